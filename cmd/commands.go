@@ -0,0 +1,578 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pochemuto/releases-watcher/internal/releaseswatcher"
+	"github.com/pochemuto/releases-watcher/sqlc"
+)
+
+type command func(ctx context.Context, app releaseswatcher.Application, args []string) error
+
+var commands = map[string]command{
+	"versions":  versionsCommand,
+	"cache":     cacheCommand,
+	"explain":   explainCommand,
+	"normalize": normalizeCommand,
+	"override":  overrideCommand,
+	"state":     stateCommand,
+	"settings":  settingsCommand,
+}
+
+func runCommand(ctx context.Context, app releaseswatcher.Application, args []string) error {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	return cmd(ctx, app, args[1:])
+}
+
+var errVersionsUsage = errors.New(`usage:
+  versions list [actual|local]
+  versions publish|unpublish|pin actual|local <version>
+  versions unpin actual|local`)
+
+func versionsCommand(ctx context.Context, app releaseswatcher.Application, args []string) error {
+	if len(args) == 0 {
+		return errVersionsUsage
+	}
+	action, args := args[0], args[1:]
+	if action == "list" {
+		kinds := []releaseswatcher.VersionKind{releaseswatcher.VersionKindActual, releaseswatcher.VersionKindLocal}
+		if len(args) > 0 {
+			kind, err := releaseswatcher.ParseVersionKind(args[0])
+			if err != nil {
+				return err
+			}
+			kinds = []releaseswatcher.VersionKind{kind}
+		}
+		return listVersions(ctx, app.DB, kinds)
+	}
+
+	if len(args) == 0 {
+		return errVersionsUsage
+	}
+	kind, err := releaseswatcher.ParseVersionKind(args[0])
+	if err != nil {
+		return err
+	}
+	if action == "unpin" {
+		if err := app.DB.UnpinVersions(ctx, kind); err != nil {
+			return fmt.Errorf("unpin %s versions error: %w", kind, err)
+		}
+		log.Infof("Unpinned %s versions, the latest published one is current", kind)
+		return nil
+	}
+
+	if len(args) < 2 {
+		return errVersionsUsage
+	}
+	id, err := strconv.ParseInt(args[1], 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid version %q: %w", args[1], err)
+	}
+	version := int32(id)
+	switch action {
+	case "publish":
+		err = app.DB.PublishVersion(ctx, kind, version)
+	case "unpublish":
+		err = app.DB.UnpublishVersion(ctx, kind, version)
+	case "pin":
+		err = app.DB.PinVersion(ctx, kind, version)
+	default:
+		return errVersionsUsage
+	}
+	if err != nil {
+		return fmt.Errorf("%s %s version %d error: %w", action, kind, version, err)
+	}
+	log.Infof("Done %s of %s version %d", action, kind, version)
+	return nil
+}
+
+func listVersions(ctx context.Context, db releaseswatcher.DB, kinds []releaseswatcher.VersionKind) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tVERSION\tCREATED\tSTATUS\tPINNED\tALBUMS")
+	for _, kind := range kinds {
+		versions, err := db.ListVersions(ctx, kind, 20)
+		if err != nil {
+			return fmt.Errorf("list %s versions error: %w", kind, err)
+		}
+		for _, v := range versions {
+			pinned := ""
+			if v.Pinned {
+				pinned = "yes"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%d\n", v.Kind, v.ID,
+				v.CreatedAt.Format("2006-01-02 15:04:05"), v.Status, pinned, v.Albums)
+		}
+	}
+	return w.Flush()
+}
+
+var errCacheUsage = errors.New(`usage:
+  cache stats
+  cache inspect <entity> <id>
+  cache invalidate <entity> [<id>|--prefix <prefix>]
+  cache export <file.jsonl.gz>
+  cache import <file.jsonl.gz>`)
+
+func cacheCommand(ctx context.Context, app releaseswatcher.Application, args []string) error {
+	if len(args) == 0 {
+		return errCacheUsage
+	}
+	cache := app.Cache
+	switch action, args := args[0], args[1:]; action {
+	case "stats":
+		return cacheStats(ctx, cache)
+	case "inspect":
+		if len(args) != 2 {
+			return errCacheUsage
+		}
+		entry, err := cache.Inspect(ctx, args[0], args[1])
+		if err != nil {
+			return fmt.Errorf("inspect %s %s error: %w", args[0], args[1], err)
+		}
+		fmt.Printf("entity:   %s\nid:       %s\nstored:   %s (%s ago)\nexpires:  %s\n",
+			args[0], args[1], entry.Ts.Format("2006-01-02 15:04:05"), time.Since(entry.Ts).Round(time.Second),
+			entry.Ts.Add(cache.TTL(args[0])).Format("2006-01-02 15:04:05"))
+		if entry.Negative {
+			fmt.Println("negative: no result")
+			return nil
+		}
+		var value bytes.Buffer
+		if err := json.Indent(&value, entry.Value, "", "  "); err != nil {
+			return fmt.Errorf("invalid value of %s %s: %w", args[0], args[1], err)
+		}
+		fmt.Println(value.String())
+		return nil
+	case "invalidate":
+		var id string
+		prefix := false
+		switch {
+		case len(args) == 1:
+			prefix = true
+		case len(args) == 2 && args[1] != "--prefix":
+			id = args[1]
+		case len(args) == 3 && args[1] == "--prefix":
+			id, prefix = args[2], true
+		default:
+			return errCacheUsage
+		}
+		count, err := cache.Invalidate(ctx, args[0], id, prefix)
+		if err != nil {
+			return fmt.Errorf("invalidate %s error: %w", args[0], err)
+		}
+		log.Infof("Invalidated %d %s entries", count, args[0])
+		return nil
+	case "export":
+		if len(args) != 1 {
+			return errCacheUsage
+		}
+		file, err := os.Create(args[0])
+		if err != nil {
+			return err
+		}
+		count, err := cache.Export(ctx, file)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("export cache error: %w", err)
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+		log.Infof("Exported %d cache entries to %s", count, args[0])
+		return nil
+	case "import":
+		if len(args) != 1 {
+			return errCacheUsage
+		}
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		count, err := cache.Import(ctx, file)
+		if err != nil {
+			return fmt.Errorf("import cache error: %w", err)
+		}
+		log.Infof("Imported %d cache entries from %s", count, args[0])
+		return nil
+	default:
+		return errCacheUsage
+	}
+}
+
+func cacheStats(ctx context.Context, cache releaseswatcher.Cache) error {
+	summaries, err := cache.Summary(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprint(w, "ENTITY\tTTL\tENTRIES\tNEGATIVE\tEXPIRED\tOLDEST\tNEWEST")
+	for _, bucket := range releaseswatcher.CacheAgeBuckets {
+		fmt.Fprintf(w, "\t<%dd", int(bucket.Hours()/24))
+	}
+	fmt.Fprintln(w, "\tOLDER")
+	for _, s := range summaries {
+		fmt.Fprintf(w, "%s\t%v\t%d\t%d\t%d\t%s\t%s", s.Entity, cache.TTL(s.Entity), s.Entries, s.Negative, s.Expired,
+			s.Oldest.Format("2006-01-02"), s.Newest.Format("2006-01-02"))
+		for _, count := range s.Ages {
+			fmt.Fprintf(w, "\t%d", count)
+		}
+		fmt.Fprintln(w)
+	}
+	return w.Flush()
+}
+
+var errExplainUsage = errors.New(`usage:
+  explain <artist> [<album>]`)
+
+func explainCommand(ctx context.Context, app releaseswatcher.Application, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errExplainUsage
+	}
+	album := ""
+	if len(args) == 2 {
+		album = args[1]
+	}
+	e, err := app.Differ.Explain(ctx, args[0], album)
+	if err != nil {
+		return fmt.Errorf("explain %s error: %w", args[0], err)
+	}
+
+	fmt.Printf("artist key:  %s\n", e.ArtistKey)
+	if album != "" {
+		fmt.Printf("album key:   %s\ntokens:      %s\n", e.AlbumKey, strings.Join(e.Tokens, " "))
+	}
+	fmt.Printf("names:       %s\n", strings.Join(e.Names, ", "))
+	if e.ExcludedArtist {
+		fmt.Println("excluded:    yes, releases of the artist are neither fetched nor reported")
+	}
+	if e.Setting != nil {
+		fmt.Printf("setting:     %s\n", e.Setting.Notification)
+	}
+	fmt.Printf("threshold:   %.2f\nsince year:  %d\n", e.Threshold, e.SinceYear)
+	if e.Collaborations {
+		fmt.Println("collabs:     included")
+	} else {
+		fmt.Println("collabs:     excluded")
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LOCAL\tKEY\tSCORE\tOVERRIDE")
+	for _, local := range e.Local {
+		override := ""
+		if local.Override != nil {
+			override = overrideRelease(*local.Override)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.2f\t%s\n", local.Album.Name, local.Key.Name, local.Score, override)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Fprintln(w, "YEAR\tKIND\tACTUAL\tKEY\tID\tDECISION")
+	for _, actual := range e.Actual {
+		year := ""
+		if actual.Album.Year != nil {
+			year = strconv.Itoa(int(*actual.Album.Year))
+		}
+		kind := ""
+		if actual.Album.Kind != nil {
+			kind = *actual.Album.Kind
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", year, kind, *actual.Album.Name, actual.Key.Name,
+			actual.Album.ID, actual.Decision)
+	}
+	return w.Flush()
+}
+
+var errNormalizeUsage = errors.New(`usage:
+  normalize <name>...`)
+
+func normalizeCommand(ctx context.Context, app releaseswatcher.Application, args []string) error {
+	if len(args) == 0 {
+		return errNormalizeUsage
+	}
+	for i, name := range args {
+		if i > 0 {
+			fmt.Println()
+		}
+		n := app.Differ.Normalize(name)
+		fmt.Printf("name:        %s\n", n.Name)
+		if len(n.Brackets) > 0 {
+			brackets := make([]string, 0, len(n.Brackets))
+			for _, bracket := range n.Brackets {
+				if bracket.Kept {
+					brackets = append(brackets, bracket.Text+" kept")
+				} else {
+					brackets = append(brackets, bracket.Text+" dropped")
+				}
+			}
+			fmt.Printf("brackets:    %s\n", strings.Join(brackets, ", "))
+		}
+		fmt.Printf("replaced:    %s\n", n.Replaced)
+		if len(n.StopWords) > 0 {
+			fmt.Printf("stop words:  %s\n", strings.Join(n.StopWords, ", "))
+		}
+		fmt.Printf("normalized:  %s\nkey:         %s\ntokens:      %s\n", n.Normalized, n.Key, strings.Join(n.Tokens, " "))
+	}
+	return nil
+}
+
+var errOverrideUsage = errors.New(`usage:
+  override list
+  override set <local artist> <local album> <release id>
+  override nomatch <local artist> <local album>
+  override delete <local artist> <local album>`)
+
+func overrideCommand(ctx context.Context, app releaseswatcher.Application, args []string) error {
+	if len(args) == 0 {
+		return errOverrideUsage
+	}
+	switch action, args := args[0], args[1:]; action {
+	case "list":
+		if len(args) != 0 {
+			return errOverrideUsage
+		}
+		return listOverrides(ctx, app)
+	case "set", "nomatch":
+		override := sqlc.MatchOverride{}
+		switch {
+		case action == "set" && len(args) == 3:
+			override.ActualID = &args[2]
+		case action == "nomatch" && len(args) == 2:
+		default:
+			return errOverrideUsage
+		}
+		override.LocalArtist, override.LocalAlbum = args[0], args[1]
+		if err := app.DB.SetMatchOverride(ctx, override); err != nil {
+			return fmt.Errorf("set override error: %w", err)
+		}
+		log.Infof("Matched %s - %s to %s", override.LocalArtist, override.LocalAlbum, overrideRelease(override))
+		return nil
+	case "delete":
+		if len(args) != 2 {
+			return errOverrideUsage
+		}
+		count, err := app.DB.DeleteMatchOverride(ctx, args[0], args[1])
+		if err != nil {
+			return fmt.Errorf("delete override error: %w", err)
+		}
+		if count == 0 {
+			return fmt.Errorf("no override of %s - %s", args[0], args[1])
+		}
+		log.Infof("Deleted override of %s - %s", args[0], args[1])
+		return nil
+	default:
+		return errOverrideUsage
+	}
+}
+
+func listOverrides(ctx context.Context, app releaseswatcher.Application) error {
+	stored, err := app.DB.GetMatchOverrides(ctx)
+	if err != nil {
+		return fmt.Errorf("list overrides error: %w", err)
+	}
+	var sheet []sqlc.MatchOverride
+	if source, ok := app.Settings.(releaseswatcher.MatchOverrideSource); ok {
+		sheet, err = source.GetMatchOverrides(ctx)
+		if err != nil {
+			return fmt.Errorf("list overrides from %s error: %w", app.Settings.Name(), err)
+		}
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE\tARTIST\tALBUM\tRELEASE")
+	for _, o := range stored {
+		fmt.Fprintf(w, "table\t%s\t%s\t%s\n", o.LocalArtist, o.LocalAlbum, overrideRelease(o))
+	}
+	for _, o := range sheet {
+		fmt.Fprintf(w, "sheet\t%s\t%s\t%s\n", o.LocalArtist, o.LocalAlbum, overrideRelease(o))
+	}
+	return w.Flush()
+}
+
+func overrideRelease(override sqlc.MatchOverride) string {
+	if override.ActualID == nil {
+		return "no match"
+	}
+	return *override.ActualID
+}
+
+var errStateUsage = errors.New(`usage:
+  state list
+  state set <release id> wishlist|ignored|acquired
+  state snooze <release id> <yyyy-mm-dd>
+  state clear <release id>`)
+
+func stateCommand(ctx context.Context, app releaseswatcher.Application, args []string) error {
+	if len(args) == 0 {
+		return errStateUsage
+	}
+	switch action, args := args[0], args[1:]; action {
+	case "list":
+		if len(args) != 0 {
+			return errStateUsage
+		}
+		states, err := app.DB.GetReleaseStates(ctx)
+		if err != nil {
+			return fmt.Errorf("list states error: %w", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "RELEASE\tSTATE\tUNTIL")
+		for _, s := range states {
+			until := ""
+			if s.Until.Valid {
+				until = s.Until.Time.Format(time.DateOnly)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", s.ActualID, s.State, until)
+		}
+		return w.Flush()
+	case "set", "snooze":
+		state := sqlc.ReleaseState{}
+		switch {
+		case action == "set" && len(args) == 2:
+			lifecycle, err := releaseswatcher.ParseLifecycle(args[1])
+			if err != nil {
+				return err
+			}
+			if lifecycle == releaseswatcher.LifecycleSnoozed {
+				return errStateUsage
+			}
+			state.State = string(lifecycle)
+		case action == "snooze" && len(args) == 2:
+			until, err := time.Parse(time.DateOnly, args[1])
+			if err != nil {
+				return fmt.Errorf("invalid date %q: %w", args[1], err)
+			}
+			state.State = string(releaseswatcher.LifecycleSnoozed)
+			state.Until = pgtype.Date{Time: until, Valid: true}
+		default:
+			return errStateUsage
+		}
+		state.ActualID = args[0]
+		if err := app.DB.SetReleaseState(ctx, state); err != nil {
+			return fmt.Errorf("set state error: %w", err)
+		}
+		log.Infof("Set release %s to %s", state.ActualID, state.State)
+		return nil
+	case "clear":
+		if len(args) != 1 {
+			return errStateUsage
+		}
+		count, err := app.DB.DeleteReleaseState(ctx, args[0])
+		if err != nil {
+			return fmt.Errorf("clear state error: %w", err)
+		}
+		if count == 0 {
+			return fmt.Errorf("no state of release %s", args[0])
+		}
+		log.Infof("Cleared state of release %s", args[0])
+		return nil
+	default:
+		return errStateUsage
+	}
+}
+
+var errSettingsUsage = errors.New(`usage:
+  settings list
+  settings set <artist> <notification> [<since>] [<collaborations>]`)
+
+func settingsCommand(ctx context.Context, app releaseswatcher.Application, args []string) error {
+	if len(args) == 0 {
+		return errSettingsUsage
+	}
+	switch action, args := args[0], args[1:]; action {
+	case "list":
+		if len(args) != 0 {
+			return errSettingsUsage
+		}
+		settings, err := app.Settings.GetArtistSettings(ctx)
+		if err != nil {
+			return fmt.Errorf("list settings from %s error: %w", app.Settings.Name(), err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ARTIST\tNOTIFICATION\tSINCE\tCOLLABORATIONS")
+		for _, s := range settings {
+			since := ""
+			if !s.Since.IsZero() {
+				since = s.Since.Format("2006")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.ArtistName, s.Notification, since, s.Collaborations)
+		}
+		return w.Flush()
+	case "set":
+		if len(args) < 2 || len(args) > 4 {
+			return errSettingsUsage
+		}
+		store, ok := app.Settings.(releaseswatcher.DBArtistSettings)
+		if !ok {
+			return fmt.Errorf("artist settings are kept in %s, edit them there", app.Settings.Name())
+		}
+		values := make([]string, 4)
+		copy(values, args)
+		setting, err := releaseswatcher.ParseArtistSetting(values[0], values[1], values[2], values[3])
+		if err != nil {
+			return err
+		}
+		if err := store.SetArtistSetting(ctx, setting); err != nil {
+			return fmt.Errorf("set setting error: %w", err)
+		}
+		log.Infof("Set %s to %s", setting.ArtistName, setting.Notification)
+		return nil
+	default:
+		return errSettingsUsage
+	}
+}
+
+var errMigrateUsage = errors.New(`usage:
+  migrate up
+  migrate status`)
+
+func migrateCommand(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errMigrateUsage
+	}
+	migrator, err := releaseswatcher.InitializeMigrator(ctx)
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "up":
+		count, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		log.Infof("Applied %d migrations", count)
+		return nil
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, applied)
+		}
+		return w.Flush()
+	default:
+		return errMigrateUsage
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"flag"
 	"io/fs"
+	"os"
 	"os/signal"
 	"syscall"
 
@@ -26,8 +27,14 @@ func init() {
 func run(ctx context.Context) {
 	updateLocal := flag.Bool("update-local", false, "Update local library")
 	updateActual := flag.Bool("update-actual", false, "Update actual library")
+	resumeActual := flag.Bool("resume-actual", false, "Resume the latest aborted or failed actual library update")
+	dryRun := flag.Bool("dry-run", false, "With -update-actual fetch the actual library from recorded API responses without writing a version")
+	cleanupVersions := flag.Bool("cleanup-versions", false, "Remove abandoned unpublished versions")
+	warmUp := flag.Bool("warm-up", false, "Keep refreshing cache entries approaching expiry until stopped")
+	refreshStale := flag.Int("refresh-stale", 0, "Refresh up to N expired cache entries, oldest first")
 	diff := flag.Bool("diff", false, "Print diff")
-	updateSettings := flag.Bool("update-settings", false, "Write local artists to Google Sheets settings")
+	showFiltered := flag.Bool("show-filtered", false, "With -diff write filtered out releases to the sheet with the reason")
+	updateSettings := flag.Bool("update-settings", false, "Write local artists to the artist settings")
 	flag.Parse()
 
 	err := godotenv.Load()
@@ -35,32 +42,85 @@ func run(ctx context.Context) {
 		log.Fatalf("error loading .env file: %v", err)
 	}
 
+	if *dryRun && os.Getenv("HTTP_MODE") == "" {
+		os.Setenv("HTTP_MODE", string(releaseswatcher.HTTPModeReplay))
+	}
+	if *dryRun {
+		os.Setenv("CACHE_READ_ONLY", "true")
+	}
+
+	if flag.Arg(0) == "migrate" {
+		if err := migrateCommand(ctx, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	app, err := releaseswatcher.InitializeApplication(ctx)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer app.Close()
+	if flag.NArg() > 0 {
+		if err := runCommand(ctx, app, flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	watcher, differ := app.Watcher, app.Differ
+	// stale entries are refreshed in the background only while the actual library is updated,
+	// other modes finish too soon, and the warm-up refreshes them itself
+	if (*updateActual || *resumeActual) && !*warmUp {
+		go app.Cache.RunBackgroundRefresh(ctx)
+	}
+	if *cleanupVersions {
+		count, err := app.DB.CleanupVersions(ctx)
+		if err != nil {
+			log.Fatalf("cleanup versions error: %v", err)
+		}
+		log.Infof("Removed %d abandoned versions", count)
+	}
+	if *refreshStale > 0 {
+		count, err := app.Cache.RefreshStale(ctx, int32(*refreshStale))
+		if err != nil {
+			log.Fatalf("refresh stale cache error: %v", err)
+		}
+		log.Infof("Refreshed %d stale cache entries", count)
+	}
 	if *updateLocal {
 		err = watcher.UpdateLocalLibrary(ctx)
 		if err != nil {
 			log.Fatalf("update local library error: %v", err)
 		}
 	}
-	if *updateActual {
+	if *updateActual && *dryRun {
+		count, err := watcher.DryRunActualLibrary(ctx)
+		if err != nil {
+			log.Fatalf("dry run of actual library error: %v", err)
+		}
+		log.Infof("Fetched %d actual albums, nothing is written", count)
+	} else if *updateActual {
 		err = watcher.UpdateActualLibrary(ctx)
 		if err != nil {
 			log.Fatalf("update actual library error: %v", err)
 		}
 	}
+	if *resumeActual {
+		err = watcher.ResumeActualLibrary(ctx)
+		if err != nil {
+			log.Fatalf("resume actual library error: %v", err)
+		}
+	}
 
 	if *updateSettings {
 		artists, err := app.DB.GetLocalArtists(ctx)
 		if err != nil {
 			log.Fatalf("load local artists error: %v", err)
 		}
-		err = app.Sheets.UpdateArtistsInSettings(ctx, artists)
+		err = app.Settings.UpdateArtistsInSettings(ctx, artists)
 		if err != nil {
-			log.Fatalf("update settings in sheet error: %v", err)
+			log.Fatalf("update settings in %s error: %v", app.Settings.Name(), err)
 		}
 	}
 
@@ -70,20 +130,39 @@ func run(ctx context.Context) {
 			log.Fatalf("error making diff: %v", err)
 		}
 		releaseCount := 0
+		releases := make([]releaseswatcher.MatchedAlbum, 0, len(matched))
 		for _, release := range matched {
-			releaseCount++
-			if release.Local == nil {
+			if release.Filtered != "" {
+				log.Tracef("Filtered out (%s): %s - %s", release.Filtered, *release.Actual.Artist, *release.Actual.Name)
+				if !*showFiltered {
+					continue
+				}
+			} else if release.Local == nil {
+				releaseCount++
 				actual := release.Actual
 				log.Infof("New album: [%v] %s - %s (%s)  https://musicbrainz.org/release/%v",
 					actual.Year,
 					*actual.Artist, *actual.Name, *actual.Kind, actual.ID)
 			}
+			releases = append(releases, release)
 		}
-		if err = app.Sheets.UpdateReleases(ctx, matched); err != nil {
-			log.Errorf("Error updating releases: %v", err)
+		if app.Sheets != nil {
+			if err = app.Sheets.UpdateReleases(ctx, releases); err != nil {
+				log.Errorf("Error updating releases: %v", err)
+			}
 		}
 		log.Infof("Found %d new albums", releaseCount)
 	}
+	if *warmUp {
+		app.Scheduler.Run(ctx)
+	}
+	for entity, stats := range app.Cache.Stats() {
+		log.Infof("Cache %s: %d memory hits, %d storage hits, %d misses, %d fetches, %d shared",
+			entity, stats.MemoryHits, stats.StorageHits, stats.Misses, stats.Fetches, stats.Shared)
+		if stats.Stale > 0 {
+			log.Warnf("Served %d stale %s entries", stats.Stale, entity)
+		}
+	}
 	log.Info("Done")
 }
 
@@ -0,0 +1,382 @@
+package releaseswatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pochemuto/releases-watcher/sqlc"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	settingsStoreSheets = "sheets"
+	settingsStoreDB     = "db"
+	settingsStoreYAML   = "yaml"
+)
+
+type ArtistSettingsConfig struct {
+	// Store keeps the settings: "sheets", "db" or "yaml"
+	Store string `envDefault:"sheets"`
+	// File is the settings file of the "yaml" store
+	File string `envDefault:"artist-settings.yaml"`
+}
+
+// ArtistSettingsStore keeps the settings of the followed artists.
+type ArtistSettingsStore interface {
+	GetArtistSettings(ctx context.Context) ([]ArtistSetting, error)
+	// UpdateArtistsInSettings lists the artists, the settings of the known ones are kept,
+	// the ones missing in the list are removed
+	UpdateArtistsInSettings(ctx context.Context, artists []string) error
+	Name() string
+}
+
+// MatchOverrideSource is implemented by settings stores which keep match overrides too,
+// like the overrides sheet next to the settings sheet.
+type MatchOverrideSource interface {
+	GetMatchOverrides(ctx context.Context) ([]sqlc.MatchOverride, error)
+}
+
+func NewArtistSettingsStore(config ArtistSettingsConfig, db DB, sheets *GoogleSheets) (ArtistSettingsStore, error) {
+	switch config.Store {
+	case settingsStoreSheets:
+		if sheets == nil {
+			return nil, errors.New("artist settings are kept in Google Sheets, but Google Sheets are disabled")
+		}
+		return sheets, nil
+	case settingsStoreDB:
+		return NewDBArtistSettings(db), nil
+	case settingsStoreYAML:
+		return NewYAMLArtistSettings(config.File), nil
+	default:
+		return nil, fmt.Errorf("unknown artist settings store %q, expected %q, %q or %q", config.Store,
+			settingsStoreSheets, settingsStoreDB, settingsStoreYAML)
+	}
+}
+
+type NotificationSetting string
+
+const (
+	NotificationAllReleases NotificationSetting = "Все релизы"
+	NotificationAlbumsAndEP NotificationSetting = "Альбомы и EP"
+	NotificationAlbumsOnly  NotificationSetting = "Альбомы"
+	NotificationDoNotTrack  NotificationSetting = "Не отслеживать"
+)
+
+var notificationScope = map[NotificationSetting]map[Kind]bool{
+	NotificationAllReleases: {
+		KindAlbum:       true,
+		KindEP:          true,
+		KindSingle:      true,
+		KindBroadcast:   true,
+		KindOther:       true,
+		KindLive:        true,
+		KindCompilation: true,
+		KindUnknown:     true,
+	},
+	NotificationAlbumsAndEP: {
+		KindAlbum: true,
+		KindEP:    true,
+	},
+	NotificationAlbumsOnly: {
+		KindAlbum: true,
+	},
+}
+
+func (n NotificationSetting) IsReleaseInScope(kind Kind) bool {
+	return notificationScope[n][kind]
+}
+
+func (n NotificationSetting) String() string {
+	return string(n)
+}
+
+func parseNotificationSetting(raw string) (NotificationSetting, error) {
+	value := strings.TrimSpace(raw)
+	switch value {
+	case "", string(NotificationAllReleases):
+		return NotificationAllReleases, nil
+	case string(NotificationAlbumsAndEP):
+		return NotificationAlbumsAndEP, nil
+	case string(NotificationDoNotTrack):
+		return NotificationDoNotTrack, nil
+	case string(NotificationAlbumsOnly):
+		return NotificationAlbumsOnly, nil
+	default:
+		return "", fmt.Errorf("unknown notification value %q", raw)
+	}
+}
+
+// CollaborationSetting tells whether releases credited to the artist together with others are reported.
+type CollaborationSetting string
+
+const (
+	// CollaborationsDefault follows DifferConfig.Collaborations
+	CollaborationsDefault CollaborationSetting = ""
+	CollaborationsInclude CollaborationSetting = "Включать"
+	CollaborationsExclude CollaborationSetting = "Исключать"
+)
+
+func parseCollaborationSetting(raw string) (CollaborationSetting, error) {
+	switch value := CollaborationSetting(strings.TrimSpace(raw)); value {
+	case CollaborationsDefault, CollaborationsInclude, CollaborationsExclude:
+		return value, nil
+	default:
+		return "", fmt.Errorf("unknown collaborations value %q, expected %q, %q or empty", raw,
+			CollaborationsInclude, CollaborationsExclude)
+	}
+}
+
+type ArtistSetting struct {
+	ArtistName   string
+	Notification NotificationSetting
+	// Since is January 1 of the earliest release year of interest, zero for DifferConfig.CutoffYear.
+	// Releases are only known to the year, so it takes no month or day.
+	Since time.Time
+	// Collaborations overrides DifferConfig.Collaborations for the artist
+	Collaborations CollaborationSetting
+}
+
+// parseSince parses the year of ArtistSetting.Since written by hand.
+func parseSince(raw string) (time.Time, error) {
+	value := strings.TrimSpace(raw)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	since, err := time.Parse("2006", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid since %q, expected a year like 1985", raw)
+	}
+	return since, nil
+}
+
+// formatSince writes the year of ArtistSetting.Since.
+func formatSince(since time.Time) string {
+	if since.IsZero() {
+		return ""
+	}
+	return since.Format("2006")
+}
+
+// ParseArtistSetting parses the values written like in the settings sheet, empty ones are the defaults.
+func ParseArtistSetting(artist string, notification string, since string, collaborations string) (ArtistSetting, error) {
+	setting := ArtistSetting{ArtistName: artist}
+	var err error
+	if setting.Notification, err = parseNotificationSetting(notification); err != nil {
+		return ArtistSetting{}, fmt.Errorf("artist %s: %w", artist, err)
+	}
+	if setting.Since, err = parseSince(since); err != nil {
+		return ArtistSetting{}, fmt.Errorf("artist %s: %w", artist, err)
+	}
+	if setting.Collaborations, err = parseCollaborationSetting(collaborations); err != nil {
+		return ArtistSetting{}, fmt.Errorf("artist %s: %w", artist, err)
+	}
+	return setting, nil
+}
+
+// mergeArtistSettings returns the settings of the artists sorted by name, new artists get all releases.
+func mergeArtistSettings(settings []ArtistSetting, artists []string) []ArtistSetting {
+	existing := make(map[string]ArtistSetting, len(settings))
+	for _, setting := range settings {
+		existing[setting.ArtistName] = setting
+	}
+
+	uniqueArtists := make([]string, 0, len(artists))
+	seen := make(map[string]struct{}, len(artists))
+	for _, artist := range artists {
+		name := strings.TrimSpace(artist)
+		if name == "" {
+			continue
+		}
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		uniqueArtists = append(uniqueArtists, name)
+	}
+	sort.Strings(uniqueArtists)
+
+	result := make([]ArtistSetting, 0, len(uniqueArtists))
+	for _, artist := range uniqueArtists {
+		setting, ok := existing[artist]
+		if !ok {
+			setting = ArtistSetting{ArtistName: artist, Notification: NotificationAllReleases}
+		}
+		result = append(result, setting)
+	}
+	return result
+}
+
+// DBArtistSettings keeps the settings in the artist_setting table.
+type DBArtistSettings struct {
+	db DB
+}
+
+func NewDBArtistSettings(db DB) DBArtistSettings {
+	return DBArtistSettings{db: db}
+}
+
+func (s DBArtistSettings) Name() string {
+	return "the database"
+}
+
+func (s DBArtistSettings) GetArtistSettings(ctx context.Context) ([]ArtistSetting, error) {
+	rows, err := s.db.GetArtistSettings(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetch settings: %w", err)
+	}
+	settings := make([]ArtistSetting, 0, len(rows))
+	for _, row := range rows {
+		notification, err := parseNotificationSetting(row.Notification)
+		if err != nil {
+			return nil, fmt.Errorf("artist %s: %w", row.Artist, err)
+		}
+		collaborations, err := parseCollaborationSetting(row.Collaborations)
+		if err != nil {
+			return nil, fmt.Errorf("artist %s: %w", row.Artist, err)
+		}
+		setting := ArtistSetting{ArtistName: row.Artist, Notification: notification, Collaborations: collaborations}
+		if row.Since.Valid {
+			setting.Since = row.Since.Time
+		}
+		settings = append(settings, setting)
+	}
+	return settings, nil
+}
+
+func artistSettingRow(setting ArtistSetting) sqlc.ArtistSetting {
+	return sqlc.ArtistSetting{
+		Artist:         setting.ArtistName,
+		Notification:   setting.Notification.String(),
+		Since:          pgtype.Date{Time: setting.Since, Valid: !setting.Since.IsZero()},
+		Collaborations: string(setting.Collaborations),
+	}
+}
+
+// SetArtistSetting inserts the setting of the artist or replaces it.
+func (s DBArtistSettings) SetArtistSetting(ctx context.Context, setting ArtistSetting) error {
+	return s.db.SetArtistSetting(ctx, artistSettingRow(setting))
+}
+
+// UpdateArtistsInSettings replaces the settings in a single transaction.
+func (s DBArtistSettings) UpdateArtistsInSettings(ctx context.Context, artists []string) error {
+	settings, err := s.GetArtistSettings(ctx)
+	if err != nil {
+		return err
+	}
+	merged := mergeArtistSettings(settings, artists)
+	rows := make([]sqlc.ArtistSetting, 0, len(merged))
+	for _, setting := range merged {
+		rows = append(rows, artistSettingRow(setting))
+	}
+	if err := s.db.ReplaceArtistSettings(ctx, rows); err != nil {
+		return fmt.Errorf("update settings: %w", err)
+	}
+	return nil
+}
+
+// YAMLArtistSettings keeps the settings in a file edited by hand, a missing file has no settings.
+type YAMLArtistSettings struct {
+	path string
+}
+
+// yamlArtistSetting is an entry of the settings file, the values are written like in the settings sheet
+type yamlArtistSetting struct {
+	Artist         string `yaml:"artist"`
+	Notification   string `yaml:"notification,omitempty"`
+	Since          string `yaml:"since,omitempty"`
+	Collaborations string `yaml:"collaborations,omitempty"`
+}
+
+func NewYAMLArtistSettings(path string) YAMLArtistSettings {
+	return YAMLArtistSettings{path: path}
+}
+
+func (s YAMLArtistSettings) Name() string {
+	return s.path
+}
+
+func (s YAMLArtistSettings) GetArtistSettings(ctx context.Context) ([]ArtistSetting, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read settings: %w", err)
+	}
+	var entries []yamlArtistSetting
+	if err := yaml.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parse settings %s: %w", s.path, err)
+	}
+	settings := make([]ArtistSetting, 0, len(entries))
+	for idx, entry := range entries {
+		artist := strings.TrimSpace(entry.Artist)
+		if artist == "" {
+			return nil, fmt.Errorf("entry %d: artist is required", idx+1)
+		}
+		setting, err := ParseArtistSetting(artist, entry.Notification, entry.Since, entry.Collaborations)
+		if err != nil {
+			return nil, err
+		}
+		settings = append(settings, setting)
+	}
+	return settings, nil
+}
+
+// UpdateArtistsInSettings rewrites the file, comments and formatting of the file are not kept.
+// The file is replaced at once, a failed write leaves the previous one.
+func (s YAMLArtistSettings) UpdateArtistsInSettings(ctx context.Context, artists []string) error {
+	settings, err := s.GetArtistSettings(ctx)
+	if err != nil {
+		return err
+	}
+	merged := mergeArtistSettings(settings, artists)
+	entries := make([]yamlArtistSetting, 0, len(merged))
+	for _, setting := range merged {
+		entries = append(entries, yamlArtistSetting{
+			Artist:         setting.ArtistName,
+			Notification:   setting.Notification.String(),
+			Since:          formatSince(setting.Since),
+			Collaborations: string(setting.Collaborations),
+		})
+	}
+	data, err := yaml.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("encode settings: %w", err)
+	}
+	if err := writeFileAtomic(s.path, data, 0o644); err != nil {
+		return fmt.Errorf("write settings: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes the data to a temporary file next to the file and renames it over the file.
+func writeFileAtomic(path string, data []byte, perm fs.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
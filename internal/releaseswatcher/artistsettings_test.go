@@ -0,0 +1,66 @@
+package releaseswatcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestYAMLArtistSettings(t *testing.T) {
+	ctx := context.Background()
+	settings := NewYAMLArtistSettings(filepath.Join(t.TempDir(), "artist-settings.yaml"))
+
+	stored, err := settings.GetArtistSettings(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, stored, "a missing file has no settings")
+
+	require.NoError(t, settings.UpdateArtistsInSettings(ctx, []string{"Кино", "ДДТ", "Кино"}))
+	require.NoError(t, os.WriteFile(settings.path, []byte(`
+- artist: Кино
+  notification: Альбомы
+  since: 1985
+  collaborations: Исключать
+- artist: ДДТ
+`), 0o644))
+	require.NoError(t, settings.UpdateArtistsInSettings(ctx, []string{"Аквариум", "Кино"}))
+
+	stored, err = settings.GetArtistSettings(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []ArtistSetting{
+		{ArtistName: "Аквариум", Notification: NotificationAllReleases},
+		{
+			ArtistName:     "Кино",
+			Notification:   NotificationAlbumsOnly,
+			Since:          time.Date(1985, time.January, 1, 0, 0, 0, 0, time.UTC),
+			Collaborations: CollaborationsExclude,
+		},
+	}, stored)
+
+	files, err := os.ReadDir(filepath.Dir(settings.path))
+	require.NoError(t, err)
+	require.Len(t, files, 1, "the temporary file is renamed over the settings")
+	info, err := files[0].Info()
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+
+	require.NoError(t, os.WriteFile(settings.path, []byte("- artist: Кино\n  notification: Иногда\n"), 0o644))
+	_, err = settings.GetArtistSettings(ctx)
+	assert.Error(t, err)
+}
+
+func TestNewArtistSettingsStore(t *testing.T) {
+	_, err := NewArtistSettingsStore(ArtistSettingsConfig{Store: settingsStoreSheets}, DB{}, nil)
+	assert.Error(t, err, "Google Sheets are disabled")
+	_, err = NewArtistSettingsStore(ArtistSettingsConfig{Store: "excel"}, DB{}, nil)
+	assert.Error(t, err)
+
+	store, err := NewArtistSettingsStore(ArtistSettingsConfig{Store: settingsStoreYAML, File: "settings.yaml"}, DB{}, nil)
+	require.NoError(t, err)
+	_, ok := store.(MatchOverrideSource)
+	assert.False(t, ok, "match overrides are read from the sheet only")
+}
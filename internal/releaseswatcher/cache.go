@@ -4,29 +4,188 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"sort"
+	"sync"
 	"time"
 
-	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgtype"
-	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/pochemuto/releases-watcher/sqlc"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"golang.org/x/sync/singleflight"
 )
 
+// ErrNoResult is returned by a fetcher when the lookup found nothing. Unlike other
+// errors it is cached as a negative entry for the negative TTL.
+var ErrNoResult = errors.New("no result")
+
+// Cache entities, the names are stored in the cache table
+const (
+	EntityMusicBrainzRelease              = "musicbrainz_release"
+	EntityMusicBrainzArtistSearch         = "musicbrainz_artist_search"
+	EntityMusicBrainzArtistReleaseGroups  = "musicbrainz_artist_releasegroups"
+	EntityMusicBrainzReleaseGroup         = "musicbrainz_releasegroup"
+	EntityMusicBrainzReleaseGroupEditions = "musicbrainz_releasegroup_editions"
+	EntityDiscogsRelease                  = "discogs_release"
+	EntityDiscogsArtistSearch             = "discogs_artist_search"
+	EntityDiscogsArtistReleases           = "discord_artist_releases"
+)
+
+// defaultCacheTTL is used for entities missing in the policy
+const defaultCacheTTL = 7 * 24 * time.Hour
+
+// CachePolicy maps a cache entity to the time its entries stay fresh.
+type CachePolicy map[string]time.Duration
+
+var defaultCachePolicy = CachePolicy{
+	EntityMusicBrainzRelease:              days(7),
+	EntityMusicBrainzArtistSearch:         days(90),
+	EntityMusicBrainzArtistReleaseGroups:  days(7),
+	EntityMusicBrainzReleaseGroup:         days(30),
+	EntityMusicBrainzReleaseGroupEditions: days(30),
+	EntityDiscogsRelease:                  days(10),
+	EntityDiscogsArtistSearch:             days(10),
+	EntityDiscogsArtistReleases:           days(10),
+}
+
+type CacheConfig struct {
+	// TTL overrides the default policy, e.g. "musicbrainz_release:72h,discogs_release:480h"
+	TTL map[string]time.Duration `envDefault:""`
+	// NegativeTTL is how long a lookup without a result is remembered,
+	// it is never longer than the TTL of the entity
+	NegativeTTL time.Duration `envDefault:"24h"`
+	// ServeStale returns an expired entry when fetching a new value fails
+	ServeStale bool `envDefault:"false"`
+	// MaxStale limits how long after expiry an entry can be served, 0 means no limit
+	MaxStale time.Duration `envDefault:"0"`
+	// RefreshInterval enables the background refresh of stale entries while the application runs
+	RefreshInterval time.Duration `envDefault:"0"`
+	// RefreshBatch is the number of stale entries refreshed at once
+	RefreshBatch int32 `envDefault:"100"`
+	// MemorySize is the number of entries kept in memory in front of the database, 0 disables it
+	MemorySize int `envDefault:"10000"`
+	// ReadOnly keeps fetched values in memory only, the stored entries are left as they are
+	ReadOnly bool `envDefault:"false"`
+}
+
+// Refresher fetches the entity with the id and stores it in the cache.
+type Refresher func(ctx context.Context, id string) error
+
+// CacheStats are counters of a single entity.
+type CacheStats struct {
+	// MemoryHits are fresh entries found in memory
+	MemoryHits int64
+	// StorageHits are fresh entries found in the database
+	StorageHits int64
+	// Misses are lookups without a fresh entry in both tiers
+	Misses int64
+	// Fetches are requests to the provider, including failed ones
+	Fetches int64
+	// Shared are lookups that waited for the same lookup already in progress
+	Shared int64
+	// Stale is the number of expired entries served because fetching failed
+	Stale int64
+}
+
+type cacheStats struct {
+	mu       sync.Mutex
+	entities map[string]*CacheStats
+}
+
+func (s *cacheStats) update(entity string, fn func(stats *CacheStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats, ok := s.entities[entity]
+	if !ok {
+		stats = &CacheStats{}
+		s.entities[entity] = stats
+	}
+	fn(stats)
+}
+
 type Cache struct {
-	conn    *pgxpool.Pool
-	queries *sqlc.Queries
+	store           Storage
+	policy          CachePolicy
+	negativeTTL     time.Duration
+	serveStale      bool
+	maxStale        time.Duration
+	refreshInterval time.Duration
+	refreshBatch    int32
+	refreshers      map[string]Refresher
+	stats           *cacheStats
+	memory          *lru.Cache[string, CacheEntry]
+	inflight        *singleflight.Group
+	readOnly        bool
 }
 
-func NewCache(conn *pgxpool.Pool) Cache {
+func NewCache(store Storage, config CacheConfig) Cache {
+	policy := make(CachePolicy, len(defaultCachePolicy)+len(config.TTL))
+	for entity, ttl := range defaultCachePolicy {
+		policy[entity] = ttl
+	}
+	for entity, ttl := range config.TTL {
+		policy[entity] = ttl
+	}
+	var memory *lru.Cache[string, CacheEntry]
+	if config.MemorySize > 0 {
+		// the error is returned only for a non-positive size
+		memory, _ = lru.New[string, CacheEntry](config.MemorySize)
+	}
 	return Cache{
-		conn:    conn,
-		queries: sqlc.New(conn),
+		store:           store,
+		policy:          policy,
+		negativeTTL:     config.NegativeTTL,
+		serveStale:      config.ServeStale,
+		maxStale:        config.MaxStale,
+		refreshInterval: config.RefreshInterval,
+		refreshBatch:    config.RefreshBatch,
+		refreshers:      make(map[string]Refresher),
+		stats:           &cacheStats{entities: make(map[string]*CacheStats)},
+		memory:          memory,
+		inflight:        &singleflight.Group{},
+		readOnly:        config.ReadOnly,
+	}
+}
+
+// RegisterRefresher makes stale entries of the entity refreshable in the background.
+func (c Cache) RegisterRefresher(entity string, refresher Refresher) {
+	c.refreshers[entity] = refresher
+}
+
+// Stats returns counters of every entity used since the start.
+func (c Cache) Stats() map[string]CacheStats {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+	result := make(map[string]CacheStats, len(c.stats.entities))
+	for entity, stats := range c.stats.entities {
+		result[entity] = *stats
+	}
+	return result
+}
+
+// TTL returns how long entries of the entity stay fresh.
+func (c Cache) TTL(entity string) time.Duration {
+	if ttl, ok := c.policy[entity]; ok {
+		return ttl
+	}
+	log.Warnf("No cache policy for %s, using %v", entity, defaultCacheTTL)
+	return defaultCacheTTL
+}
+
+// NegativeTTL returns how long a lookup of the entity without a result is remembered.
+func (c Cache) NegativeTTL(entity string) time.Duration {
+	return min(c.negativeTTL, c.TTL(entity))
+}
+
+func (c Cache) isFresh(entity string, entry CacheEntry) bool {
+	ttl := c.TTL(entity)
+	if entry.Negative {
+		ttl = c.NegativeTTL(entity)
 	}
+	return time.Since(entry.Ts) <= ttl
 }
 
-func GetAllCacheEntities[T any](c Cache, ctx context.Context,
-	entity string, freshness time.Duration) (map[string]*T, error) {
-	rows, err := c.getAllCacheEntities(ctx, entity, freshness)
+// GetAllCacheEntities loads all fresh entries of the entity, negative ones are skipped.
+func GetAllCacheEntities[T any](c Cache, ctx context.Context, entity string) (map[string]*T, error) {
+	rows, err := c.getAllCacheEntities(ctx, entity)
 	if err != nil {
 		return nil, err
 	}
@@ -40,70 +199,238 @@ func GetAllCacheEntities[T any](c Cache, ctx context.Context,
 }
 
 func GetCached[T any](c Cache, ctx context.Context,
-	entity string, id string, freshness time.Duration, fetcher func() (*T, error)) (*T, error) {
-	byte_fetcher := func() ([]byte, error) {
+	entity string, id string, fetcher func() (*T, error)) (*T, error) {
+	data, err := c.getEntity(ctx, entity, id, byteFetcher(fetcher))
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalCached[T](data)
+}
+
+// RefreshCached fetches the value and stores it regardless of the cached one.
+func RefreshCached[T any](c Cache, ctx context.Context,
+	entity string, id string, fetcher func() (*T, error)) (*T, error) {
+	data, err := c.fetchEntity(ctx, entity, id, byteFetcher(fetcher))
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalCached[T](data)
+}
+
+func byteFetcher[T any](fetcher func() (*T, error)) func() ([]byte, error) {
+	return func() ([]byte, error) {
 		data, err := fetcher()
 		if err != nil {
 			return nil, err
 		}
 		return json.Marshal(data)
 	}
+}
 
-	data, err := c.getEntity(ctx, entity, id, freshness, byte_fetcher)
-	if err != nil {
-		return nil, err
-	}
+func unmarshalCached[T any](data []byte) (*T, error) {
 	result := new(T)
-	err = json.Unmarshal(data, result)
+	err := json.Unmarshal(data, result)
 	if err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
-func (c Cache) getAllCacheEntities(ctx context.Context,
-	entity string, freshness time.Duration) (map[string][]byte, error) {
-	// use iterator
-	// https://github.com/sqlc-dev/sqlc/issues/720
-	rows, err := c.queries.GetAll(ctx, entity)
-	if err != nil {
-		return nil, err
+func (c Cache) getAllCacheEntities(ctx context.Context, entity string) (map[string][]byte, error) {
+	return c.store.GetAllCache(ctx, entity, time.Now().Add(-c.TTL(entity)))
+}
+
+func cacheKey(entity string, id string) string {
+	return entity + "/" + id
+}
+
+func (c Cache) remember(entity string, id string, entry CacheEntry) {
+	if c.memory != nil {
+		c.memory.Add(cacheKey(entity, id), entry)
 	}
+}
 
-	result := make(map[string][]byte)
-	for _, row := range rows {
-		result[row.ID] = row.Value
+func entryValue(entity string, id string, entry CacheEntry) ([]byte, error) {
+	if entry.Negative {
+		return nil, fmt.Errorf("%w for %s %s (cached)", ErrNoResult, entity, id)
 	}
-	return result, nil
+	return entry.Value, nil
 }
 
+// getEntity looks up the memory first, then the database, and fetches the value at last.
+// Concurrent lookups of the same entry share a single database query and fetch. The shared
+// lookup is not cancelled with the context of the caller which started it, a cancelled caller
+// stops waiting for it.
 func (c Cache) getEntity(ctx context.Context,
-	entity string, id string, freshness time.Duration, fetcher func() ([]byte, error)) ([]byte, error) {
-	result, err := c.queries.GetCache(ctx, sqlc.GetCacheParams{
-		Entity: entity,
-		ID:     id,
-		Ts:     pgtype.Timestamp{Time: time.Now().Add(-freshness), Valid: true},
+	entity string, id string, fetcher func() ([]byte, error)) ([]byte, error) {
+	key := cacheKey(entity, id)
+	if c.memory != nil {
+		if entry, ok := c.memory.Get(key); ok && c.isFresh(entity, entry) {
+			c.stats.update(entity, func(stats *CacheStats) { stats.MemoryHits++ })
+			return entryValue(entity, id, entry)
+		}
+	}
+	executed := false
+	flight := c.inflight.DoChan(key, func() (any, error) {
+		executed = true
+		return c.loadEntity(context.WithoutCancel(ctx), entity, id, fetcher)
 	})
-	if err != nil {
-		if !errors.Is(err, pgx.ErrNoRows) {
+	var result singleflight.Result
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result = <-flight:
+	}
+	if !executed {
+		c.stats.update(entity, func(stats *CacheStats) { stats.Shared++ })
+	}
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	return result.Val.([]byte), nil
+}
+
+func (c Cache) loadEntity(ctx context.Context,
+	entity string, id string, fetcher func() ([]byte, error)) ([]byte, error) {
+	entry, err := c.store.GetCache(ctx, entity, id)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	found := err == nil
+	if found && c.isFresh(entity, entry) {
+		c.stats.update(entity, func(stats *CacheStats) { stats.StorageHits++ })
+		c.remember(entity, id, entry)
+		return entryValue(entity, id, entry)
+	}
+	c.stats.update(entity, func(stats *CacheStats) { stats.Misses++ })
+
+	result, err := c.fetchEntity(ctx, entity, id, fetcher)
+	if err != nil && !errors.Is(err, ErrNoResult) && found && c.canServeStale(entity, entry) {
+		log.Warnf("Serving stale %s %s stored at %v: %v", entity, id, entry.Ts, err)
+		c.stats.update(entity, func(stats *CacheStats) { stats.Stale++ })
+		return entry.Value, nil
+	}
+	return result, err
+}
+
+func (c Cache) canServeStale(entity string, entry CacheEntry) bool {
+	if !c.serveStale || entry.Negative {
+		return false
+	}
+	return c.maxStale == 0 || time.Since(entry.Ts) <= c.TTL(entity)+c.maxStale
+}
+
+// fetchEntity stores the fetched value, or a negative entry when the fetcher found nothing.
+func (c Cache) fetchEntity(ctx context.Context,
+	entity string, id string, fetcher func() ([]byte, error)) ([]byte, error) {
+	c.stats.update(entity, func(stats *CacheStats) { stats.Fetches++ })
+	result, err := fetcher()
+	if errors.Is(err, ErrNoResult) {
+		entry := CacheEntry{Negative: true, Ts: time.Now()}
+		if err := c.save(ctx, entity, id, entry); err != nil {
 			return nil, err
 		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entry := CacheEntry{Value: result, Ts: time.Now()}
+	if err := c.save(ctx, entity, id, entry); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// save writes the entry to the database unless the cache is read-only, and remembers it.
+func (c Cache) save(ctx context.Context, entity string, id string, entry CacheEntry) error {
+	if !c.readOnly {
+		if err := c.store.InsertCache(ctx, entity, id, entry); err != nil {
+			return err
+		}
+	}
+	c.remember(entity, id, entry)
+	return nil
+}
+
+// RefreshStale refreshes up to limit expired entries of the entities with a registered
+// refresher, the ones expired the longest time ago go first. It returns the number of
+// refreshed entries.
+func (c Cache) RefreshStale(ctx context.Context, limit int32) (int, error) {
+	return c.RefreshExpiring(ctx, 0, limit, nil)
+}
 
-		result, err = fetcher()
+// RefreshExpiring refreshes up to limit entries expired or expiring within the horizon.
+// Entries for which priority returns true go first, then the ones expiring earlier.
+func (c Cache) RefreshExpiring(ctx context.Context, horizon time.Duration, limit int32, priority func(key CacheKey) bool) (int, error) {
+	type expiringEntry struct {
+		key      CacheKey
+		expiry   time.Time
+		priority bool
+	}
+	// load more candidates than needed, so prioritized entries expiring later are not cut off
+	candidates := limit
+	if priority != nil {
+		candidates *= 10
+	}
+	var expiring []expiringEntry
+	for entity := range c.refreshers {
+		ttl := c.TTL(entity)
+		keys, err := c.store.GetStaleCache(ctx, entity, time.Now().Add(horizon-ttl), candidates)
 		if err != nil {
-			return nil, err
+			return 0, fmt.Errorf("error loading stale %s: %w", entity, err)
+		}
+		for _, key := range keys {
+			expiring = append(expiring, expiringEntry{
+				key:      key,
+				expiry:   key.Ts.Add(ttl),
+				priority: priority != nil && priority(key),
+			})
+		}
+	}
+	sort.Slice(expiring, func(i, j int) bool {
+		if expiring[i].priority != expiring[j].priority {
+			return expiring[i].priority
 		}
+		return expiring[i].expiry.Before(expiring[j].expiry)
+	})
 
-		err = c.queries.InsertCache(ctx, sqlc.InsertCacheParams{
-			Entity: entity,
-			ID:     id,
-			Value:  result,
-		})
-		if err != nil {
-			return nil, err
+	count := 0
+	for _, entry := range expiring[:min(int(limit), len(expiring))] {
+		if ctx.Err() != nil {
+			return count, ctx.Err()
 		}
+		key := entry.key
+		if err := c.refreshers[key.Entity](ctx, key.ID); err != nil && !errors.Is(err, ErrNoResult) {
+			log.Warnf("Error refreshing %s %s: %v", key.Entity, key.ID, err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
 
-		return result, nil
+// RunBackgroundRefresh refreshes stale entries every refresh interval until the context is done.
+// It does nothing when the interval is not configured.
+func (c Cache) RunBackgroundRefresh(ctx context.Context) {
+	if c.refreshInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(c.refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			count, err := c.RefreshStale(ctx, c.refreshBatch)
+			if err != nil && ctx.Err() == nil {
+				log.Errorf("Background cache refresh error: %v", err)
+			}
+			if count > 0 {
+				log.Infof("Refreshed %d stale cache entries", count)
+			}
+		}
 	}
-	return result, nil
 }
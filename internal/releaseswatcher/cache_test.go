@@ -0,0 +1,320 @@
+package releaseswatcher
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type cachedValue struct {
+	Name string
+}
+
+func setupTestCache(t *testing.T, config CacheConfig) Cache {
+	store := setupSQLite(t)
+	t.Cleanup(store.Close)
+	migrator, err := NewMigrator(store)
+	require.NoError(t, err)
+	_, err = migrator.Up(context.Background())
+	require.NoError(t, err)
+	return NewCache(store, config)
+}
+
+func TestCache_TTL(t *testing.T) {
+	cache := NewCache(nil, CacheConfig{TTL: map[string]time.Duration{EntityDiscogsRelease: time.Hour}})
+	assert.Equal(t, time.Hour, cache.TTL(EntityDiscogsRelease))
+	assert.Equal(t, days(90), cache.TTL(EntityMusicBrainzArtistSearch))
+	assert.Equal(t, defaultCacheTTL, cache.TTL("unknown"))
+}
+
+func TestGetAllCacheEntities_Freshness(t *testing.T) {
+	ctx := context.Background()
+	cache := setupTestCache(t, CacheConfig{})
+	_, err := GetCached(cache, ctx, EntityDiscogsRelease, "1", func() (*cachedValue, error) {
+		return &cachedValue{Name: "First"}, nil
+	})
+	require.NoError(t, err)
+
+	values, err := GetAllCacheEntities[cachedValue](cache, ctx, EntityDiscogsRelease)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]*cachedValue{"1": {Name: "First"}}, values)
+
+	// a negative TTL makes every stored entry stale
+	cache.policy[EntityDiscogsRelease] = -time.Hour
+	values, err = GetAllCacheEntities[cachedValue](cache, ctx, EntityDiscogsRelease)
+	require.NoError(t, err)
+	assert.Empty(t, values)
+
+	fetched := false
+	value, err := GetCached(cache, ctx, EntityDiscogsRelease, "1", func() (*cachedValue, error) {
+		fetched = true
+		return &cachedValue{Name: "Second"}, nil
+	})
+	require.NoError(t, err)
+	assert.True(t, fetched, "stale entry is fetched again")
+	assert.Equal(t, "Second", value.Name)
+}
+
+func TestGetCached_Negative(t *testing.T) {
+	ctx := context.Background()
+	cache := setupTestCache(t, CacheConfig{NegativeTTL: time.Hour})
+	assert.Equal(t, time.Hour, cache.NegativeTTL(EntityMusicBrainzArtistSearch))
+
+	calls := 0
+	fetcher := func() (*cachedValue, error) {
+		calls++
+		return nil, ErrNoResult
+	}
+	_, err := GetCached(cache, ctx, EntityMusicBrainzArtistSearch, "Unknown", fetcher)
+	assert.ErrorIs(t, err, ErrNoResult)
+	_, err = GetCached(cache, ctx, EntityMusicBrainzArtistSearch, "Unknown", fetcher)
+	assert.ErrorIs(t, err, ErrNoResult)
+	assert.Equal(t, 1, calls, "negative result is cached")
+
+	values, err := GetAllCacheEntities[cachedValue](cache, ctx, EntityMusicBrainzArtistSearch)
+	require.NoError(t, err)
+	assert.Empty(t, values, "negative entries are not loaded in bulk")
+
+	// errors are not cached
+	failing := func() (*cachedValue, error) {
+		calls++
+		return nil, assert.AnError
+	}
+	for range 2 {
+		_, err = GetCached(cache, ctx, EntityMusicBrainzArtistSearch, "Failing", failing)
+		assert.ErrorIs(t, err, assert.AnError)
+	}
+	assert.Equal(t, 3, calls)
+
+	cache.negativeTTL = -time.Hour
+	value, err := GetCached(cache, ctx, EntityMusicBrainzArtistSearch, "Unknown", func() (*cachedValue, error) {
+		return &cachedValue{Name: "Found"}, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Found", value.Name, "expired negative entry is fetched again")
+}
+
+func TestGetCached_ServeStale(t *testing.T) {
+	ctx := context.Background()
+	cache := setupTestCache(t, CacheConfig{ServeStale: true})
+	_, err := GetCached(cache, ctx, EntityMusicBrainzRelease, "1", func() (*cachedValue, error) {
+		return &cachedValue{Name: "Old"}, nil
+	})
+	require.NoError(t, err)
+
+	cache.policy[EntityMusicBrainzRelease] = -time.Hour
+	value, err := GetCached(cache, ctx, EntityMusicBrainzRelease, "1", func() (*cachedValue, error) {
+		return nil, assert.AnError
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Old", value.Name)
+	assert.Equal(t, int64(1), cache.Stats()[EntityMusicBrainzRelease].Stale)
+
+	cache.maxStale = time.Minute
+	_, err = GetCached(cache, ctx, EntityMusicBrainzRelease, "1", func() (*cachedValue, error) {
+		return nil, assert.AnError
+	})
+	assert.ErrorIs(t, err, assert.AnError, "entry is too old to be served")
+}
+
+func TestGetCached_ReadOnly(t *testing.T) {
+	ctx := context.Background()
+	cache := setupTestCache(t, CacheConfig{ReadOnly: true, MemorySize: 10})
+	fetches := 0
+	fetcher := func() (*cachedValue, error) {
+		fetches++
+		return &cachedValue{Name: "Value"}, nil
+	}
+	for range 2 {
+		value, err := GetCached(cache, ctx, EntityMusicBrainzRelease, "1", fetcher)
+		require.NoError(t, err)
+		assert.Equal(t, "Value", value.Name)
+	}
+	assert.Equal(t, 1, fetches, "the value is kept in memory")
+
+	keys, err := cache.store.ListCache(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, keys, "nothing is written to the database")
+}
+
+func TestCache_RefreshStale(t *testing.T) {
+	ctx := context.Background()
+	cache := setupTestCache(t, CacheConfig{})
+	for _, id := range []string{"1", "2", "3"} {
+		_, err := GetCached(cache, ctx, EntityMusicBrainzRelease, id, func() (*cachedValue, error) {
+			return &cachedValue{Name: "Old"}, nil
+		})
+		require.NoError(t, err)
+	}
+
+	var refreshed []string
+	cache.RegisterRefresher(EntityMusicBrainzRelease, func(ctx context.Context, id string) error {
+		refreshed = append(refreshed, id)
+		_, err := RefreshCached(cache, ctx, EntityMusicBrainzRelease, id, func() (*cachedValue, error) {
+			return &cachedValue{Name: "New"}, nil
+		})
+		return err
+	})
+
+	count, err := cache.RefreshStale(ctx, 10)
+	require.NoError(t, err)
+	assert.Zero(t, count, "entries are fresh")
+
+	cache.policy[EntityMusicBrainzRelease] = -time.Hour
+	count, err = cache.RefreshStale(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Len(t, refreshed, 2)
+
+	cache.policy[EntityMusicBrainzRelease] = time.Hour
+	value, err := GetCached(cache, ctx, EntityMusicBrainzRelease, refreshed[0], func() (*cachedValue, error) {
+		return nil, assert.AnError
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "New", value.Name)
+}
+
+func TestCache_RefreshExpiring(t *testing.T) {
+	ctx := context.Background()
+	cache := setupTestCache(t, CacheConfig{TTL: CachePolicy{EntityMusicBrainzRelease: time.Hour}})
+	for _, id := range []string{"1", "2", "3"} {
+		_, err := GetCached(cache, ctx, EntityMusicBrainzRelease, id, func() (*cachedValue, error) {
+			return &cachedValue{Name: "Old"}, nil
+		})
+		require.NoError(t, err)
+	}
+
+	var refreshed []string
+	cache.RegisterRefresher(EntityMusicBrainzRelease, func(ctx context.Context, id string) error {
+		refreshed = append(refreshed, id)
+		return nil
+	})
+
+	count, err := cache.RefreshExpiring(ctx, 30*time.Minute, 10, nil)
+	require.NoError(t, err)
+	assert.Zero(t, count, "entries expire after the horizon")
+
+	priority := func(key CacheKey) bool { return key.ID == "3" }
+	count, err = cache.RefreshExpiring(ctx, 2*time.Hour, 1, priority)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, []string{"3"}, refreshed)
+}
+
+func TestCache_TimeZone(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("UTC+5", 5*60*60)
+	t.Cleanup(func() { time.Local = local })
+
+	forEachStorage(t, func(t *testing.T, store Storage) {
+		ctx := context.Background()
+		cache := NewCache(store, CacheConfig{TTL: CachePolicy{EntityMusicBrainzRelease: time.Hour}})
+		_, err := GetCached(cache, ctx, EntityMusicBrainzRelease, "1", func() (*cachedValue, error) {
+			return &cachedValue{Name: "First"}, nil
+		})
+		require.NoError(t, err)
+
+		entry, err := store.GetCache(ctx, EntityMusicBrainzRelease, "1")
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now(), entry.Ts, time.Minute)
+		assert.True(t, cache.isFresh(EntityMusicBrainzRelease, entry))
+
+		values, err := store.GetAllCache(ctx, EntityMusicBrainzRelease, time.Now().Add(-time.Minute))
+		require.NoError(t, err)
+		assert.Contains(t, values, "1")
+		keys, err := store.GetStaleCache(ctx, EntityMusicBrainzRelease, time.Now().Add(-time.Minute), 10)
+		require.NoError(t, err)
+		assert.Empty(t, keys)
+		keys, err = store.GetStaleCache(ctx, EntityMusicBrainzRelease, time.Now().Add(time.Minute), 10)
+		require.NoError(t, err)
+		assert.Len(t, keys, 1)
+	})
+}
+
+func TestParseReleaseGroupsCacheKey(t *testing.T) {
+	artistID, offset, err := parseReleaseGroupsCacheKey(releaseGroupsCacheKey("a_b", 100))
+	require.NoError(t, err)
+	assert.Equal(t, "a_b", artistID)
+	assert.Equal(t, 100, offset)
+
+	_, _, err = parseReleaseGroupsCacheKey("nooffset")
+	assert.Error(t, err)
+}
+
+func TestGetCached_MemoryAndSingleflight(t *testing.T) {
+	ctx := context.Background()
+	cache := setupTestCache(t, CacheConfig{MemorySize: 10})
+
+	var fetches atomic.Int32
+	var started sync.WaitGroup
+	started.Add(5)
+	fetcher := func() (*cachedValue, error) {
+		fetches.Add(1)
+		// the fetch lasts until every caller has made its lookup
+		started.Wait()
+		return &cachedValue{Name: "Value"}, nil
+	}
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			started.Done()
+			value, err := GetCached(cache, ctx, EntityMusicBrainzRelease, "1", fetcher)
+			assert.NoError(t, err)
+			assert.Equal(t, "Value", value.Name)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), fetches.Load())
+
+	_, err := GetCached(cache, ctx, EntityMusicBrainzRelease, "1", fetcher)
+	require.NoError(t, err)
+	stats := cache.Stats()[EntityMusicBrainzRelease]
+	assert.Equal(t, int64(1), stats.Fetches)
+	assert.Equal(t, int64(1), stats.Misses)
+	// a caller scheduled after the fetch finds the entry in memory or in the database
+	assert.Equal(t, int64(5), stats.Shared+stats.MemoryHits+stats.StorageHits)
+	assert.Positive(t, stats.MemoryHits)
+
+	// another instance shares only the database
+	other := NewCache(cache.store, CacheConfig{MemorySize: 10})
+	_, err = GetCached(other, ctx, EntityMusicBrainzRelease, "1", fetcher)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), other.Stats()[EntityMusicBrainzRelease].StorageHits)
+}
+
+func TestGetCached_CancelledCaller(t *testing.T) {
+	cache := setupTestCache(t, CacheConfig{})
+
+	fetching := make(chan struct{})
+	release := make(chan struct{})
+	fetcher := func() (*cachedValue, error) {
+		close(fetching)
+		<-release
+		return &cachedValue{Name: "Value"}, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := GetCached(cache, ctx, EntityMusicBrainzRelease, "1", fetcher)
+		first <- err
+	}()
+	<-fetching
+	cancel()
+	assert.ErrorIs(t, <-first, context.Canceled, "the cancelled caller stops waiting")
+
+	// the lookup started by the cancelled caller goes on and is stored
+	close(release)
+	value, err := GetCached(cache, context.Background(), EntityMusicBrainzRelease, "1", func() (*cachedValue, error) {
+		<-release
+		return &cachedValue{Name: "Value"}, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Value", value.Name)
+}
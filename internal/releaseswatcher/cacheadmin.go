@@ -0,0 +1,192 @@
+package releaseswatcher
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CacheAgeBuckets are upper bounds of the age distribution in CacheSummary,
+// the last bucket holds everything older.
+var CacheAgeBuckets = []time.Duration{days(1), days(7), days(30), days(90)}
+
+// importBatchSize is the number of records written in a single transaction
+const importBatchSize = 1000
+
+// CacheSummary describes the stored entries of a single entity.
+type CacheSummary struct {
+	Entity   string
+	Entries  int
+	Negative int
+	Expired  int
+	Oldest   time.Time
+	Newest   time.Time
+	// Ages counts entries per CacheAgeBuckets
+	Ages []int
+}
+
+// cacheExportRecord is a line of the exported JSONL file.
+type cacheExportRecord struct {
+	Entity   string          `json:"entity"`
+	ID       string          `json:"id"`
+	Ts       time.Time       `json:"ts"`
+	Negative bool            `json:"negative,omitempty"`
+	Value    json.RawMessage `json:"value,omitempty"`
+}
+
+// Summary returns counts and the age distribution of entries per entity.
+func (c Cache) Summary(ctx context.Context) ([]CacheSummary, error) {
+	keys, err := c.store.ListCache(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing cache: %w", err)
+	}
+	now := time.Now()
+	summaries := make(map[string]*CacheSummary)
+	for _, key := range keys {
+		summary, ok := summaries[key.Entity]
+		if !ok {
+			summary = &CacheSummary{Entity: key.Entity, Oldest: key.Ts, Newest: key.Ts,
+				Ages: make([]int, len(CacheAgeBuckets)+1)}
+			summaries[key.Entity] = summary
+		}
+		summary.Entries++
+		if key.Negative {
+			summary.Negative++
+		}
+		if !c.isFresh(key.Entity, CacheEntry{Negative: key.Negative, Ts: key.Ts}) {
+			summary.Expired++
+		}
+		if key.Ts.Before(summary.Oldest) {
+			summary.Oldest = key.Ts
+		}
+		if key.Ts.After(summary.Newest) {
+			summary.Newest = key.Ts
+		}
+		age := now.Sub(key.Ts)
+		bucket := sort.Search(len(CacheAgeBuckets), func(i int) bool { return age < CacheAgeBuckets[i] })
+		summary.Ages[bucket]++
+	}
+
+	result := make([]CacheSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		result = append(result, *summary)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Entity < result[j].Entity
+	})
+	return result, nil
+}
+
+// Inspect returns the stored entry regardless of its age.
+func (c Cache) Inspect(ctx context.Context, entity string, id string) (CacheEntry, error) {
+	return c.store.GetCache(ctx, entity, id)
+}
+
+// Invalidate removes the entry, or all entries with ids starting with id when prefix is set.
+// An empty id with prefix removes the whole entity.
+func (c Cache) Invalidate(ctx context.Context, entity string, id string, prefix bool) (int64, error) {
+	count, err := c.store.DeleteCache(ctx, entity, id, prefix)
+	if err != nil {
+		return 0, err
+	}
+	if c.memory != nil {
+		for _, key := range c.memory.Keys() {
+			if prefix && strings.HasPrefix(key, cacheKey(entity, id)) || key == cacheKey(entity, id) {
+				c.memory.Remove(key)
+			}
+		}
+	}
+	return count, nil
+}
+
+// Export writes all entries as gzip compressed JSONL, one entry per line.
+func (c Cache) Export(ctx context.Context, w io.Writer) (int, error) {
+	keys, err := c.store.ListCache(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error listing cache: %w", err)
+	}
+	var entities []string
+	for _, key := range keys {
+		if len(entities) == 0 || entities[len(entities)-1] != key.Entity {
+			entities = append(entities, key.Entity)
+		}
+	}
+
+	gz := gzip.NewWriter(w)
+	encoder := json.NewEncoder(gz)
+	count := 0
+	for _, entity := range entities {
+		records, err := c.store.GetCacheRecords(ctx, entity)
+		if err != nil {
+			return count, fmt.Errorf("error loading %s: %w", entity, err)
+		}
+		for _, record := range records {
+			err := encoder.Encode(cacheExportRecord{
+				Entity:   record.Entity,
+				ID:       record.ID,
+				Ts:       record.Ts,
+				Negative: record.Negative,
+				Value:    record.Value,
+			})
+			if err != nil {
+				return count, fmt.Errorf("error writing %s %s: %w", record.Entity, record.ID, err)
+			}
+			count++
+		}
+	}
+	return count, gz.Close()
+}
+
+// Import reads entries written by Export, entries newer than the imported ones are kept.
+func (c Cache) Import(ctx context.Context, r io.Reader) (int, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("error opening cache export: %w", err)
+	}
+	defer gz.Close()
+
+	scanner := bufio.NewScanner(gz)
+	// release groups of prolific artists don't fit into the default 64KiB
+	scanner.Buffer(make([]byte, 0, 1024*1024), 64*1024*1024)
+	count := 0
+	batch := make([]CacheRecord, 0, importBatchSize)
+	flush := func() error {
+		if err := c.store.ImportCache(ctx, batch); err != nil {
+			return err
+		}
+		count += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	for line := 1; scanner.Scan(); line++ {
+		var record cacheExportRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return count, fmt.Errorf("error parsing line %d: %w", line, err)
+		}
+		batch = append(batch, CacheRecord{
+			CacheKey: CacheKey{Entity: record.Entity, ID: record.ID, Ts: record.Ts, Negative: record.Negative},
+			Value:    record.Value,
+		})
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return count, fmt.Errorf("error reading cache export: %w", err)
+	}
+	if err := flush(); err != nil {
+		return count, err
+	}
+	if c.memory != nil {
+		c.memory.Purge()
+	}
+	return count, nil
+}
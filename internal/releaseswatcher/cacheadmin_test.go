@@ -0,0 +1,90 @@
+package releaseswatcher
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fillTestCache(t *testing.T, cache Cache, entity string, ids ...string) {
+	for _, id := range ids {
+		_, err := GetCached(cache, context.Background(), entity, id, func() (*cachedValue, error) {
+			return &cachedValue{Name: id}, nil
+		})
+		require.NoError(t, err)
+	}
+}
+
+func TestCache_SummaryAndInvalidate(t *testing.T) {
+	ctx := context.Background()
+	cache := setupTestCache(t, CacheConfig{MemorySize: 10})
+	fillTestCache(t, cache, EntityMusicBrainzArtistReleaseGroups, "a_0", "a_100", "ab_0")
+	fillTestCache(t, cache, EntityMusicBrainzRelease, "1")
+	_, err := GetCached(cache, ctx, EntityMusicBrainzArtistSearch, "Unknown", func() (*cachedValue, error) {
+		return nil, ErrNoResult
+	})
+	require.ErrorIs(t, err, ErrNoResult)
+
+	summaries, err := cache.Summary(ctx)
+	require.NoError(t, err)
+	require.Len(t, summaries, 3)
+	assert.Equal(t, EntityMusicBrainzArtistReleaseGroups, summaries[0].Entity)
+	assert.Equal(t, 3, summaries[0].Entries)
+	assert.Equal(t, []int{3, 0, 0, 0, 0}, summaries[0].Ages)
+	assert.Equal(t, 1, summaries[1].Negative)
+
+	count, err := cache.Invalidate(ctx, EntityMusicBrainzArtistReleaseGroups, "a_", true)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+	_, err = cache.Inspect(ctx, EntityMusicBrainzArtistReleaseGroups, "ab_0")
+	assert.NoError(t, err, "other artist is kept")
+
+	fetched := false
+	_, err = GetCached(cache, ctx, EntityMusicBrainzArtistReleaseGroups, "a_0", func() (*cachedValue, error) {
+		fetched = true
+		return &cachedValue{}, nil
+	})
+	require.NoError(t, err)
+	assert.True(t, fetched, "invalidated entry is removed from memory too")
+
+	count, err = cache.Invalidate(ctx, EntityMusicBrainzRelease, "1", false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestCache_ExportImport(t *testing.T) {
+	ctx := context.Background()
+	source := setupTestCache(t, CacheConfig{})
+	fillTestCache(t, source, EntityMusicBrainzRelease, "1", "2")
+	_, err := GetCached(source, ctx, EntityMusicBrainzArtistSearch, "Unknown", func() (*cachedValue, error) {
+		return nil, ErrNoResult
+	})
+	require.ErrorIs(t, err, ErrNoResult)
+
+	var exported bytes.Buffer
+	count, err := source.Export(ctx, &exported)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	target := setupTestCache(t, CacheConfig{NegativeTTL: time.Hour})
+	count, err = target.Import(ctx, bytes.NewReader(exported.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	original, err := source.Inspect(ctx, EntityMusicBrainzRelease, "2")
+	require.NoError(t, err)
+	imported, err := target.Inspect(ctx, EntityMusicBrainzRelease, "2")
+	require.NoError(t, err)
+	assert.JSONEq(t, string(original.Value), string(imported.Value))
+	assert.True(t, original.Ts.Equal(imported.Ts), "time of the entry is kept")
+
+	_, err = GetCached(target, ctx, EntityMusicBrainzArtistSearch, "Unknown", func() (*cachedValue, error) {
+		t.Fatal("negative entry is imported")
+		return nil, nil
+	})
+	assert.ErrorIs(t, err, ErrNoResult)
+}
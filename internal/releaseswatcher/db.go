@@ -2,38 +2,66 @@ package releaseswatcher
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/pochemuto/releases-watcher/sqlc"
 )
 
 type DB struct {
-	conn    *pgxpool.Pool
-	queries *sqlc.Queries
+	store Storage
 }
 
-type DbConfig struct {
-	ConnectionString string
-}
+// VersionStatus is the lifecycle state of an actual or local version.
+type VersionStatus string
 
-func NewPgxPool(ctx context.Context, config DbConfig) (*pgxpool.Pool, error) {
-	conn, err := pgxpool.New(ctx, config.ConnectionString)
-	if err != nil {
-		return nil, err
-	}
-	err = conn.Ping(ctx)
-	if err != nil {
-		return nil, err
+const (
+	VersionBuilding  VersionStatus = "building"
+	VersionPublished VersionStatus = "published"
+	VersionFailed    VersionStatus = "failed"
+	VersionAborted   VersionStatus = "aborted"
+	// VersionHeld is a complete version that failed sanity checks and waits for a manual publish
+	VersionHeld VersionStatus = "held"
+	// VersionUnpublished is a version rolled back by hand
+	VersionUnpublished VersionStatus = "unpublished"
+)
+
+// VersionKind selects between actual and local versions.
+type VersionKind string
+
+const (
+	VersionKindActual VersionKind = "actual"
+	VersionKindLocal  VersionKind = "local"
+)
+
+func ParseVersionKind(raw string) (VersionKind, error) {
+	switch VersionKind(raw) {
+	case VersionKindActual, VersionKindLocal:
+		return VersionKind(raw), nil
+	default:
+		return "", fmt.Errorf("unknown version kind %q, expected %q or %q", raw, VersionKindActual, VersionKindLocal)
 	}
-	return conn, nil
 }
 
-func NewDB(conn *pgxpool.Pool) (DB, error) {
-	return DB{
-		conn:    conn,
-		queries: sqlc.New(conn),
-	}, nil
+// VersionInfo describes an actual or local version.
+type VersionInfo struct {
+	Kind      VersionKind
+	ID        int32
+	CreatedAt time.Time
+	Status    VersionStatus
+	Pinned    bool
+	Albums    int64
+}
+
+var ErrNoResumableVersion = errors.New("no aborted or failed actual version to resume")
+
+type DbConfig struct {
+	ConnectionString string
+}
+
+func NewDB(store Storage) (DB, error) {
+	return DB{store: store}, nil
 }
 
 func IsCorrect(a sqlc.LocalAlbum) bool {
@@ -41,61 +69,265 @@ func IsCorrect(a sqlc.LocalAlbum) bool {
 }
 
 func (db DB) Disconnect() {
-	db.conn.Close()
+	db.store.Close()
 }
 
-func (db DB) InsertLocalAlbum(ctx context.Context, album sqlc.LocalAlbum) error {
-	return db.queries.InsertLocalAlbum(ctx, sqlc.InsertLocalAlbumParams(album))
+// WriteLocalAlbums writes all albums of the local version in a single transaction.
+func (db DB) WriteLocalAlbums(ctx context.Context, albums []sqlc.LocalAlbum) error {
+	return db.store.WriteLocalAlbums(ctx, albums)
 }
 
-func (db DB) InsertActualAlbum(ctx context.Context, album sqlc.ActualAlbum) error {
-	return db.queries.InsertActualAlbum(ctx, sqlc.InsertActualAlbumParams(album))
+// WriteActualAlbums writes albums into the version and records lastArtist as completed
+// in the same transaction, so a resumed update continues from a consistent point.
+func (db DB) WriteActualAlbums(ctx context.Context, version sqlc.ActualVersion, lastArtist string, albums []sqlc.ActualAlbum) error {
+	return db.store.WriteActualAlbums(ctx, version.VersionID, lastArtist, albums)
+}
+
+// GetActualAlbumIDs returns IDs of albums already written to the version.
+func (db DB) GetActualAlbumIDs(ctx context.Context, version sqlc.ActualVersion) ([]string, error) {
+	return db.store.GetActualAlbumIDs(ctx, version.VersionID)
 }
 
 func (db DB) GetLocalAlbums(ctx context.Context) ([]sqlc.LocalAlbumPublished, error) {
-	return db.queries.GetLocalAlbums(ctx)
+	return db.store.GetLocalAlbums(ctx)
 }
 
 func (db DB) GetActualAlbums(ctx context.Context) ([]sqlc.ActualAlbumPublished, error) {
-	return db.queries.GetActualAlbums(ctx)
+	return db.store.GetActualAlbums(ctx)
 }
 
 func (db DB) GetLocalArtists(ctx context.Context) ([]string, error) {
-	return db.queries.GetLocalArtists(ctx)
+	return db.store.GetLocalArtists(ctx)
+}
+
+// GetRecentArtists returns artists with actual releases since the year.
+func (db DB) GetRecentArtists(ctx context.Context, sinceYear int32) ([]string, error) {
+	return db.store.GetRecentArtists(ctx, sinceYear)
 }
 
 func (db DB) GetExcludedArtists(ctx context.Context) ([]string, error) {
-	return db.queries.GetExcludedArtists(ctx)
+	return db.store.GetExcludedArtists(ctx)
+}
+
+func (db DB) GetExcludedAlbums(ctx context.Context) ([]sqlc.ExcludedAlbum, error) {
+	return db.store.GetExcludedAlbums(ctx)
+}
+
+func (db DB) GetArtistAliases(ctx context.Context) ([]sqlc.ArtistAlias, error) {
+	return db.store.GetArtistAliases(ctx)
+}
+
+func (db DB) ReplaceArtistAliases(ctx context.Context, artist string, aliases []string) error {
+	return db.store.ReplaceArtistAliases(ctx, artist, aliases)
+}
+
+func (db DB) GetMatchOverrides(ctx context.Context) ([]sqlc.MatchOverride, error) {
+	return db.store.GetMatchOverrides(ctx)
+}
+
+func (db DB) SetMatchOverride(ctx context.Context, override sqlc.MatchOverride) error {
+	return db.store.SetMatchOverride(ctx, override)
+}
+
+func (db DB) DeleteMatchOverride(ctx context.Context, artist string, album string) (int64, error) {
+	return db.store.DeleteMatchOverride(ctx, artist, album)
+}
+
+func (db DB) GetReleaseStates(ctx context.Context) ([]sqlc.ReleaseState, error) {
+	return db.store.GetReleaseStates(ctx)
+}
+
+func (db DB) SetReleaseState(ctx context.Context, state sqlc.ReleaseState) error {
+	return db.store.SetReleaseState(ctx, state)
+}
+
+func (db DB) DeleteReleaseState(ctx context.Context, actualID string) (int64, error) {
+	return db.store.DeleteReleaseState(ctx, actualID)
+}
+
+func (db DB) GetArtistSettings(ctx context.Context) ([]sqlc.ArtistSetting, error) {
+	return db.store.GetArtistSettings(ctx)
+}
+
+func (db DB) SetArtistSetting(ctx context.Context, setting sqlc.ArtistSetting) error {
+	return db.store.SetArtistSetting(ctx, setting)
+}
+
+func (db DB) DeleteArtistSetting(ctx context.Context, artist string) (int64, error) {
+	return db.store.DeleteArtistSetting(ctx, artist)
+}
+
+func (db DB) ReplaceArtistSettings(ctx context.Context, settings []sqlc.ArtistSetting) error {
+	return db.store.ReplaceArtistSettings(ctx, settings)
 }
 
 func (db DB) CreateActualVersion(ctx context.Context) (sqlc.ActualVersion, error) {
-	version, err := db.queries.CreateActualVersion(ctx)
-	if err != nil {
-		return sqlc.ActualVersion{}, err
+	return db.store.CreateActualVersion(ctx)
+}
+
+func (db DB) CreateLocalVersion(ctx context.Context) (sqlc.LocalVersion, error) {
+	return db.store.CreateLocalVersion(ctx)
+}
+
+func (db DB) PublishActualVersion(ctx context.Context, version sqlc.ActualVersion) error {
+	return db.store.SetVersionStatus(ctx, VersionKindActual, version.VersionID, VersionPublished)
+}
+
+func (db DB) PublishLocalVersion(ctx context.Context, version sqlc.LocalVersion) error {
+	return db.store.SetVersionStatus(ctx, VersionKindLocal, version.VersionID, VersionPublished)
+}
+
+func (db DB) SetActualVersionStatus(ctx context.Context, version sqlc.ActualVersion, status VersionStatus) error {
+	return db.store.SetVersionStatus(ctx, VersionKindActual, version.VersionID, status)
+}
+
+func (db DB) SetLocalVersionStatus(ctx context.Context, version sqlc.LocalVersion, status VersionStatus) error {
+	return db.store.SetVersionStatus(ctx, VersionKindLocal, version.VersionID, status)
+}
+
+// UpdateActualVersionProgress remembers the last artist completely written to the version.
+func (db DB) UpdateActualVersionProgress(ctx context.Context, version sqlc.ActualVersion, artist string) error {
+	return db.store.UpdateActualVersionProgress(ctx, version.VersionID, artist)
+}
+
+// GetResumableActualVersion returns the latest actual version if it was aborted or failed.
+func (db DB) GetResumableActualVersion(ctx context.Context) (sqlc.ActualVersion, error) {
+	version, err := db.store.GetResumableActualVersion(ctx)
+	if errors.Is(err, ErrNotFound) {
+		return sqlc.ActualVersion{}, ErrNoResumableVersion
 	}
-	err = db.queries.CreateActualAlbumPartition(ctx, version.VersionID)
-	if err != nil {
-		return sqlc.ActualVersion{}, fmt.Errorf("error creating actual album partition: %w", err)
+	return version, err
+}
+
+// CleanupVersions removes failed, aborted and superseded unpublished versions together with their albums.
+func (db DB) CleanupVersions(ctx context.Context) (int, error) {
+	count := 0
+	for _, kind := range []VersionKind{VersionKindActual, VersionKindLocal} {
+		versions, err := db.store.GetAbandonedVersions(ctx, kind)
+		if err != nil {
+			return count, fmt.Errorf("error loading abandoned %s versions: %w", kind, err)
+		}
+		for _, version := range versions {
+			if err := db.store.DeleteVersion(ctx, kind, version); err != nil {
+				return count, fmt.Errorf("error deleting %s version %d: %w", kind, version, err)
+			}
+			log.Infof("Removed abandoned %s version %d", kind, version)
+			count++
+		}
 	}
-	return version, nil
+	return count, nil
 }
 
-func (db DB) CreateLocalVersion(ctx context.Context) (sqlc.LocalVersion, error) {
-	version, err := db.queries.CreateLocalVersion(ctx)
+// ListVersions returns the latest versions of the given kind, newest first.
+func (db DB) ListVersions(ctx context.Context, kind VersionKind, limit int32) ([]VersionInfo, error) {
+	var result []VersionInfo
+	switch kind {
+	case VersionKindActual:
+		versions, err := db.store.ListActualVersions(ctx, limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range versions {
+			result = append(result, VersionInfo{Kind: kind, ID: v.VersionID, CreatedAt: v.CreatedAt.Time,
+				Status: VersionStatus(v.Status), Pinned: v.Pinned})
+		}
+	case VersionKindLocal:
+		versions, err := db.store.ListLocalVersions(ctx, limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range versions {
+			result = append(result, VersionInfo{Kind: kind, ID: v.VersionID, CreatedAt: v.CreatedAt.Time,
+				Status: VersionStatus(v.Status), Pinned: v.Pinned})
+		}
+	}
+	for i := range result {
+		count, err := db.CountAlbums(ctx, kind, result[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		result[i].Albums = count
+	}
+	return result, nil
+}
+
+// GetVersion returns a single version or an error if it does not exist.
+func (db DB) GetVersion(ctx context.Context, kind VersionKind, id int32) (VersionInfo, error) {
+	info := VersionInfo{Kind: kind, ID: id}
+	switch kind {
+	case VersionKindActual:
+		v, err := db.store.GetActualVersion(ctx, id)
+		if err != nil {
+			return VersionInfo{}, versionError(kind, id, err)
+		}
+		info.CreatedAt, info.Status, info.Pinned = v.CreatedAt.Time, VersionStatus(v.Status), v.Pinned
+	case VersionKindLocal:
+		v, err := db.store.GetLocalVersion(ctx, id)
+		if err != nil {
+			return VersionInfo{}, versionError(kind, id, err)
+		}
+		info.CreatedAt, info.Status, info.Pinned = v.CreatedAt.Time, VersionStatus(v.Status), v.Pinned
+	}
+	count, err := db.CountAlbums(ctx, kind, id)
 	if err != nil {
-		return sqlc.LocalVersion{}, err
+		return VersionInfo{}, err
+	}
+	info.Albums = count
+	return info, nil
+}
+
+func versionError(kind VersionKind, id int32, err error) error {
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("%s version %d not found", kind, id)
 	}
-	err = db.queries.CreateLocalAlbumPartition(ctx, version.VersionID)
+	return err
+}
+
+// CountAlbums returns the number of albums written to the version.
+func (db DB) CountAlbums(ctx context.Context, kind VersionKind, id int32) (int64, error) {
+	return db.store.CountAlbums(ctx, kind, id)
+}
+
+// CountPublishedAlbums returns the number of albums in the current published version.
+func (db DB) CountPublishedAlbums(ctx context.Context, kind VersionKind) (int64, error) {
+	return db.store.CountPublishedAlbums(ctx, kind)
+}
+
+// PublishVersion publishes a held or unpublished version by hand, versions which are being built
+// or never completed can't be published.
+func (db DB) PublishVersion(ctx context.Context, kind VersionKind, id int32) error {
+	version, err := db.GetVersion(ctx, kind, id)
 	if err != nil {
-		return sqlc.LocalVersion{}, fmt.Errorf("error creating local album partition: %w", err)
+		return err
+	}
+	if version.Status != VersionHeld && version.Status != VersionUnpublished {
+		return fmt.Errorf("%s version %d is %s, only held and unpublished versions can be published",
+			kind, id, version.Status)
 	}
-	return version, nil
+	return db.store.SetVersionStatus(ctx, kind, id, VersionPublished)
 }
 
-func (db DB) PublishActualVersion(ctx context.Context, version sqlc.ActualVersion) error {
-	return db.queries.PublishActualVersion(ctx, version.VersionID)
+// UnpublishVersion rolls the version back, the previous published version becomes current.
+func (db DB) UnpublishVersion(ctx context.Context, kind VersionKind, id int32) error {
+	if _, err := db.GetVersion(ctx, kind, id); err != nil {
+		return err
+	}
+	return db.store.UnpublishVersion(ctx, kind, id)
 }
 
-func (db DB) PublishLocalVersion(ctx context.Context, version sqlc.LocalVersion) error {
-	return db.queries.PublishLocalVersion(ctx, version.VersionID)
+// PinVersion makes the published version current regardless of newer published versions.
+func (db DB) PinVersion(ctx context.Context, kind VersionKind, id int32) error {
+	version, err := db.GetVersion(ctx, kind, id)
+	if err != nil {
+		return err
+	}
+	if version.Status != VersionPublished {
+		return fmt.Errorf("%s version %d is %s, only published versions can be pinned", kind, id, version.Status)
+	}
+	return db.store.PinVersion(ctx, kind, id)
+}
+
+// UnpinVersions makes the newest published version current again.
+func (db DB) UnpinVersions(ctx context.Context, kind VersionKind) error {
+	return db.store.UnpinVersions(ctx, kind)
 }
@@ -3,219 +3,559 @@ package releaseswatcher
 import (
 	"context"
 	"fmt"
-	"regexp"
-	"strings"
+	"slices"
+	"time"
 
 	"github.com/pochemuto/releases-watcher/sqlc"
 )
 
 type Differ struct {
 	db         DB
-	sheets     GoogleSheets
+	settings   ArtistSettingsStore
 	cutoffYear uint
+	names      nameMatcher
+	threshold  float64
+	// keepUnknownYear reports releases without a year
+	keepUnknownYear bool
+	// reportEditions reports editions of owned albums with more tracks than the local copy
+	reportEditions bool
+	// collaborations reports releases credited to the artist together with others
+	collaborations bool
 }
 
 type DifferConfig struct {
+	// CutoffYear is the earliest year of reported releases, ArtistSetting.Since overrides it
 	CutoffYear uint
+	// KeepUnknownYear reports releases without a year, otherwise they are filtered out
+	KeepUnknownYear bool `envDefault:"false"`
+	// Transliteration is the scheme matching Cyrillic and Latin spellings, "none" disables it
+	Transliteration string `envDefault:"ru"`
+	// TransliterationRules override letters of the scheme, e.g. "х:kh,й:j"
+	TransliterationRules map[string]string `envDefault:""`
+	// Normalization are the rules making keys of artist and album names
+	Normalization NormalizationConfig `envPrefix:"NORMALIZATION_"`
+	// MatchThreshold is the lowest similarity of titles considered the same album,
+	// above 1 only equal titles match
+	MatchThreshold float64 `envDefault:"0.85"`
+	// ReportEditions reports editions of owned albums with more tracks than the local copy,
+	// e.g. a deluxe edition with bonus tracks
+	ReportEditions bool `envDefault:"false"`
+	// Collaborations reports releases credited to the artist together with others, e.g. "A & B"
+	// or "A feat. B", ArtistSetting.Collaborations overrides it
+	Collaborations bool `envDefault:"true"`
 }
 
-func NewDiffer(db DB, config DifferConfig, sheets GoogleSheets) Differ {
+func NewDiffer(db DB, config DifferConfig, settings ArtistSettingsStore) (Differ, error) {
+	names, err := newNameMatcher(config.Transliteration, config.TransliterationRules)
+	if err != nil {
+		return Differ{}, err
+	}
+	normalizer, err := newNormalizer(config.Normalization)
+	if err != nil {
+		return Differ{}, err
+	}
+	names = names.withNormalizer(normalizer)
 	return Differ{
 		db:         db,
-		sheets:     sheets,
+		settings:   settings,
 		cutoffYear: config.CutoffYear,
-	}
+		names:      names,
+		threshold:  config.MatchThreshold,
+
+		keepUnknownYear: config.KeepUnknownYear,
+		reportEditions:  config.ReportEditions,
+		collaborations:  config.Collaborations,
+	}, nil
 }
 
-func toAlbum(ea sqlc.ExcludedAlbum) sqlc.LocalAlbumPublished {
-	return sqlc.LocalAlbumPublished{
-		Artist: ea.Artist,
-		Name:   ea.Album,
+// loadNames returns the name matcher aware of the artist aliases known to the library.
+func (d Differ) loadNames(ctx context.Context) (nameMatcher, error) {
+	aliases, err := d.db.GetArtistAliases(ctx)
+	if err != nil {
+		return nameMatcher{}, fmt.Errorf("error loading artist aliases: %w", err)
 	}
+	return d.names.withAliases(aliases), nil
 }
 
+// FilterReason tells why a new release is not reported.
+type FilterReason string
+
+const (
+	// FilterIgnored releases are set to LifecycleIgnored
+	FilterIgnored FilterReason = "ignored"
+	// FilterAcquired releases are set to LifecycleAcquired
+	FilterAcquired FilterReason = "acquired"
+	// FilterSnoozed releases are set to LifecycleSnoozed until a later date
+	FilterSnoozed FilterReason = "snoozed"
+	// FilterUnknownYear releases have no year and DifferConfig.KeepUnknownYear is not set
+	FilterUnknownYear FilterReason = "unknown year"
+	// FilterCutoff releases are older than ArtistSetting.Since or DifferConfig.CutoffYear
+	FilterCutoff FilterReason = "cutoff"
+	// FilterExcludedArtist releases are of artists in excluded_artist
+	FilterExcludedArtist FilterReason = "excluded artist"
+	// FilterExcludedAlbum releases are in excluded_album
+	FilterExcludedAlbum FilterReason = "excluded album"
+	// FilterDoNotTrack releases are of artists set to NotificationDoNotTrack
+	FilterDoNotTrack FilterReason = "do not track"
+	// FilterOutOfScope releases are of kinds not in the notification setting of the artist
+	FilterOutOfScope FilterReason = "out of scope"
+	// FilterCollaboration releases are credited to other artists too and none of the followed
+	// credited artists takes collaborations
+	FilterCollaboration FilterReason = "collaboration"
+	// FilterEdition releases are other editions of an owned album or of an already reported release group
+	FilterEdition FilterReason = "edition"
+)
+
 type MatchedAlbum struct {
 	Local  *sqlc.LocalAlbumPublished
 	Actual *sqlc.ActualAlbumPublished
+	// Score is the similarity of the titles when both albums are present, 1 for equal titles
+	Score float64
+	// Filtered is why the new release is not reported, empty for reported ones
+	Filtered FilterReason
+	// State is the lifecycle state of the release set by hand
+	State *sqlc.ReleaseState
+	// EditionOf is the owned album of the same release group, Local is nil then
+	EditionOf *sqlc.LocalAlbumPublished
 }
 
-func (d Differ) Matched(ctx context.Context) ([]MatchedAlbum, error) {
-	locals, err := d.loadLocal(ctx)
+// releaseFilter returns the reason to filter out the new release, empty to report it.
+type releaseFilter func(actual *sqlc.ActualAlbumPublished, key sqlc.LocalAlbumPublished) FilterReason
+
+// matcher matches actual releases to local albums and filters out the new ones not worth reporting,
+// Matched, Diff and Explain share it.
+type matcher struct {
+	names     nameMatcher
+	locals    albumIndex
+	overrides matchOverrides
+	settings  map[string]ArtistSetting
+	// states are active lifecycle states by release ID
+	states  map[string]sqlc.ReleaseState
+	filters []releaseFilter
+	// reportEditions keeps editions with more tracks than the local copy
+	reportEditions bool
+}
+
+func (d Differ) newMatcher(ctx context.Context) (matcher, error) {
+	names, err := d.loadNames(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load local: %w", err)
+		return matcher{}, err
 	}
-	settings, err := d.loadArtistSettings(ctx)
+	locals, err := d.loadLocal(ctx, names)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get artist settings: %w", err)
+		return matcher{}, fmt.Errorf("failed to load local: %w", err)
 	}
-
-	actuals, err := d.db.GetActualAlbums(ctx)
+	overrides, err := d.loadOverrides(ctx, names, locals)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get actual albums: %w", err)
+		return matcher{}, fmt.Errorf("failed to load match overrides: %w", err)
+	}
+	settings, err := d.loadArtistSettings(ctx, names)
+	if err != nil {
+		return matcher{}, fmt.Errorf("failed to get artist settings: %w", err)
 	}
+	excludedArtists, err := d.db.GetExcludedArtists(ctx)
+	if err != nil {
+		return matcher{}, fmt.Errorf("error loading excluded artists: %w", err)
+	}
+	excludedAlbums, err := d.db.GetExcludedAlbums(ctx)
+	if err != nil {
+		return matcher{}, fmt.Errorf("error loading excluded albums: %w", err)
+	}
+	states, err := d.db.GetReleaseStates(ctx)
+	if err != nil {
+		return matcher{}, fmt.Errorf("error loading release states: %w", err)
+	}
+	log.Infof("Excluded %d artists and %d albums, filtering albums released since %d unless set for the artist",
+		len(excludedArtists), len(excludedAlbums), d.cutoffYear)
+	return matcher{
+		names:     names,
+		locals:    locals,
+		overrides: overrides,
+		settings:  settings,
+		states:    activeReleaseStates(states, time.Now()),
+		filters:   d.releaseFilters(names, excludedArtists, excludedAlbums, settings),
 
-	for _, actual := range actuals {
-		if actual.Year != nil && *actual.Year < int32(d.cutoffYear) {
-			continue
-		}
+		reportEditions: d.reportEditions,
+	}, nil
+}
 
-		normalizedActual := normalize(sqlc.LocalAlbumPublished{Artist: *actual.Artist, Name: *actual.Name})
-		matched, isOk := locals[normalizedActual]
-		if isOk {
-			matched.Actual = &actual
-			continue
-		}
-		if setting, settingOk := settings[normalizedActual.Artist]; settingOk {
-			kind, err := KindOf(&actual)
-			if err != nil {
-				return nil, fmt.Errorf("failed to get album kind: %w", err)
+// releaseFilters are applied to new releases in order, the first reason is kept.
+func (d Differ) releaseFilters(names nameMatcher, excludedArtists []string, excludedAlbums []sqlc.ExcludedAlbum,
+	settings map[string]ArtistSetting) []releaseFilter {
+	artists := make(map[string]bool, len(excludedArtists))
+	for _, artist := range excludedArtists {
+		artists[names.artistKey(artist)] = true
+	}
+	albums := make(map[sqlc.LocalAlbumPublished]bool, len(excludedAlbums))
+	for _, album := range excludedAlbums {
+		albums[names.album(album.Artist, album.Album)] = true
+	}
+	return []releaseFilter{
+		func(actual *sqlc.ActualAlbumPublished, key sqlc.LocalAlbumPublished) FilterReason {
+			// the libraries write 0 for releases without a date
+			if actual.Year == nil || *actual.Year == 0 {
+				if d.keepUnknownYear {
+					return ""
+				}
+				return FilterUnknownYear
+			}
+			if *actual.Year < d.sinceYear(settings[key.Artist]) {
+				return FilterCutoff
+			}
+			return ""
+		},
+		func(_ *sqlc.ActualAlbumPublished, key sqlc.LocalAlbumPublished) FilterReason {
+			if artists[key.Artist] {
+				return FilterExcludedArtist
 			}
+			return ""
+		},
+		func(_ *sqlc.ActualAlbumPublished, key sqlc.LocalAlbumPublished) FilterReason {
+			if albums[key] {
+				return FilterExcludedAlbum
+			}
+			return ""
+		},
+		func(actual *sqlc.ActualAlbumPublished, key sqlc.LocalAlbumPublished) FilterReason {
+			setting, ok := settings[key.Artist]
+			if !ok {
+				return ""
+			}
+			if setting.Notification == NotificationDoNotTrack {
+				return FilterDoNotTrack
+			}
+			// unknown kinds are in scope of the settings including KindUnknown
+			kind, _ := KindOf(actual)
 			if !setting.Notification.IsReleaseInScope(kind) {
-				log.Tracef("release %v is out of scope of %v", actual, kind)
-				continue
+				return FilterOutOfScope
 			}
+			return ""
+		},
+		func(actual *sqlc.ActualAlbumPublished, key sqlc.LocalAlbumPublished) FilterReason {
+			if isCollaboration(actual) && !d.takesCollaborations(settings[key.Artist]) {
+				return FilterCollaboration
+			}
+			return ""
+		},
+	}
+}
+
+// takesCollaborations returns whether collaborations of the artist are reported.
+func (d Differ) takesCollaborations(setting ArtistSetting) bool {
+	switch setting.Collaborations {
+	case CollaborationsInclude:
+		return true
+	case CollaborationsExclude:
+		return false
+	}
+	return d.collaborations
+}
+
+// creditedArtists returns the artists of a release the library credits to several artists.
+func creditedArtists(actual *sqlc.ActualAlbumPublished) []string {
+	if len(actual.Credited) < 2 {
+		return nil
+	}
+	return actual.Credited
+}
+
+// isCollaboration returns whether the library credits the release to several artists,
+// an artist named like "Simon & Garfunkel" is credited alone.
+func isCollaboration(actual *sqlc.ActualAlbumPublished) bool {
+	return len(creditedArtists(actual)) > 0
+}
+
+// sinceYear is the earliest year of releases reported for the artist.
+func (d Differ) sinceYear(setting ArtistSetting) int32 {
+	if !setting.Since.IsZero() {
+		return int32(setting.Since.Year())
+	}
+	return int32(d.cutoffYear)
+}
+
+// filter applies the lifecycle state of the release and then the filters,
+// releases on the wishlist are always reported.
+func (m matcher) filter(actual *sqlc.ActualAlbumPublished, key sqlc.LocalAlbumPublished) FilterReason {
+	if state, ok := m.states[actual.ID]; ok {
+		if Lifecycle(state.State) == LifecycleWishlist {
+			return ""
 		}
-		locals[normalizedActual] = &MatchedAlbum{
-			Actual: &actual,
+		if reason, ok := lifecycleFilters[Lifecycle(state.State)]; ok {
+			return reason
 		}
 	}
-	var results []MatchedAlbum
-	for _, local := range locals {
-		results = append(results, *local)
+	for _, filter := range m.filters {
+		if reason := filter(actual, key); reason != "" {
+			return reason
+		}
 	}
-	return results, nil
+	return ""
 }
 
-func (d Differ) loadArtistSettings(ctx context.Context) (map[string]ArtistSetting, error) {
-	settings, err := d.sheets.GetArtistSettings(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("error getting artist settings: %w", err)
+// filterCredited attributes a collaboration to every followed credited artist, it is reported
+// when it passes the filters of any of them. Otherwise the reason for the artist of the key is kept.
+func (m matcher) filterCredited(actual *sqlc.ActualAlbumPublished, key sqlc.LocalAlbumPublished) FilterReason {
+	reason := m.filter(actual, key)
+	if reason == "" {
+		return ""
 	}
-	artistSettings := make(map[string]ArtistSetting)
-	for _, setting := range settings {
-		normalized := normalizeString(setting.ArtistName)
-		artistSettings[normalized] = setting
+	for _, artist := range creditedArtists(actual) {
+		credited := m.names.album(artist, *actual.Name)
+		if credited.Artist == key.Artist || !m.follows(credited.Artist) {
+			continue
+		}
+		if m.filter(actual, credited) == "" {
+			return ""
+		}
 	}
-	return artistSettings, nil
+	return reason
 }
 
-func (d Differ) loadLocal(ctx context.Context) (map[sqlc.LocalAlbumPublished]*MatchedAlbum, error) {
-	local, err := d.db.GetLocalAlbums(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("error loading local albums: %w", err)
+// setting returns the setting of the artist of the key, nil without one.
+func (m matcher) setting(artist string) *ArtistSetting {
+	if setting, ok := m.settings[artist]; ok {
+		return &setting
 	}
-	localMap := make(map[sqlc.LocalAlbumPublished]*MatchedAlbum)
-	for _, local := range local {
-		normalized := normalize(local)
-		localMap[normalized] = &MatchedAlbum{
-			Local: &local,
+	return nil
+}
+
+// follows returns whether the artist of the key has local albums or a setting.
+func (m matcher) follows(artist string) bool {
+	_, ok := m.settings[artist]
+	return ok || m.locals.hasArtist(artist)
+}
+
+// match finds the album of the release by an override, the key or the most similar title.
+// A collaboration is found by any of the credited artists or by the whole credit, e.g. "A & B".
+// The score is of the found album or of the best candidate below the threshold.
+func (m matcher) match(actual *sqlc.ActualAlbumPublished, key sqlc.LocalAlbumPublished) (*MatchedAlbum, float64, bool) {
+	if local, ok := m.overrides.byActual[actual.ID]; ok {
+		return local, 1, true
+	}
+	match, score := m.locals.find(key, *actual.Name)
+	artists := creditedArtists(actual)
+	if len(artists) > 0 && actual.Credit != nil {
+		artists = append(slices.Clip(artists), *actual.Credit)
+	}
+	for _, artist := range artists {
+		if match != nil {
+			break
+		}
+		credited, creditedScore := m.locals.find(m.names.album(artist, *actual.Name), *actual.Name)
+		if credited != nil || creditedScore > score {
+			match, score = credited, creditedScore
 		}
 	}
-	return localMap, nil
+	return match, score, false
 }
 
-// Diff function with excluded albums and artists
-func (d Differ) Diff(ctx context.Context) ([]sqlc.ActualAlbumPublished, error) {
-	local, err := d.db.GetLocalAlbums(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("error loading local albums: %w", err)
+// matchAll matches the releases to local albums, new releases are returned with the reason
+// they are filtered out, if any. Other releases of the release group of an owned album
+// or of a new release are editions.
+func (m matcher) matchAll(actuals []sqlc.ActualAlbumPublished) []MatchedAlbum {
+	// owned are local albums by the release groups of their releases
+	owned := make(map[string]*sqlc.LocalAlbumPublished)
+	var editions []MatchedAlbum
+	var added []*MatchedAlbum
+	for _, actual := range actuals {
+		key := m.names.album(*actual.Artist, *actual.Name)
+		matched, score, override := m.match(&actual, key)
+		switch {
+		case matched == nil:
+			album := &MatchedAlbum{
+				Actual:   &actual,
+				Filtered: m.filterCredited(&actual, key),
+			}
+			m.locals.add(key, album)
+			added = append(added, album)
+			continue
+		case override:
+			matched.Actual = &actual
+			matched.Score = score
+		case matched.Local == nil:
+			// a release with the same key is reported once, a filtered one gives way to a reported one
+			if matched.Filtered != "" && m.filterCredited(&actual, key) == "" {
+				editions = append(editions, m.edition(matched.Actual, nil))
+				matched.Actual = &actual
+				matched.Filtered = ""
+			} else {
+				editions = append(editions, m.edition(&actual, nil))
+			}
+			continue
+		case matched.Actual == nil || score > matched.Score:
+			// the best scoring release of the local album is shown, the others are editions
+			if score < 1 {
+				log.Debugf("Matched %s - %s to local %s with score %.2f",
+					*actual.Artist, *actual.Name, matched.Local.Name, score)
+			}
+			if matched.Actual != nil {
+				editions = append(editions, m.edition(matched.Actual, matched.Local))
+			}
+			matched.Actual = &actual
+			matched.Score = score
+		default:
+			editions = append(editions, m.edition(&actual, matched.Local))
+		}
+		if actual.ReleaseGroup != nil {
+			owned[*actual.ReleaseGroup] = matched.Local
+		}
 	}
-	actual, err := d.db.GetActualAlbums(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("error loading actual albums: %w", err)
+
+	// releases come in the order of the library, the earliest release of a group first
+	reported := make(map[string]bool)
+	for _, album := range added {
+		group := album.Actual.ReleaseGroup
+		switch {
+		case group == nil:
+		case owned[*group] != nil:
+			*album = m.edition(album.Actual, owned[*group])
+		case reported[*group]:
+			*album = m.edition(album.Actual, nil)
+		default:
+			reported[*group] = true
+		}
 	}
-	log.Infof("Loaded %d local albums and %d actual albums", len(local), len(actual))
 
-	excludedArtists, err := d.db.queries.GetExcludedArtists(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("error when loading excluded artists: %w", err)
+	var results []MatchedAlbum
+	for _, local := range m.locals.all() {
+		results = append(results, m.withState(*local))
 	}
-	log.Infof("Excluded artists %d", len(excludedArtists))
-	excludedAlbums, err := d.db.queries.GetExcludedAlbums(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("error when loading excluded albums: %w", err)
+	for local := range m.overrides.locals {
+		results = append(results, m.withState(*local))
 	}
-	log.Infof("Excluded albums %d", len(excludedAlbums))
-	// Create a map of normalized Local albums for a faster lookup.
-	localMap := make(map[sqlc.LocalAlbumPublished]bool)
-	for _, local := range local {
-		normalized := normalize(local)
-		localMap[normalized] = true
+	for _, edition := range editions {
+		results = append(results, m.withState(edition))
 	}
+	return results
+}
 
-	// Create a set of normalized excluded albums for faster lookup.
-	excludedAlbumMap := make(map[sqlc.LocalAlbumPublished]bool)
-	for _, album := range excludedAlbums {
-		normalized := normalize(toAlbum(album))
-		excludedAlbumMap[normalized] = true
+// edition filters out another edition of the owned album or of a new release, the local album
+// is nil then. Editions with more tracks than the local copy go through the filters when
+// reporting editions, as well as editions with a lifecycle state.
+func (m matcher) edition(actual *sqlc.ActualAlbumPublished, local *sqlc.LocalAlbumPublished) MatchedAlbum {
+	album := MatchedAlbum{Actual: actual, EditionOf: local, Filtered: FilterEdition}
+	_, hasState := m.states[actual.ID]
+	if hasState || m.reportEditions && hasExtraTracks(actual, local) {
+		album.Filtered = m.filterCredited(actual, m.names.album(*actual.Artist, *actual.Name))
 	}
+	return album
+}
 
-	settings, err := d.sheets.GetArtistSettings(ctx)
-	artistSettings := make(map[string]NotificationSetting)
-	for _, setting := range settings {
-		normalized := normalizeString(setting.ArtistName)
-		artistSettings[normalized] = setting.Notification
-	}
+// hasExtraTracks returns whether the release has more tracks than the local copy,
+// both track counts are known.
+func hasExtraTracks(actual *sqlc.ActualAlbumPublished, local *sqlc.LocalAlbumPublished) bool {
+	return local != nil && actual.Tracks != nil && local.Tracks != nil && *actual.Tracks > *local.Tracks
+}
 
-	log.Infof("Filtering albums released since %d", d.cutoffYear)
-	// Iterate over actual albums and check if they exist in the map or are excluded.
-	result := make([]sqlc.ActualAlbumPublished, 0)
-	for _, actual := range actual {
-		if actual.Year != nil && *actual.Year < int32(d.cutoffYear) {
-			continue
+func (m matcher) withState(album MatchedAlbum) MatchedAlbum {
+	if album.Actual != nil {
+		if state, ok := m.states[album.Actual.ID]; ok {
+			album.State = &state
 		}
+	}
+	return album
+}
+
+// Matched returns local albums with their releases and new releases, filtered ones included.
+func (d Differ) Matched(ctx context.Context) ([]MatchedAlbum, error) {
+	m, err := d.newMatcher(ctx)
+	if err != nil {
+		return nil, err
+	}
+	actuals, err := d.db.GetActualAlbums(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get actual albums: %w", err)
+	}
+	return m.matchAll(actuals), nil
+}
 
-		normalizedAlbum := normalize(sqlc.LocalAlbumPublished{Artist: *actual.Artist, Name: *actual.Name})
-		normalizedArtist := normalizeString(*actual.Artist)
+// matchOverrides are local albums matched by hand, they take no part in automatic matching.
+type matchOverrides struct {
+	// byActual are local albums by the ID of the release they are matched to
+	byActual map[string]*MatchedAlbum
+	locals   map[*MatchedAlbum]sqlc.MatchOverride
+}
 
-		if _, localOk := localMap[normalizedAlbum]; localOk {
-			log.Tracef("Album exists locally, skipping: %v", normalizedAlbum)
+// loadOverrides takes overridden local albums out of the index, overrides from the table
+// take precedence over the ones from the settings store, e.g. the overrides sheet.
+func (d Differ) loadOverrides(ctx context.Context, names nameMatcher, index albumIndex) (matchOverrides, error) {
+	stored, err := d.db.GetMatchOverrides(ctx)
+	if err != nil {
+		return matchOverrides{}, fmt.Errorf("error loading match overrides: %w", err)
+	}
+	var sheet []sqlc.MatchOverride
+	if source, ok := d.settings.(MatchOverrideSource); ok {
+		sheet, err = source.GetMatchOverrides(ctx)
+		if err != nil {
+			return matchOverrides{}, fmt.Errorf("error getting match overrides from %s: %w", d.settings.Name(), err)
+		}
+	}
+	result := matchOverrides{
+		byActual: make(map[string]*MatchedAlbum),
+		locals:   make(map[*MatchedAlbum]sqlc.MatchOverride),
+	}
+	seen := make(map[sqlc.LocalAlbumPublished]bool)
+	for _, override := range append(stored, sheet...) {
+		key := names.album(override.LocalArtist, override.LocalAlbum)
+		if seen[key] {
 			continue
 		}
-		if _, albumOk := excludedAlbumMap[normalizedAlbum]; albumOk {
-			log.Tracef("Album is excluded, skipping: %v", normalizedAlbum)
+		seen[key] = true
+		local := index.remove(key)
+		if local == nil {
+			log.Warnf("Local album %s - %s of the match override is not found", override.LocalArtist, override.LocalAlbum)
 			continue
 		}
-		if setting, artistOk := artistSettings[normalizedArtist]; artistOk {
-			if setting == NotificationDoNotTrack {
-				log.Tracef("Artist is excluded, skipping: %v", normalizedArtist)
-				continue
-			}
-			kind, err := KindOf(&actual)
-			if err != nil {
-				log.Errorf("error getting album kind: %v", err)
-				continue
-			}
-			if !setting.IsReleaseInScope(kind) {
-				log.Tracef("Release type %v is not in scope of %v", kind, setting)
-				continue
-			}
+		result.locals[local] = override
+		if override.ActualID != nil {
+			result.byActual[*override.ActualID] = local
 		}
-		result = append(result, actual)
 	}
-
 	return result, nil
 }
 
-func normalize(a sqlc.LocalAlbumPublished) sqlc.LocalAlbumPublished {
-	return sqlc.LocalAlbumPublished{
-		Artist:    normalizeString(a.Artist),
-		Name:      normalizeString(a.Name),
-		VersionID: 0,
+func (d Differ) loadArtistSettings(ctx context.Context, names nameMatcher) (map[string]ArtistSetting, error) {
+	settings, err := d.settings.GetArtistSettings(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting artist settings from %s: %w", d.settings.Name(), err)
+	}
+	artistSettings := make(map[string]ArtistSetting)
+	for _, setting := range settings {
+		artistSettings[names.artistKey(setting.ArtistName)] = setting
+	}
+	return artistSettings, nil
+}
+
+func (d Differ) loadLocal(ctx context.Context, names nameMatcher) (albumIndex, error) {
+	local, err := d.db.GetLocalAlbums(ctx)
+	if err != nil {
+		return albumIndex{}, fmt.Errorf("error loading local albums: %w", err)
 	}
+	return d.localIndex(names, local), nil
 }
 
-// Remove text in braces and brackets
-func removeTextInBraces(text string) string {
-	regex := `[\(\[][^\)\]]*[\)\]]`
-	return regexp.MustCompile(regex).ReplaceAllString(text, "")
+func (d Differ) localIndex(names nameMatcher, local []sqlc.LocalAlbumPublished) albumIndex {
+	index := newAlbumIndex(names, d.threshold)
+	for _, local := range local {
+		normalized := names.album(local.Artist, local.Name)
+		index.addLocal(normalized, local.Name, &MatchedAlbum{
+			Local: &local,
+		})
+	}
+	return index
 }
 
-// Normalize the string by converting to lowercase and removing non-alphanumeric characters except for the star
-func normalizeString(s string) string {
-	// First, remove text in braces
-	s = removeTextInBraces(s)
-	// Now, remove unwanted characters except for letters, digits, and the star symbol
-	regex := `[^\p{L}a-zA-Z0-9★]`
-	return strings.ToLower(regexp.MustCompile(regex).ReplaceAllString(s, ""))
+// Diff returns new releases which are not filtered out.
+func (d Differ) Diff(ctx context.Context) ([]sqlc.ActualAlbumPublished, error) {
+	matched, err := d.Matched(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]sqlc.ActualAlbumPublished, 0)
+	for _, album := range matched {
+		if album.Local == nil && album.Actual != nil && album.Filtered == "" {
+			result = append(result, *album.Actual)
+		}
+	}
+	return result, nil
 }
@@ -1,9 +1,14 @@
 package releaseswatcher
 
 import (
+	"slices"
 	"testing"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/pochemuto/releases-watcher/sqlc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNormalize(t *testing.T) {
@@ -58,12 +63,456 @@ func TestNormalize(t *testing.T) {
 		},
 	}
 
+	names, err := newNameMatcher("ru", nil)
+	require.NoError(t, err)
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			actual := normalize(test.input)
+			actual := names.album(test.input.Artist, test.input.Name)
 			if actual != test.expected {
 				t.Errorf("Normalize(%v) = %v, expected %v", test.input, actual, test.expected)
 			}
 		})
 	}
 }
+
+func TestNameMatcher_Transliteration(t *testing.T) {
+	names, err := newNameMatcher("ru", nil)
+	require.NoError(t, err)
+	assert.Equal(t, names.album("Kino", "Gruppa Krovi"), names.album("Кино", "Группа крови"))
+	assert.Equal(t, "zhuki", names.key("Жуки"))
+	assert.NotEqual(t, names.key("Аквариум"), names.key("Aquarium"), "needs an alias")
+
+	names, err = newNameMatcher("ru", map[string]string{"Х": "kh"})
+	require.NoError(t, err)
+	assert.Equal(t, "kharms", names.key("Хармс"))
+
+	names, err = newNameMatcher(transliterationNone, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "кино", names.key("Кино"))
+
+	_, err = newNameMatcher("klingon", nil)
+	assert.Error(t, err)
+	_, err = newNameMatcher("ru", map[string]string{"ch": "4"})
+	assert.Error(t, err)
+}
+
+func TestNameMatcher_Aliases(t *testing.T) {
+	names, err := newNameMatcher("ru", nil)
+	require.NoError(t, err)
+	names = names.withAliases([]sqlc.ArtistAlias{
+		{Artist: "Аквариум", Alias: "Аквариум"},
+		{Artist: "Аквариум", Alias: "Aquarium"},
+		{Artist: "Аквариум", Alias: "БГ и Аквариум"},
+		{Artist: "Кино", Alias: "Kino"},
+		{Artist: "Кино", Alias: "Аквариум"},
+		{Artist: "Кино", Alias: "Rock"},
+		{Artist: "ДДТ", Alias: "Rock"},
+		{Artist: "ДДТ", Alias: "DDT"},
+	})
+	assert.Equal(t, "akvarium", names.artistKey("Aquarium"))
+	assert.Equal(t, "akvarium", names.artistKey("БГ и Аквариум"))
+	assert.Equal(t, names.album("Aquarium", "Radio Afrika"), names.album("Аквариум", "Радио Африка"))
+	assert.Equal(t, "kino", names.artistKey("Kino"))
+	assert.Equal(t, "akvarium", names.artistKey("Аквариум"), "an alias naming another artist is left out")
+	assert.Equal(t, "rock", names.artistKey("Rock"), "an alias shared by several artists is left out")
+	assert.Equal(t, "ddt", names.artistKey("DDT"))
+
+	// followed artists are never merged, even when they are aliases of each other
+	names = names.withAliases([]sqlc.ArtistAlias{
+		{Artist: "Аквариум", Alias: "Aquarium"},
+		{Artist: "Aquarium", Alias: "Аквариум"},
+	})
+	assert.NotEqual(t, names.artistKey("Аквариум"), names.artistKey("Aquarium"))
+}
+
+func TestNormalizer(t *testing.T) {
+	n, err := newNormalizer(NormalizationConfig{
+		Replacements: map[string]string{"&": "and", "feat.": "featuring"},
+		StopWords:    []string{"The", "a"},
+		Brackets:     bracketsDrop,
+		KeepBrackets: []string{"part"},
+		Characters:   "★",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "beatles", n.normalize("The Beatles"))
+	assert.Equal(t, "thethe", n.normalize("The The"), "a name of stop words only is kept")
+	assert.Equal(t, "rockandroll", n.normalize("Rock & Roll"))
+	assert.Equal(t, "songfeaturingb", n.normalize("Song feat. B"), "longer replacements go first")
+	assert.Equal(t, "wishpart2", n.normalize("Wish (Part 2) [Remastered]"))
+	assert.Equal(t, "★", n.normalize("★ (Blackstar)"))
+
+	n, err = newNormalizer(NormalizationConfig{Brackets: bracketsKeep, DropBrackets: []string{"remastered"}})
+	require.NoError(t, err)
+	assert.Equal(t, "wishpart2", n.normalize("Wish (Part 2) (2011 Remastered)"))
+	assert.Equal(t, "", n.normalize("★"), "only the configured characters are kept")
+
+	_, err = newNormalizer(NormalizationConfig{Brackets: "strip"})
+	assert.Error(t, err)
+	_, err = newNormalizer(NormalizationConfig{Replacements: map[string]string{"": "x"}})
+	assert.Error(t, err)
+}
+
+func TestNameMatcher_Normalizer(t *testing.T) {
+	names, err := newNameMatcher("ru", nil)
+	require.NoError(t, err)
+	n, err := newNormalizer(NormalizationConfig{KeepBrackets: []string{"part", "часть"}, StopWords: []string{"the"}})
+	require.NoError(t, err)
+	names = names.withNormalizer(n)
+	assert.NotEqual(t, names.album("Кино", "Песни (Часть 1)"), names.album("Кино", "Песни (Часть 2)"))
+	assert.Equal(t, names.album("Kino", "Pesni Chast 1"), names.album("Кино", "Песни (Часть 1)"))
+	assert.Equal(t, []string{"wall", "part", "2"}, names.titleTokens("The Wall (Part II) [Remastered]"))
+
+	d := Differ{names: names}
+	normalization := d.Normalize("The Wall (Part II) [Remastered]")
+	assert.Equal(t, []NormalizedBracket{{Text: "(Part II)", Kept: true}, {Text: "[Remastered]", Kept: false}},
+		normalization.Brackets)
+	assert.Equal(t, []string{"the"}, normalization.StopWords)
+	assert.Equal(t, "wallpartii", normalization.Key)
+}
+
+func TestTitleSimilarity(t *testing.T) {
+	names, err := newNameMatcher("ru", nil)
+	require.NoError(t, err)
+	tests := []struct {
+		a, b  string
+		match bool
+	}{
+		{"Wish You Were Here Pt. 2", "Wish You Were Here Part II", true},
+		{"Rock & Roll", "Rock and Roll", true},
+		{"The Wall", "Wall", true},
+		{"Abbey Raod", "Abbey Road", true},
+		{"Группа крови", "Gruppa Krovi", true},
+		{"Volume 1", "Vol. I", true},
+		{"Volume 1", "Volume 2", false},
+		{"Part III", "Part 4", false},
+		{"Help!", "Revolver", false},
+		{"I Robot", "1 Robot", false},
+	}
+	for _, test := range tests {
+		t.Run(test.a+" vs "+test.b, func(t *testing.T) {
+			score := titleSimilarity(names.titleTokens(test.a), names.titleTokens(test.b))
+			if test.match {
+				assert.GreaterOrEqual(t, score, 0.85)
+			} else {
+				assert.Less(t, score, 0.85)
+			}
+		})
+	}
+}
+
+func TestAlbumIndex_Find(t *testing.T) {
+	names, err := newNameMatcher("ru", nil)
+	require.NoError(t, err)
+	index := newAlbumIndex(names, 0.85)
+	local := &MatchedAlbum{Local: &sqlc.LocalAlbumPublished{Artist: "Pink Floyd", Name: "The Dark Side of the Moon"}}
+	index.addLocal(names.album("Pink Floyd", "The Dark Side of the Moon"), "The Dark Side of the Moon", local)
+
+	match, score := index.find(names.album("Pink Floyd", "Dark Side of the Moon"), "Dark Side of the Moon")
+	assert.Same(t, local, match)
+	assert.Equal(t, 1.0, score)
+
+	match, score = index.find(names.album("Pink Floyd", "The Dark Side of the Mooon"), "The Dark Side of the Mooon")
+	assert.Same(t, local, match)
+	assert.Less(t, score, 1.0)
+
+	match, _ = index.find(names.album("Pink Moon", "The Dark Side of the Moon"), "The Dark Side of the Moon")
+	assert.Nil(t, match, "only albums of the same artist are candidates")
+
+	strict := newAlbumIndex(names, 1.1)
+	strict.addLocal(names.album("Pink Floyd", "The Dark Side of the Moon"), "The Dark Side of the Moon", local)
+	match, _ = strict.find(names.album("Pink Floyd", "The Dark Side of the Mooon"), "The Dark Side of the Mooon")
+	assert.Nil(t, match)
+	assert.Len(t, strict.all(), 1)
+}
+
+func TestMatcher_MatchAll(t *testing.T) {
+	names, err := newNameMatcher("ru", nil)
+	require.NoError(t, err)
+	d := Differ{cutoffYear: 2000, threshold: 0.85}
+	settings := map[string]ArtistSetting{
+		names.artistKey("Аквариум"): {ArtistName: "Аквариум", Notification: NotificationAlbumsOnly},
+		names.artistKey("Алиса"):    {ArtistName: "Алиса", Notification: NotificationDoNotTrack},
+	}
+	m := matcher{
+		names:     names,
+		locals:    newAlbumIndex(names, d.threshold),
+		overrides: matchOverrides{byActual: map[string]*MatchedAlbum{}, locals: map[*MatchedAlbum]sqlc.MatchOverride{}},
+		settings:  settings,
+		filters: d.releaseFilters(names, []string{"ДДТ"},
+			[]sqlc.ExcludedAlbum{{Artist: "Кино", Album: "Звезда по имени Солнце"}}, settings),
+	}
+	local := &MatchedAlbum{Local: &sqlc.LocalAlbumPublished{Artist: "Кино", Name: "Группа крови"}}
+	m.locals.addLocal(names.album("Кино", "Группа крови"), "Группа крови", local)
+
+	release := func(id, artist, name string, year int32, kind *string) sqlc.ActualAlbumPublished {
+		return sqlc.ActualAlbumPublished{ID: id, Artist: &artist, Name: &name, Year: &year, Kind: kind}
+	}
+	album, single := "Album", "Single"
+	matched := m.matchAll([]sqlc.ActualAlbumPublished{
+		release("1", "Кино", "Группа крови", 1988, &album),
+		release("2", "Кино", "Звезда по имени Солнце", 2024, &album),
+		release("3", "Кино", "Черный альбом", 1990, &album),
+		release("4", "Кино", "Черный альбом", 2024, &album),
+		release("5", "ДДТ", "Актриса Весна", 2024, &album),
+		release("6", "Алиса", "Шабаш", 2024, &album),
+		release("7", "Аквариум", "Пеликан", 2024, &single),
+		release("8", "Аквариум", "Без единого слова", 2024, nil),
+		release("9", "Аквариум", "Навигатор", 2024, &album),
+	})
+
+	reasons := make(map[string]FilterReason)
+	for _, album := range matched {
+		if album.Local != nil {
+			require.NotNil(t, album.Actual)
+			assert.Equal(t, "1", album.Actual.ID, "old releases still match local albums")
+			continue
+		}
+		reasons[album.Actual.ID] = album.Filtered
+	}
+	assert.Equal(t, map[string]FilterReason{
+		"2": FilterExcludedAlbum,
+		"3": FilterEdition,
+		"4": "",
+		"5": FilterExcludedArtist,
+		"6": FilterDoNotTrack,
+		"7": FilterOutOfScope,
+		"8": FilterOutOfScope,
+		"9": "",
+	}, reasons, "the release before the cutoff gives way to the reissue and becomes its edition")
+}
+
+func TestMatcher_ReleaseStates(t *testing.T) {
+	names, err := newNameMatcher("ru", nil)
+	require.NoError(t, err)
+	d := Differ{cutoffYear: 2000}
+	today := time.Date(2026, time.June, 1, 0, 0, 0, 0, time.UTC)
+	date := func(t time.Time) pgtype.Date { return pgtype.Date{Time: t, Valid: true} }
+	m := matcher{
+		names:     names,
+		locals:    newAlbumIndex(names, 0.85),
+		overrides: matchOverrides{byActual: map[string]*MatchedAlbum{}, locals: map[*MatchedAlbum]sqlc.MatchOverride{}},
+		states: activeReleaseStates([]sqlc.ReleaseState{
+			{ActualID: "1", State: string(LifecycleWishlist)},
+			{ActualID: "2", State: string(LifecycleIgnored)},
+			{ActualID: "3", State: string(LifecycleAcquired)},
+			{ActualID: "4", State: string(LifecycleSnoozed), Until: date(today.AddDate(0, 1, 0))},
+			{ActualID: "5", State: string(LifecycleSnoozed), Until: date(today)},
+		}, today),
+		filters: d.releaseFilters(names, nil, nil, nil),
+	}
+	release := func(id string, year int32) sqlc.ActualAlbumPublished {
+		artist, name := "Кино", "Альбом "+id
+		return sqlc.ActualAlbumPublished{ID: id, Artist: &artist, Name: &name, Year: &year}
+	}
+	matched := m.matchAll([]sqlc.ActualAlbumPublished{
+		release("1", 1990), release("2", 2024), release("3", 2024), release("4", 2024), release("5", 2024),
+	})
+
+	reasons := make(map[string]FilterReason)
+	for _, album := range matched {
+		reasons[album.Actual.ID] = album.Filtered
+		if album.Actual.ID == "1" {
+			require.NotNil(t, album.State)
+			assert.Equal(t, string(LifecycleWishlist), album.State.State)
+		}
+	}
+	assert.Equal(t, map[string]FilterReason{
+		"1": "",
+		"2": FilterIgnored,
+		"3": FilterAcquired,
+		"4": FilterSnoozed,
+		"5": "",
+	}, reasons, "wishlist releases pass the cutoff, snoozing ends on the date")
+}
+
+func TestMatcher_Editions(t *testing.T) {
+	names, err := newNameMatcher("ru", nil)
+	require.NoError(t, err)
+	d := Differ{cutoffYear: 2000}
+	release := func(id, name, group string, year int32, tracks int32) sqlc.ActualAlbumPublished {
+		artist := "Кино"
+		return sqlc.ActualAlbumPublished{ID: id, Artist: &artist, Name: &name, Year: &year,
+			ReleaseGroup: &group, Tracks: &tracks}
+	}
+	actuals := []sqlc.ActualAlbumPublished{
+		release("1", "Группа крови", "g1", 1988, 10),
+		release("2", "Группа крови (Deluxe)", "g1", 2012, 14),
+		release("3", "Gruppa Krovi Remastered", "g1", 2019, 10),
+		release("4", "Звезда по имени Солнце", "g2", 2024, 9),
+		release("5", "Legend", "g2", 2025, 12),
+	}
+	decide := func(reportEditions bool) map[string]FilterReason {
+		m := matcher{
+			names:     names,
+			locals:    newAlbumIndex(names, 0.85),
+			overrides: matchOverrides{byActual: map[string]*MatchedAlbum{}, locals: map[*MatchedAlbum]sqlc.MatchOverride{}},
+			filters:   d.releaseFilters(names, nil, nil, nil),
+
+			reportEditions: reportEditions,
+		}
+		tracks := int32(10)
+		local := &sqlc.LocalAlbumPublished{Artist: "Кино", Name: "Группа крови", Tracks: &tracks}
+		m.locals.addLocal(names.album("Кино", "Группа крови"), "Группа крови", &MatchedAlbum{Local: local})
+
+		reasons := make(map[string]FilterReason)
+		for _, album := range m.matchAll(slices.Clone(actuals)) {
+			if album.Local != nil {
+				assert.Equal(t, "1", album.Actual.ID, "the first release matches the local album")
+				continue
+			}
+			if album.Actual.ReleaseGroup != nil && *album.Actual.ReleaseGroup == "g1" {
+				assert.Same(t, local, album.EditionOf)
+			}
+			reasons[album.Actual.ID] = album.Filtered
+		}
+		return reasons
+	}
+
+	assert.Equal(t, map[string]FilterReason{
+		"2": FilterEdition,
+		"3": FilterEdition,
+		"4": "",
+		"5": FilterEdition,
+	}, decide(false), "editions of owned albums and new releases are filtered out")
+	assert.Equal(t, map[string]FilterReason{
+		"2": "",
+		"3": FilterEdition,
+		"4": "",
+		"5": FilterEdition,
+	}, decide(true), "editions with more tracks than the local copy are reported")
+}
+
+func TestMatcher_Collaborations(t *testing.T) {
+	names, err := newNameMatcher("ru", nil)
+	require.NoError(t, err)
+	d := Differ{cutoffYear: 2000, threshold: 0.85, collaborations: true}
+	settings := map[string]ArtistSetting{
+		names.artistKey("Кино"): {ArtistName: "Кино", Notification: NotificationAllReleases,
+			Collaborations: CollaborationsExclude},
+		names.artistKey("Аквариум"): {ArtistName: "Аквариум", Notification: NotificationAllReleases},
+	}
+	m := matcher{
+		names: names,
+		locals: d.localIndex(names, []sqlc.LocalAlbumPublished{
+			{Artist: "Кино", Name: "Группа крови"},
+			{Artist: "Аквариум", Name: "Радио Африка"},
+			{Artist: "ДДТ & Кино", Name: "Революция"},
+			{Artist: "Nick Cave", Name: "Idiot Prayer"},
+			{Artist: "Nick Cave & The Bad Seeds", Name: "Murder Ballads"},
+		}),
+		overrides: matchOverrides{byActual: map[string]*MatchedAlbum{}, locals: map[*MatchedAlbum]sqlc.MatchOverride{}},
+		settings:  settings,
+		filters:   d.releaseFilters(names, nil, nil, settings),
+	}
+	release := func(id, artist, credit, name string, credited ...string) sqlc.ActualAlbumPublished {
+		year := int32(2024)
+		return sqlc.ActualAlbumPublished{ID: id, Artist: &artist, Credit: &credit, Credited: credited, Name: &name, Year: &year}
+	}
+	matched := m.matchAll([]sqlc.ActualAlbumPublished{
+		release("1", "Кино", "ДДТ & Кино", "Революция", "ДДТ", "Кино"),
+		release("2", "Кино", "Кино feat. ДДТ", "Сказка", "Кино", "ДДТ"),
+		release("3", "Кино", "Кино & Аквариум", "Концерт", "Кино", "Аквариум"),
+		release("4", "Кино", "Кино & Аквариум", "Радио Африка", "Кино", "Аквариум"),
+		release("5", "Simon & Garfunkel", "Simon & Garfunkel", "Old Friends", "Simon & Garfunkel"),
+		release("6", "Nick Cave & The Bad Seeds", "Nick Cave & The Bad Seeds", "Murder Ballads", "Nick Cave & The Bad Seeds"),
+		release("7", "Nick Cave & The Bad Seeds", "Nick Cave & The Bad Seeds", "Ghosteen", "Nick Cave & The Bad Seeds"),
+		release("8", "Кино", "Кино & Nick Cave", "Carnage", "Кино", "Nick Cave"),
+	})
+
+	owned := make(map[string]string)
+	reasons := make(map[string]FilterReason)
+	for _, album := range matched {
+		switch {
+		case album.Actual == nil:
+		case album.Local != nil:
+			owned[album.Actual.ID] = album.Local.Artist
+		default:
+			reasons[album.Actual.ID] = album.Filtered
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"1": "ДДТ & Кино",
+		"4": "Аквариум",
+		"6": "Nick Cave & The Bad Seeds",
+	}, owned, "collaborations are found by the credited artists and the whole credit, bands by their name")
+	assert.Equal(t, map[string]FilterReason{
+		"2": FilterCollaboration,
+		"3": "",
+		"5": "",
+		"7": "",
+		"8": "",
+	}, reasons, "collaborations are reported for every followed credited artist taking them")
+}
+
+func TestDiffer_Decide(t *testing.T) {
+	d := Differ{cutoffYear: 2000, threshold: 0.85}
+	year := int32(1988)
+	local := &sqlc.LocalAlbumPublished{Artist: "Кино", Name: "Группа крови"}
+	actual := sqlc.ActualAlbumPublished{Year: &year}
+
+	assert.Contains(t, d.decide(actual, ActualDecision{Local: local, Score: 1}, nil), "owned")
+	assert.Contains(t, d.decide(actual, ActualDecision{Local: local, Override: true}, nil), "by an override")
+	assert.Contains(t, d.decide(actual, ActualDecision{Filtered: FilterCutoff}, nil), "before the since year")
+	assert.Contains(t, d.decide(actual, ActualDecision{Filtered: FilterUnknownYear}, nil), "year is unknown")
+	assert.Contains(t, d.decide(actual, ActualDecision{Filtered: FilterEdition, EditionOf: local}, nil), "an edition of local")
+	assert.Contains(t, d.decide(actual, ActualDecision{Score: 0.6}, nil), "below the threshold")
+	assert.Contains(t, d.decide(actual, ActualDecision{}, nil), "no local album")
+}
+
+func TestAlbumIndex_Remove(t *testing.T) {
+	names, err := newNameMatcher("ru", nil)
+	require.NoError(t, err)
+	index := newAlbumIndex(names, 0.85)
+	untitled := &MatchedAlbum{Local: &sqlc.LocalAlbumPublished{Artist: "Кино", Name: "Untitled"}}
+	index.addLocal(names.album("Кино", "Untitled"), "Untitled", untitled)
+	index.add(names.album("Кино", "Без названия"), untitled)
+
+	assert.Nil(t, index.remove(names.album("Кино", "Группа крови")))
+	assert.Same(t, untitled, index.remove(names.album("КИНО", "untitled")))
+	match, _ := index.find(names.album("Кино", "Untitled"), "Untitled")
+	assert.Nil(t, match, "removed albums are not matched automatically")
+	match, _ = index.find(names.album("Кино", "Без названия"), "Без названия")
+	assert.Nil(t, match, "removed albums are not matched by other keys")
+	assert.Empty(t, index.all())
+}
+
+func TestDiffer_SinceYear(t *testing.T) {
+	names, err := newNameMatcher("ru", nil)
+	require.NoError(t, err)
+	d := Differ{cutoffYear: 2020}
+	since, err := parseSince("1985")
+	require.NoError(t, err)
+	settings := map[string]ArtistSetting{
+		names.artistKey("Кино"): {ArtistName: "Кино", Notification: NotificationAllReleases, Since: since},
+	}
+	m := matcher{filters: d.releaseFilters(names, nil, nil, settings)}
+	filter := func(artist string, year *int32) FilterReason {
+		name := "Album"
+		actual := sqlc.ActualAlbumPublished{Artist: &artist, Name: &name, Year: year}
+		return m.filter(&actual, names.album(artist, name))
+	}
+	year := func(y int32) *int32 { return &y }
+
+	assert.Empty(t, filter("Кино", year(1985)), "releases in the year are kept")
+	assert.Equal(t, FilterCutoff, filter("Кино", year(1984)))
+	assert.Equal(t, FilterCutoff, filter("ДДТ", year(2019)), "the cutoff year applies to other artists")
+	assert.Empty(t, filter("ДДТ", year(2020)))
+	assert.Equal(t, FilterUnknownYear, filter("ДДТ", year(0)))
+	assert.Equal(t, FilterUnknownYear, filter("ДДТ", nil))
+
+	d.keepUnknownYear = true
+	m.filters = d.releaseFilters(names, nil, nil, settings)
+	assert.Empty(t, filter("ДДТ", year(0)))
+}
+
+func TestParseSince(t *testing.T) {
+	for _, raw := range []string{"", "1985"} {
+		since, err := parseSince(raw)
+		require.NoError(t, err)
+		assert.Equal(t, raw, formatSince(since))
+	}
+	for _, raw := range []string{"last year", "1985-06", "1985-06-15"} {
+		_, err := parseSince(raw)
+		assert.Error(t, err, "releases are only known to the year")
+	}
+}
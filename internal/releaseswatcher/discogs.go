@@ -11,6 +11,7 @@ import (
 
 	"github.com/irlndts/go-discogs"
 	"github.com/pochemuto/releases-watcher/sqlc"
+	"go.uber.org/thriftrw/ptr"
 	"golang.org/x/time/rate"
 )
 
@@ -26,29 +27,41 @@ type DiscogsLibrary struct {
 	cached  *cached
 }
 
+const discogsURL = "https://api.discogs.com"
+
 type DiscogsConfig struct {
 	Token string
 }
 
-func NewDiscogsLibrary(config DiscogsConfig, db DB, cache Cache) (DiscogsLibrary, error) {
+func NewDiscogsLibrary(config DiscogsConfig, db DB, cache Cache, fixtures HTTPFixtures) (DiscogsLibrary, error) {
 	if config.Token == "" {
 		return DiscogsLibrary{}, errors.New("token is empty")
 	}
+	endpoint, err := fixtures.Endpoint("discogs", discogsURL)
+	if err != nil {
+		return DiscogsLibrary{}, err
+	}
 	client, err := discogs.New(&discogs.Options{
 		UserAgent: "Releases Watcher",
 		Token:     config.Token,
-		URL:       "https://api.discogs.com", // optional
+		URL:       endpoint,
 	})
 	if err != nil {
 		return DiscogsLibrary{}, err
 	}
-	return DiscogsLibrary{
+	limiter := rate.NewLimiter(50*rate.Every(time.Minute), 1)
+	if fixtures.Offline() {
+		limiter = rate.NewLimiter(rate.Inf, 1)
+	}
+	library := DiscogsLibrary{
 		db:      db,
 		cache:   cache,
 		discogs: client,
-		limiter: rate.NewLimiter(50*rate.Every(time.Minute), 1),
+		limiter: limiter,
 		cached:  &cached{},
-	}, nil
+	}
+	library.registerRefreshers()
+	return library, nil
 }
 
 func (l DiscogsLibrary) Name() string {
@@ -62,10 +75,9 @@ func (l DiscogsLibrary) api(ctx context.Context) discogs.Discogs {
 
 func (l DiscogsLibrary) getRelease(ctx context.Context, releaseID int) (*discogs.Release, error) {
 	id := strconv.Itoa(releaseID)
-	freshness := 10 * 24 * time.Hour
 	if l.cached.releases == nil {
 		var err error
-		l.cached.releases, err = GetAllCacheEntities[discogs.Release](l.cache, context.TODO(), "discogs_release", freshness)
+		l.cached.releases, err = GetAllCacheEntities[discogs.Release](l.cache, context.TODO(), EntityDiscogsRelease)
 		if err != nil {
 			return nil, err
 		}
@@ -75,17 +87,31 @@ func (l DiscogsLibrary) getRelease(ctx context.Context, releaseID int) (*discogs
 		log.Tracef("Loaded release %d from cache", releaseID)
 		return release, nil
 	}
-	return GetCached(l.cache, context.TODO(), "discogs_release", id,
-		freshness, func() (*discogs.Release, error) {
+	return GetCached(l.cache, context.TODO(), EntityDiscogsRelease, id,
+		func() (*discogs.Release, error) {
 			return l.api(ctx).Release(releaseID)
 		})
 }
 
+func (l DiscogsLibrary) searchArtist(ctx context.Context, artist string) (*discogs.Search, error) {
+	request := discogs.SearchRequest{Type: "artist", Q: artist, PerPage: 300}
+	search, err := l.api(ctx).Search(request)
+	if err != nil {
+		return nil, err
+	}
+	if len(search.Results) == 0 {
+		return nil, ErrNoResult
+	}
+	return search, nil
+}
+
 func (l DiscogsLibrary) getArtistID(ctx context.Context, artist string) (int, error) {
-	search, err := GetCached(l.cache, ctx, "discogs_artist_search", artist, 10*24*time.Hour, func() (*discogs.Search, error) {
-		request := discogs.SearchRequest{Type: "artist", Q: artist, PerPage: 300}
-		return l.api(ctx).Search(request)
+	search, err := GetCached(l.cache, ctx, EntityDiscogsArtistSearch, artist, func() (*discogs.Search, error) {
+		return l.searchArtist(ctx, artist)
 	})
+	if errors.Is(err, ErrNoResult) {
+		return 0, errors.New("Artist '" + artist + "' not found")
+	}
 	if err != nil {
 		return 0, err
 	}
@@ -95,13 +121,67 @@ func (l DiscogsLibrary) getArtistID(ctx context.Context, artist string) (int, er
 	return search.Results[0].ID, nil
 }
 
+func (l DiscogsLibrary) fetchArtistReleases(ctx context.Context, artistID int, page int) (*discogs.ArtistReleases, error) {
+	return l.api(ctx).ArtistReleases(artistID,
+		&discogs.Pagination{Page: page, PerPage: 500, Sort: "year", SortOrder: "asc"})
+}
+
 func (l DiscogsLibrary) getArtistReleases(ctx context.Context, artistID int, page int) (*discogs.ArtistReleases, error) {
-	id := fmt.Sprintf("%d_%d", artistID, page)
-	return GetCached(l.cache, ctx, "discord_artist_releases",
-		id, 10*24*time.Hour, func() (*discogs.ArtistReleases, error) {
-			return l.api(ctx).ArtistReleases(artistID,
-				&discogs.Pagination{Page: page, PerPage: 500, Sort: "year", SortOrder: "asc"})
+	id := artistReleasesCacheKey(artistID, page)
+	return GetCached(l.cache, ctx, EntityDiscogsArtistReleases,
+		id, func() (*discogs.ArtistReleases, error) {
+			return l.fetchArtistReleases(ctx, artistID, page)
+		})
+}
+
+func artistReleasesCacheKey(artistID int, page int) string {
+	return fmt.Sprintf("%d_%d", artistID, page)
+}
+
+func parseArtistReleasesCacheKey(key string) (int, int, error) {
+	artist, page, ok := strings.Cut(key, "_")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid artist releases cache key %q", key)
+	}
+	artistID, err := strconv.Atoi(artist)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid artist releases cache key %q: %w", key, err)
+	}
+	pageNumber, err := strconv.Atoi(page)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid artist releases cache key %q: %w", key, err)
+	}
+	return artistID, pageNumber, nil
+}
+
+// registerRefreshers lets the cache refresh stale Discogs entries in the background.
+func (l DiscogsLibrary) registerRefreshers() {
+	l.cache.RegisterRefresher(EntityDiscogsRelease, func(ctx context.Context, id string) error {
+		releaseID, err := strconv.Atoi(id)
+		if err != nil {
+			return fmt.Errorf("invalid release id %q: %w", id, err)
+		}
+		_, err = RefreshCached(l.cache, ctx, EntityDiscogsRelease, id, func() (*discogs.Release, error) {
+			return l.api(ctx).Release(releaseID)
+		})
+		return err
+	})
+	l.cache.RegisterRefresher(EntityDiscogsArtistSearch, func(ctx context.Context, id string) error {
+		_, err := RefreshCached(l.cache, ctx, EntityDiscogsArtistSearch, id, func() (*discogs.Search, error) {
+			return l.searchArtist(ctx, id)
+		})
+		return err
+	})
+	l.cache.RegisterRefresher(EntityDiscogsArtistReleases, func(ctx context.Context, id string) error {
+		artistID, page, err := parseArtistReleasesCacheKey(id)
+		if err != nil {
+			return err
+		}
+		_, err = RefreshCached(l.cache, ctx, EntityDiscogsArtistReleases, id, func() (*discogs.ArtistReleases, error) {
+			return l.fetchArtistReleases(ctx, artistID, page)
 		})
+		return err
+	})
 }
 
 func (l DiscogsLibrary) getReleases(ctx context.Context, artist string) ([]discogs.Release, error) {
@@ -123,12 +203,8 @@ func (l DiscogsLibrary) getReleases(ctx context.Context, artist string) ([]disco
 				if err != nil {
 					return nil, err
 				}
-				if isCompilation(release) {
-					continue
-				}
-				properType := isAlbum(release) || isEP(release) || isSingle(release)
-				mainArtist := isMainArtist(release, artistID)
-				if properType && mainArtist {
+				// releases of any kind are kept, the notification setting of the artist decides
+				if isMainArtist(release, artistID) {
 					releases = append(releases, *release)
 				}
 			}
@@ -159,72 +235,104 @@ func (l DiscogsLibrary) GetActualAlbumsForArtists(ctx context.Context, artists [
 			continue
 		}
 		for _, release := range releases {
-			kind := getKind(release)
-			if kind == "" {
-				log.Tracef("Unknown kind of release %v, skipped", release)
-				continue
-			}
+			kind := discogsKind(release)
 			if isSoundtrack(release) {
 				log.Tracef("Release %v is a soundtrack, skipped", release)
 				continue
 			}
 			year := int32(release.Year)
+			kindName := kind.String()
 			actualAlbum := sqlc.ActualAlbum{
 				ID:     fmt.Sprint(release.ID),
 				Artist: &artist,
 				Name:   &release.Title,
 				Year:   &year,
-				Kind:   &kind,
+				Kind:   &kindName,
+				Tracks: discogsTracks(release),
 			}
-			out <- actualAlbum
-		}
-	}
-}
-
-func isReleaseType(release *discogs.Release, releaseType string) bool {
-	for _, format := range release.Formats {
-		for _, desc := range format.Descriptions {
-			if strings.ToLower(desc) == releaseType {
-				return true
+			if credit := discogsCredit(release); credit != "" {
+				actualAlbum.Credit = &credit
+			}
+			actualAlbum.Credited = discogsCredited(release)
+			if release.MasterID != 0 {
+				actualAlbum.ReleaseGroup = ptr.String(fmt.Sprint(release.MasterID))
 			}
+			out <- actualAlbum
 		}
 	}
-	return false
 }
 
-func isAlbum(release *discogs.Release) bool {
-	return isReleaseType(release, "album")
-}
-
-func isEP(release *discogs.Release) bool {
-	return isReleaseType(release, "ep")
-}
-
-func isSingle(release *discogs.Release) bool {
-	return isReleaseType(release, "single")
+// isMainArtist returns whether the artist is credited for the release, collaborations included.
+func isMainArtist(release *discogs.Release, artistID int) bool {
+	return slices.ContainsFunc(release.Artists, func(artist discogs.ArtistSource) bool {
+		return artist.ID == artistID
+	})
 }
 
-func isCompilation(release *discogs.Release) bool {
-	return isReleaseType(release, "compilation")
+// discogsCredit writes the artists of the release joined as Discogs shows them, e.g. "A & B Feat. C".
+func discogsCredit(release discogs.Release) string {
+	var b strings.Builder
+	for i, artist := range release.Artists {
+		b.WriteString(artist.Name)
+		if i == len(release.Artists)-1 {
+			break
+		}
+		switch join := strings.TrimSpace(artist.Join); join {
+		case "", ",":
+			b.WriteString(join + " ")
+		default:
+			b.WriteString(" " + join + " ")
+		}
+	}
+	return b.String()
 }
 
-func isMainArtist(release *discogs.Release, artistID int) bool {
-	return release.Artists[0].ID == artistID
+// discogsCredited returns the names of the artists of the release.
+func discogsCredited(release discogs.Release) []string {
+	var names []string
+	for _, artist := range release.Artists {
+		names = append(names, artist.Name)
+	}
+	return names
 }
 
 func isSoundtrack(release discogs.Release) bool {
 	return slices.Contains(release.Styles, "Soundtrack")
 }
 
-func getKind(release discogs.Release) string {
-	if isAlbum(&release) {
-		return "album"
+// discogsTracks counts the tracks of the tracklist without headings, nil without a tracklist.
+func discogsTracks(release discogs.Release) *int32 {
+	if len(release.Tracklist) == 0 {
+		return nil
+	}
+	tracks := int32(0)
+	for _, track := range release.Tracklist {
+		if track.Type == "" || track.Type == "track" {
+			tracks++
+		}
 	}
-	if isSingle(&release) {
-		return "single"
+	return &tracks
+}
+
+// discogsKindOrder is the precedence of kinds given by several format descriptions,
+// e.g. "LP", "Album", "Compilation" is a compilation
+var discogsKindOrder = []Kind{KindCompilation, KindLive, KindAlbum, KindSingle, KindEP, KindBroadcast}
+
+// discogsKind maps format descriptions of the release to the kind through ParseKind, descriptions are in any case.
+// Releases described only by other terms, e.g. "Reissue", are KindOther.
+func discogsKind(release discogs.Release) Kind {
+	kinds := make(map[Kind]bool)
+	for _, format := range release.Formats {
+		for _, desc := range format.Descriptions {
+			if kind, ok := ParseKind(desc); ok {
+				kinds[kind] = true
+			}
+		}
 	}
-	if isEP(&release) {
-		return "EP"
+	for _, kind := range discogsKindOrder {
+		if kinds[kind] {
+			return kind
+		}
 	}
-	return ""
+	return KindOther
 }
@@ -0,0 +1,29 @@
+package releaseswatcher
+
+import (
+	"testing"
+
+	"github.com/irlndts/go-discogs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiscogsKind(t *testing.T) {
+	tests := []struct {
+		descriptions []string
+		expected     Kind
+	}{
+		{[]string{"LP", "Album"}, KindAlbum},
+		{[]string{"LP"}, KindAlbum},
+		{[]string{"album", "Compilation"}, KindCompilation},
+		{[]string{"Mini-Album"}, KindEP},
+		{[]string{"EP"}, KindEP},
+		{[]string{"Maxi-Single"}, KindSingle},
+		{[]string{"45 RPM", "Single"}, KindSingle},
+		{[]string{"Reissue"}, KindOther},
+		{nil, KindOther},
+	}
+	for _, tt := range tests {
+		release := discogs.Release{Formats: []discogs.Format{{Name: "Vinyl", Descriptions: tt.descriptions}}}
+		assert.Equal(t, tt.expected, discogsKind(release), "%v", tt.descriptions)
+	}
+}
@@ -0,0 +1,257 @@
+package releaseswatcher
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/pochemuto/releases-watcher/sqlc"
+)
+
+// explainMinScore hides albums unrelated to the explained title
+const explainMinScore = 0.5
+
+// LocalCandidate is a local album of the explained artist.
+type LocalCandidate struct {
+	Album sqlc.LocalAlbumPublished
+	Key   sqlc.LocalAlbumPublished
+	// Score is the similarity to the explained title, 1 without a title
+	Score float64
+	// Override matches the album by hand
+	Override *sqlc.MatchOverride
+}
+
+// ActualDecision is what the differ decided about an actual album of the explained artist.
+type ActualDecision struct {
+	Album sqlc.ActualAlbumPublished
+	Key   sqlc.LocalAlbumPublished
+	// Local is the matched local album
+	Local *sqlc.LocalAlbumPublished
+	// Score is the similarity to the matched local album or to the best candidate below the threshold
+	Score float64
+	// Override is set when the release is matched by hand
+	Override bool
+	// Filtered is why the new release is not reported
+	Filtered FilterReason
+	// State is the lifecycle state of the release set by hand
+	State *sqlc.ReleaseState
+	// EditionOf is the owned album of the same release group
+	EditionOf *sqlc.LocalAlbumPublished
+	Decision  string
+}
+
+type Explanation struct {
+	Artist    string
+	Album     string
+	ArtistKey string
+	AlbumKey  string
+	Tokens    []string
+	// Names are the names of the artist found in the albums and aliases
+	Names []string
+	// ExcludedArtist is neither fetched by actual updates nor reported
+	ExcludedArtist bool
+	Setting        *ArtistSetting
+	Threshold      float64
+	// SinceYear is the earliest year of reported releases of the artist
+	SinceYear int32
+	// Collaborations are reported for the artist
+	Collaborations bool
+	Local          []LocalCandidate
+	Actual         []ActualDecision
+}
+
+// Explain shows how albums of the artist are matched, the album narrows it down to similar titles.
+func (d Differ) Explain(ctx context.Context, artist string, album string) (Explanation, error) {
+	m, err := d.newMatcher(ctx)
+	if err != nil {
+		return Explanation{}, err
+	}
+	names := m.names
+	key := names.album(artist, album)
+	tokens := names.titleTokens(album)
+	explanation := Explanation{
+		Artist:    artist,
+		Album:     album,
+		ArtistKey: key.Artist,
+		AlbumKey:  key.Name,
+		Tokens:    tokens,
+		Threshold: d.threshold,
+	}
+	addName := func(name string) {
+		if !slices.Contains(explanation.Names, name) {
+			explanation.Names = append(explanation.Names, name)
+		}
+	}
+	// collaborations are explained for each of the credited artists
+	credited := func(actual sqlc.ActualAlbumPublished) bool {
+		artists := append([]string{*actual.Artist}, creditedArtists(&actual)...)
+		if actual.Credit != nil {
+			artists = append(artists, *actual.Credit)
+		}
+		return slices.ContainsFunc(artists, func(artist string) bool {
+			return names.artistKey(artist) == key.Artist
+		})
+	}
+	similarity := func(name string) float64 {
+		if album == "" {
+			return 1
+		}
+		return titleSimilarity(tokens, names.titleTokens(name))
+	}
+
+	aliases, err := d.db.GetArtistAliases(ctx)
+	if err != nil {
+		return Explanation{}, fmt.Errorf("error loading artist aliases: %w", err)
+	}
+	for _, alias := range aliases {
+		if names.artistKey(alias.Artist) == key.Artist {
+			addName(alias.Artist)
+		}
+		if names.artistKey(alias.Alias) == key.Artist {
+			addName(alias.Alias)
+		}
+	}
+	excludedArtists, err := d.db.GetExcludedArtists(ctx)
+	if err != nil {
+		return Explanation{}, fmt.Errorf("error loading excluded artists: %w", err)
+	}
+	for _, excluded := range excludedArtists {
+		if names.artistKey(excluded) == key.Artist {
+			explanation.ExcludedArtist = true
+		}
+	}
+	setting, ok := m.settings[key.Artist]
+	if ok {
+		explanation.Setting = &setting
+	}
+	explanation.SinceYear = d.sinceYear(setting)
+	explanation.Collaborations = d.takesCollaborations(setting)
+
+	locals := m.locals.all()
+	for local := range m.overrides.locals {
+		locals = append(locals, local)
+	}
+	for _, match := range locals {
+		local := *match.Local
+		localKey := names.album(local.Artist, local.Name)
+		if localKey.Artist != key.Artist {
+			continue
+		}
+		addName(local.Artist)
+		candidate := LocalCandidate{Album: local, Key: localKey, Score: similarity(local.Name)}
+		if override, ok := m.overrides.locals[match]; ok {
+			candidate.Override = &override
+		}
+		if candidate.Score >= explainMinScore {
+			explanation.Local = append(explanation.Local, candidate)
+		}
+	}
+	slices.SortStableFunc(explanation.Local, func(a, b LocalCandidate) int {
+		return compareScores(a.Score, b.Score)
+	})
+
+	actuals, err := d.db.GetActualAlbums(ctx)
+	if err != nil {
+		return Explanation{}, fmt.Errorf("error loading actual albums: %w", err)
+	}
+	var decisions []ActualDecision
+	for _, actual := range actuals {
+		actualKey := names.album(*actual.Artist, *actual.Name)
+		if !credited(actual) || similarity(*actual.Name) < explainMinScore {
+			continue
+		}
+		addName(*actual.Artist)
+		decision := ActualDecision{Album: actual, Key: actualKey}
+		if state, ok := m.states[actual.ID]; ok {
+			decision.State = &state
+		}
+		// scores are taken before matchAll adds new releases to the index
+		_, decision.Score, decision.Override = m.match(&actual, actualKey)
+		decisions = append(decisions, decision)
+	}
+	results := make(map[string]MatchedAlbum)
+	for _, album := range m.matchAll(actuals) {
+		if album.Actual != nil {
+			results[album.Actual.ID] = album
+		}
+	}
+	for _, decision := range decisions {
+		result := results[decision.Album.ID]
+		decision.Local, decision.Filtered, decision.EditionOf = result.Local, result.Filtered, result.EditionOf
+		// the reason of a filtered release is of the artist of its key, see filterCredited
+		decision.Decision = d.decide(decision.Album, decision, m.setting(decision.Key.Artist))
+		explanation.Actual = append(explanation.Actual, decision)
+	}
+	slices.SortStableFunc(explanation.Actual, func(a, b ActualDecision) int {
+		return compareScores(similarity(*a.Album.Name), similarity(*b.Album.Name))
+	})
+	return explanation, nil
+}
+
+// decide describes the decision of Matched about the release, the setting is of the artist
+// the filters were applied for, nil when the artist has no setting.
+func (d Differ) decide(actual sqlc.ActualAlbumPublished, decision ActualDecision, setting *ArtistSetting) string {
+	if setting == nil {
+		setting = &ArtistSetting{}
+		if actual.Artist != nil {
+			setting.ArtistName = *actual.Artist
+		}
+	}
+	switch {
+	case decision.Override:
+		return fmt.Sprintf("owned: matches local %q by an override", decision.Local.Name)
+	case decision.Local != nil:
+		return fmt.Sprintf("owned: matches local %q with score %.2f", decision.Local.Name, decision.Score)
+	}
+	switch decision.Filtered {
+	case FilterIgnored:
+		return "skipped: the release is ignored"
+	case FilterAcquired:
+		return "skipped: the release is acquired"
+	case FilterSnoozed:
+		return fmt.Sprintf("skipped: the release is %s", formatReleaseState(*decision.State))
+	case FilterUnknownYear:
+		return "skipped: the release year is unknown"
+	case FilterCutoff:
+		return fmt.Sprintf("skipped: released in %d before the since year %d of %s", *actual.Year,
+			d.sinceYear(*setting), setting.ArtistName)
+	case FilterExcludedArtist:
+		return "skipped: the artist is excluded"
+	case FilterExcludedAlbum:
+		return "skipped: the album is excluded"
+	case FilterDoNotTrack:
+		return fmt.Sprintf("skipped: %s is set to %q", setting.ArtistName, setting.Notification)
+	case FilterOutOfScope:
+		kind, _ := KindOf(&actual)
+		return fmt.Sprintf("skipped: %s is out of scope of %q of %s", kind, setting.Notification, setting.ArtistName)
+	case FilterCollaboration:
+		return fmt.Sprintf("skipped: collaborations of the credited artists are excluded, credited to %q", *actual.Credit)
+	case FilterEdition:
+		if decision.EditionOf != nil {
+			return fmt.Sprintf("skipped: an edition of local %q", decision.EditionOf.Name)
+		}
+		return "skipped: an edition of a new release"
+	}
+	if decision.EditionOf != nil && hasExtraTracks(&actual, decision.EditionOf) {
+		return fmt.Sprintf("new: an edition of local %q with %d tracks instead of %d",
+			decision.EditionOf.Name, *actual.Tracks, *decision.EditionOf.Tracks)
+	}
+	if decision.State != nil && Lifecycle(decision.State.State) == LifecycleWishlist {
+		return "new: the release is on the wishlist"
+	}
+	if decision.Score > 0 {
+		return fmt.Sprintf("new: the best local candidate scores %.2f below the threshold %.2f", decision.Score, d.threshold)
+	}
+	return "new: no local album is similar"
+}
+
+// compareScores orders higher scores first.
+func compareScores(a float64, b float64) int {
+	switch {
+	case a > b:
+		return -1
+	case a < b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,136 @@
+package releaseswatcher
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pochemuto/releases-watcher/sqlc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/thriftrw/ptr"
+)
+
+// setupExplain publishes the local and actual albums and returns a differ reading them with the settings.
+func setupExplain(t *testing.T, locals []sqlc.LocalAlbum, actuals []sqlc.ActualAlbum, settings ...ArtistSetting) (Differ, Storage) {
+	ctx := context.Background()
+	store := setupTestCache(t, CacheConfig{}).store
+	db, err := NewDB(store)
+	require.NoError(t, err)
+
+	local, err := db.CreateLocalVersion(ctx)
+	require.NoError(t, err)
+	for i := range locals {
+		locals[i].VersionID = local.VersionID
+	}
+	require.NoError(t, db.WriteLocalAlbums(ctx, locals))
+	require.NoError(t, db.PublishLocalVersion(ctx, local))
+
+	actual, err := db.CreateActualVersion(ctx)
+	require.NoError(t, err)
+	require.NoError(t, db.WriteActualAlbums(ctx, actual, "", actuals))
+	require.NoError(t, db.PublishActualVersion(ctx, actual))
+
+	settingsStore := NewDBArtistSettings(db)
+	for _, setting := range settings {
+		require.NoError(t, settingsStore.SetArtistSetting(ctx, setting))
+	}
+	differ, err := NewDiffer(db, DifferConfig{CutoffYear: 2000, Transliteration: "ru", MatchThreshold: 0.85, Collaborations: true}, settingsStore)
+	require.NoError(t, err)
+	return differ, store
+}
+
+func TestDiffer_ExplainCollaboration(t *testing.T) {
+	differ, _ := setupExplain(t,
+		[]sqlc.LocalAlbum{{Artist: "Artist A", Name: "Album X"}},
+		[]sqlc.ActualAlbum{{ID: "1", Artist: ptr.String("Artist A"), Name: ptr.String("Together"), Year: ptr.Int32(2020),
+			Kind: ptr.String("Single"), Credit: ptr.String("Artist A & Artist B"), Credited: []string{"Artist A", "Artist B"}}},
+		ArtistSetting{ArtistName: "Artist A", Notification: NotificationAlbumsOnly},
+	)
+
+	explanation, err := differ.Explain(context.Background(), "Artist B", "")
+	require.NoError(t, err)
+	assert.Nil(t, explanation.Setting, "the explained artist has no setting")
+	require.Len(t, explanation.Actual, 1)
+	decision := explanation.Actual[0]
+	assert.Equal(t, FilterOutOfScope, decision.Filtered)
+	assert.Equal(t, `skipped: Single is out of scope of "Альбомы" of Artist A`, decision.Decision,
+		"the reason names the setting of the artist it came from")
+}
+
+func TestDiffer_Explain(t *testing.T) {
+	ctx := context.Background()
+	differ, store := setupExplain(t,
+		[]sqlc.LocalAlbum{
+			{Artist: "Кино", Name: "Группа крови"},
+			{Artist: "Кино", Name: "Звезда по имени Солнце"},
+			{Artist: "Аквариум", Name: "Радио Африка"},
+		},
+		[]sqlc.ActualAlbum{
+			{ID: "1", Artist: ptr.String("Kino"), Name: ptr.String("Gruppa Krovi"), Year: ptr.Int32(1988), Kind: ptr.String("Album")},
+			{ID: "2", Artist: ptr.String("Кино"), Name: ptr.String("Чёрный альбом"), Year: ptr.Int32(2020), Kind: ptr.String("Album")},
+			{ID: "3", Artist: ptr.String("Кино"), Name: ptr.String("Легенда"), Year: ptr.Int32(2021), Kind: ptr.String("Single")},
+			{ID: "4", Artist: ptr.String("Кино"), Name: ptr.String("Последний герой"), Year: ptr.Int32(2021), Kind: ptr.String("Album")},
+			{ID: "5", Artist: ptr.String("Кино"), Name: ptr.String("Кинопробы"), Year: ptr.Int32(2022), Kind: ptr.String("Album")},
+			{ID: "6", Artist: ptr.String("Аквариум"), Name: ptr.String("Равноденствие"), Year: ptr.Int32(2022), Kind: ptr.String("Album")},
+		},
+		ArtistSetting{ArtistName: "Кино", Notification: NotificationAlbumsOnly},
+	)
+	execSQL(t, store, `INSERT INTO excluded_album (artist, album) VALUES ('Кино', 'Чёрный альбом')`)
+	db, err := NewDB(store)
+	require.NoError(t, err)
+	require.NoError(t, db.SetMatchOverride(ctx, sqlc.MatchOverride{LocalArtist: "Кино", LocalAlbum: "Звезда по имени Солнце", ActualID: ptr.String("4")}))
+
+	explanation, err := differ.Explain(ctx, "KINO", "")
+	require.NoError(t, err)
+	assert.Equal(t, "kino", explanation.ArtistKey)
+	assert.Equal(t, []string{"Кино", "Kino"}, explanation.Names)
+	require.NotNil(t, explanation.Setting)
+	assert.Equal(t, NotificationAlbumsOnly, explanation.Setting.Notification)
+	assert.Equal(t, int32(2000), explanation.SinceYear)
+
+	require.Len(t, explanation.Local, 2, "albums of other artists are not candidates")
+	assert.Equal(t, sqlc.LocalAlbumPublished{Artist: "kino", Name: "gruppakrovi"}, explanation.Local[0].Key)
+	assert.Nil(t, explanation.Local[0].Override)
+	assert.Equal(t, sqlc.LocalAlbumPublished{Artist: "kino", Name: "zvezdapoimenisolntse"}, explanation.Local[1].Key)
+	require.NotNil(t, explanation.Local[1].Override)
+	assert.Equal(t, ptr.String("4"), explanation.Local[1].Override.ActualID)
+
+	decisions := make(map[string]ActualDecision)
+	for _, decision := range explanation.Actual {
+		decisions[decision.Album.ID] = decision
+	}
+	require.Len(t, decisions, 5, "releases of other artists are not explained")
+
+	transliterated := decisions["1"]
+	assert.Equal(t, sqlc.LocalAlbumPublished{Artist: "kino", Name: "gruppakrovi"}, transliterated.Key)
+	assert.Equal(t, 1.0, transliterated.Score)
+	require.NotNil(t, transliterated.Local)
+	assert.Equal(t, "Группа крови", transliterated.Local.Name)
+	assert.Equal(t, `owned: matches local "Группа крови" with score 1.00`, transliterated.Decision)
+
+	excluded := decisions["2"]
+	assert.Equal(t, FilterExcludedAlbum, excluded.Filtered)
+	assert.Equal(t, "skipped: the album is excluded", excluded.Decision)
+
+	single := decisions["3"]
+	assert.Equal(t, FilterOutOfScope, single.Filtered)
+	assert.Equal(t, `skipped: Single is out of scope of "Альбомы" of Кино`, single.Decision)
+
+	overridden := decisions["4"]
+	assert.True(t, overridden.Override)
+	assert.Equal(t, `owned: matches local "Звезда по имени Солнце" by an override`, overridden.Decision)
+
+	unmatched := decisions["5"]
+	assert.Equal(t, FilterReason(""), unmatched.Filtered)
+	assert.InDelta(t, 0.27, unmatched.Score, 0.01)
+	assert.Equal(t, "new: the best local candidate scores 0.27 below the threshold 0.85", unmatched.Decision)
+
+	explanation, err = differ.Explain(ctx, "Kino", "Gruppa Krovi")
+	require.NoError(t, err)
+	assert.Equal(t, "gruppakrovi", explanation.AlbumKey)
+	require.Len(t, explanation.Local, 1, "the title narrows the candidates down")
+	assert.Equal(t, "Группа крови", explanation.Local[0].Album.Name)
+	assert.Equal(t, 1.0, explanation.Local[0].Score)
+	require.Len(t, explanation.Actual, 1)
+	assert.Equal(t, "1", explanation.Actual[0].Album.ID)
+}
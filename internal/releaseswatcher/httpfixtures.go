@@ -0,0 +1,219 @@
+package releaseswatcher
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+type HTTPMode string
+
+const (
+	// HTTPModeLive talks to the APIs directly
+	HTTPModeLive HTTPMode = ""
+	// HTTPModeRecord talks to the APIs and saves every response as a fixture
+	HTTPModeRecord HTTPMode = "record"
+	// HTTPModeReplay serves saved fixtures and never touches the network
+	HTTPModeReplay HTTPMode = "replay"
+)
+
+var ErrFixtureNotFound = errors.New("fixture not found")
+
+type HTTPConfig struct {
+	// Mode is "record" or "replay", empty for live requests
+	Mode HTTPMode `envDefault:""`
+	// Fixtures is the directory of recorded responses, one subdirectory per API
+	Fixtures string `envDefault:"fixtures"`
+}
+
+// httpFixture is a recorded response, the request is kept for readability.
+type httpFixture struct {
+	Method      string `json:"method"`
+	URL         string `json:"url"`
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        string `json:"body"`
+}
+
+// FixtureTransport records responses of the next transport into the directory
+// or replays them from it. Requests are matched by method, path and query,
+// headers (and the tokens in them) are neither matched nor recorded.
+type FixtureTransport struct {
+	mode HTTPMode
+	dir  string
+	next http.RoundTripper
+}
+
+func NewFixtureTransport(mode HTTPMode, dir string, next http.RoundTripper) *FixtureTransport {
+	return &FixtureTransport{mode: mode, dir: dir, next: next}
+}
+
+// fixtureName is readable enough to find a fixture by the path and unique thanks to the hash.
+func fixtureName(req *http.Request) string {
+	hash := sha256.Sum256([]byte(req.Method + " " + req.URL.RequestURI()))
+	path := strings.Trim(strings.ReplaceAll(req.URL.Path, "/", "_"), "_")
+	if len(path) > 80 {
+		path = path[:80]
+	}
+	return fmt.Sprintf("%s_%s_%s.json", req.Method, path, hex.EncodeToString(hash[:])[:16])
+}
+
+func (t *FixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := filepath.Join(t.dir, fixtureName(req))
+	if t.mode == HTTPModeReplay {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s %s", ErrFixtureNotFound, req.Method, req.URL.RequestURI())
+		}
+		if err != nil {
+			return nil, err
+		}
+		var fixture httpFixture
+		if err := json.Unmarshal(data, &fixture); err != nil {
+			return nil, fmt.Errorf("invalid fixture %s: %w", path, err)
+		}
+		return fixtureResponse(req, fixture), nil
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	fixture := httpFixture{
+		Method:      req.Method,
+		URL:         req.URL.RequestURI(),
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        string(body),
+	}
+	// rate limiting and server errors are not what the API returns for the request
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		if err := t.save(path, fixture); err != nil {
+			return nil, err
+		}
+	}
+	return fixtureResponse(req, fixture), nil
+}
+
+func (t *FixtureTransport) save(path string, fixture httpFixture) error {
+	if err := os.MkdirAll(t.dir, 0o755); err != nil {
+		return fmt.Errorf("error creating fixtures directory: %w", err)
+	}
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(fixture); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("error writing fixture: %w", err)
+	}
+	return nil
+}
+
+func fixtureResponse(req *http.Request, fixture httpFixture) *http.Response {
+	header := http.Header{}
+	if fixture.ContentType != "" {
+		header.Set("Content-Type", fixture.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", fixture.Status, http.StatusText(fixture.Status)),
+		StatusCode:    fixture.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(fixture.Body)),
+		ContentLength: int64(len(fixture.Body)),
+		Request:       req,
+	}
+}
+
+// HTTPFixtures puts a FixtureTransport in front of the APIs. The API clients
+// don't accept a transport, so it is served as a local proxy the clients are pointed to.
+type HTTPFixtures struct {
+	config  HTTPConfig
+	mu      *sync.Mutex
+	servers *[]*http.Server
+}
+
+func NewHTTPFixtures(config HTTPConfig) (HTTPFixtures, error) {
+	switch config.Mode {
+	case HTTPModeLive, HTTPModeRecord, HTTPModeReplay:
+	default:
+		return HTTPFixtures{}, fmt.Errorf("unknown HTTP mode %q", config.Mode)
+	}
+	return HTTPFixtures{config: config, mu: &sync.Mutex{}, servers: &[]*http.Server{}}, nil
+}
+
+// Offline is true when responses are replayed, so requests need no rate limiting.
+func (f HTTPFixtures) Offline() bool {
+	return f.config.Mode == HTTPModeReplay
+}
+
+// Endpoint returns the base URL the client of the named API should use instead of upstream.
+func (f HTTPFixtures) Endpoint(name string, upstream string) (string, error) {
+	if f.config.Mode == HTTPModeLive {
+		return upstream, nil
+	}
+	target, err := url.Parse(upstream)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s URL: %w", name, err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("error starting %s fixtures proxy: %w", name, err)
+	}
+	server := &http.Server{Handler: &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.Out.Host = target.Host
+		},
+		Transport: NewFixtureTransport(f.config.Mode, filepath.Join(f.config.Fixtures, name), http.DefaultTransport),
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Errorf("%s %s: %v", name, r.URL.RequestURI(), err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}}
+	go server.Serve(listener)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	*f.servers = append(*f.servers, server)
+	log.Infof("%s requests are in %s mode with fixtures in %s", name, f.config.Mode, f.config.Fixtures)
+	return "http://" + listener.Addr().String(), nil
+}
+
+// Close stops the proxies, the zero value has none.
+func (f HTTPFixtures) Close() error {
+	if f.mu == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var errs []error
+	for _, server := range *f.servers {
+		errs = append(errs, server.Shutdown(context.Background()))
+	}
+	*f.servers = nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,59 @@
+package releaseswatcher
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pochemuto/releases-watcher/sqlc"
+)
+
+// Lifecycle is the state of an actual release set by hand.
+type Lifecycle string
+
+const (
+	// LifecycleWishlist releases are reported even when filtered out
+	LifecycleWishlist Lifecycle = "wishlist"
+	// LifecycleIgnored releases are never reported
+	LifecycleIgnored Lifecycle = "ignored"
+	// LifecycleAcquired releases are owned, but not in the local library
+	LifecycleAcquired Lifecycle = "acquired"
+	// LifecycleSnoozed releases are not reported until the date
+	LifecycleSnoozed Lifecycle = "snoozed"
+)
+
+var lifecycleFilters = map[Lifecycle]FilterReason{
+	LifecycleIgnored:  FilterIgnored,
+	LifecycleAcquired: FilterAcquired,
+	LifecycleSnoozed:  FilterSnoozed,
+}
+
+func ParseLifecycle(raw string) (Lifecycle, error) {
+	switch Lifecycle(raw) {
+	case LifecycleWishlist, LifecycleIgnored, LifecycleAcquired, LifecycleSnoozed:
+		return Lifecycle(raw), nil
+	default:
+		return "", fmt.Errorf("unknown state %q, expected %q, %q, %q or %q", raw,
+			LifecycleWishlist, LifecycleIgnored, LifecycleAcquired, LifecycleSnoozed)
+	}
+}
+
+// activeReleaseStates returns states by release ID, releases snoozed until the date or earlier
+// are left out.
+func activeReleaseStates(states []sqlc.ReleaseState, today time.Time) map[string]sqlc.ReleaseState {
+	result := make(map[string]sqlc.ReleaseState, len(states))
+	for _, state := range states {
+		if Lifecycle(state.State) == LifecycleSnoozed && state.Until.Valid && !state.Until.Time.After(today) {
+			continue
+		}
+		result[state.ActualID] = state
+	}
+	return result
+}
+
+// formatReleaseState describes the state for the sheet and the CLI.
+func formatReleaseState(state sqlc.ReleaseState) string {
+	if state.Until.Valid {
+		return fmt.Sprintf("%s until %s", state.State, state.Until.Time.Format(time.DateOnly))
+	}
+	return state.State
+}
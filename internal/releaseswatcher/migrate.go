@@ -0,0 +1,130 @@
+package releaseswatcher
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed migrations/postgres/*.sql migrations/sqlite/*.sql
+var migrationFiles embed.FS
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.sql$`)
+
+var ErrSchemaOutdated = errors.New("database schema is outdated, run 'migrate up'")
+
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+type Migrator struct {
+	store      Storage
+	migrations []Migration
+}
+
+// NewMigrator loads the migrations of the storage backend.
+func NewMigrator(store Storage) (Migrator, error) {
+	migrations, err := loadMigrations(migrationFiles, store.migrationsDir())
+	if err != nil {
+		return Migrator{}, err
+	}
+	return Migrator{store: store, migrations: migrations}, nil
+}
+
+func loadMigrations(files fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations: %w", err)
+	}
+	var migrations []Migration
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file name %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %q and %q have the same version", other, entry.Name())
+		}
+		seen[version] = entry.Name()
+		sql, err := fs.ReadFile(files, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %q: %w", entry.Name(), err)
+		}
+		migrations = append(migrations, Migration{Version: version, Name: match[2], SQL: string(sql)})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Status returns all known migrations with the time they were applied at.
+func (m Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	if err := m.store.ensureMigrationsTable(ctx); err != nil {
+		return nil, fmt.Errorf("error creating schema_migrations: %w", err)
+	}
+	applied, err := m.store.appliedMigrations(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error loading applied migrations: %w", err)
+	}
+
+	result := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Migration: migration}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		result = append(result, status)
+	}
+	return result, nil
+}
+
+// Up applies pending migrations in order, each one in its own transaction.
+func (m Migrator) Up(ctx context.Context) (int, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			continue
+		}
+		if err := m.store.applyMigration(ctx, status.Migration); err != nil {
+			return count, fmt.Errorf("migration %04d_%s failed: %w", status.Version, status.Name, err)
+		}
+		log.Infof("Applied migration %04d_%s", status.Version, status.Name)
+		count++
+	}
+	return count, nil
+}
+
+// CheckUpToDate returns ErrSchemaOutdated if some migrations are not applied.
+func (m Migrator) CheckUpToDate(ctx context.Context) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			return fmt.Errorf("%w: %04d_%s is not applied", ErrSchemaOutdated, status.Version, status.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package releaseswatcher
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	files := fstest.MapFS{
+		"m/0010_second.sql": {Data: []byte("SELECT 2;")},
+		"m/0002_first.sql":  {Data: []byte("SELECT 1;")},
+	}
+	migrations, err := loadMigrations(files, "m")
+	require.NoError(t, err)
+	assert.Equal(t, []Migration{
+		{Version: 2, Name: "first", SQL: "SELECT 1;"},
+		{Version: 10, Name: "second", SQL: "SELECT 2;"},
+	}, migrations)
+
+	files["m/0002_duplicate.sql"] = &fstest.MapFile{Data: []byte("SELECT 3;")}
+	_, err = loadMigrations(files, "m")
+	assert.Error(t, err)
+
+	_, err = loadMigrations(fstest.MapFS{"m/init.sql": {}}, "m")
+	assert.Error(t, err)
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	for _, dir := range []string{postgresStorage{}.migrationsDir(), sqliteStorage{}.migrationsDir()} {
+		migrations, err := loadMigrations(migrationFiles, dir)
+		require.NoError(t, err)
+		require.NotEmpty(t, migrations, dir)
+		for i, migration := range migrations {
+			assert.Equal(t, i+1, migration.Version, "%s are numbered without gaps", dir)
+			assert.NotEmpty(t, migration.SQL)
+		}
+	}
+}
@@ -1,7 +1,7 @@
 -- public.actual_album определение
 -- Drop table
 -- DROP TABLE public.actual_album;
-CREATE TABLE public.actual_album (
+CREATE TABLE IF NOT EXISTS public.actual_album (
 	id varchar NOT NULL,
 	artist varchar COLLATE "ru-RU-x-icu" NULL,
 	name varchar COLLATE "ru-RU-x-icu" NULL,
@@ -20,7 +20,7 @@ END $$;
 -- public.local_album определение
 -- Drop table
 -- DROP TABLE public.local_album;
-CREATE TABLE public.local_album (
+CREATE TABLE IF NOT EXISTS public.local_album (
 	artist varchar COLLATE "ru-RU-x-icu" NOT NULL,
 	"name" varchar COLLATE "ru-RU-x-icu" NOT NULL,
 	version_id int4 NOT NULL,
@@ -29,7 +29,7 @@ CREATE TABLE public.local_album (
 -- public."local_version" definition
 -- Drop table
 -- DROP TABLE public."local_version";
-CREATE TABLE public."local_version" (
+CREATE TABLE IF NOT EXISTS public."local_version" (
 	version_id serial4 NOT NULL,
 	created_at timestamp DEFAULT CURRENT_TIMESTAMP NOT NULL,
 	published bool DEFAULT false NOT NULL,
@@ -58,18 +58,18 @@ END $$;
 -- public."cache" определение
 -- Drop table
 -- DROP TABLE public."cache";
-CREATE TABLE public."cache" (
+CREATE TABLE IF NOT EXISTS public."cache" (
 	entity varchar NOT NULL,
 	id varchar NOT NULL,
 	value jsonb NULL,
 	ts timestamp DEFAULT CURRENT_TIMESTAMP NULL,
 	CONSTRAINT cache_pk PRIMARY KEY (entity, id)
 );
-CREATE TABLE public."excluded_artist" (
+CREATE TABLE IF NOT EXISTS public."excluded_artist" (
 	"artist" varchar NOT NULL,
 	PRIMARY KEY ("artist")
 );
-CREATE TABLE "public"."excluded_album" (
+CREATE TABLE IF NOT EXISTS "public"."excluded_album" (
 	"artist" varchar NOT NULL,
 	"album" varchar NOT NULL,
 	PRIMARY KEY ("artist", "album")
@@ -77,7 +77,7 @@ CREATE TABLE "public"."excluded_album" (
 -- public."actual_version" definition
 -- Drop table
 -- DROP TABLE public."actual_version";
-CREATE TABLE public."actual_version" (
+CREATE TABLE IF NOT EXISTS public."actual_version" (
 	version_id serial4 NOT NULL,
 	created_at timestamp DEFAULT CURRENT_TIMESTAMP NOT NULL,
 	published bool DEFAULT false NOT NULL,
@@ -85,7 +85,7 @@ CREATE TABLE public."actual_version" (
 );
 -- ADD url column to actual_album
 ALTER TABLE public.actual_album
-ADD COLUMN url varchar COLLATE "ru-RU-x-icu";
+ADD COLUMN IF NOT EXISTS url varchar COLLATE "ru-RU-x-icu";
 -- public.actual_album_published source
 CREATE OR REPLACE VIEW public.actual_album_published AS
 SELECT aa.id,
@@ -102,4 +102,4 @@ FROM actual_album aa
 		WHERE actual_version.published = true
 		ORDER BY actual_version.version_id DESC
 		LIMIT 1
-	) v ON aa.version_id = v.version_id;
\ No newline at end of file
+	) v ON aa.version_id = v.version_id;
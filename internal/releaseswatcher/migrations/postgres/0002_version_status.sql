@@ -0,0 +1,54 @@
+-- version status: building -> published | failed | aborted
+ALTER TABLE public.actual_version
+ADD COLUMN IF NOT EXISTS status varchar DEFAULT 'building' NOT NULL,
+	ADD COLUMN IF NOT EXISTS last_artist varchar COLLATE "ru-RU-x-icu" NULL;
+ALTER TABLE public.local_version
+ADD COLUMN IF NOT EXISTS status varchar DEFAULT 'building' NOT NULL;
+UPDATE public.actual_version
+SET status = 'published'
+WHERE published = true;
+UPDATE public.local_version
+SET status = 'published'
+WHERE published = true;
+CREATE OR REPLACE VIEW public.actual_album_published AS
+SELECT aa.id,
+	aa.artist,
+	aa.name,
+	aa.year,
+	aa.kind,
+	aa.version_id,
+	aa.url
+FROM actual_album aa
+	JOIN (
+		SELECT actual_version.version_id
+		FROM actual_version
+		WHERE actual_version.status = 'published'
+		ORDER BY actual_version.version_id DESC
+		LIMIT 1
+	) v ON aa.version_id = v.version_id;
+CREATE OR REPLACE VIEW public.local_album_published AS
+SELECT la.artist,
+	la.name,
+	la.version_id
+FROM local_album la
+	JOIN (
+		SELECT local_version.version_id
+		FROM local_version
+		WHERE local_version.status = 'published'
+		ORDER BY local_version.version_id DESC
+		LIMIT 1
+	) v ON la.version_id = v.version_id;
+ALTER TABLE public.actual_version DROP COLUMN IF EXISTS published;
+ALTER TABLE public.local_version DROP COLUMN IF EXISTS published;
+-- public.drop_actual_album_partition
+CREATE OR REPLACE FUNCTION drop_actual_album_partition(v int) RETURNS void LANGUAGE plpgsql AS $$ BEGIN EXECUTE format(
+		'DROP TABLE IF EXISTS %I',
+		format('actual_album_v%s', v)
+	);
+END $$;
+-- public.drop_local_album_partition
+CREATE OR REPLACE FUNCTION drop_local_album_partition(v int) RETURNS void LANGUAGE plpgsql AS $$ BEGIN EXECUTE format(
+		'DROP TABLE IF EXISTS %I',
+		format('local_album_v%s', v)
+	);
+END $$;
@@ -0,0 +1,3 @@
+-- negative entries remember lookups without a result, value is NULL
+ALTER TABLE public."cache"
+ADD COLUMN IF NOT EXISTS negative bool DEFAULT false NOT NULL;
@@ -0,0 +1,6 @@
+-- other names of local artists known to the library, e.g. "Kino" for "Кино"
+CREATE TABLE IF NOT EXISTS public."artist_alias" (
+	"artist" varchar NOT NULL,
+	"alias" varchar NOT NULL,
+	PRIMARY KEY ("artist", "alias")
+);
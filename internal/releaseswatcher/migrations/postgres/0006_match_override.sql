@@ -0,0 +1,7 @@
+-- local albums matched to an actual release by hand, a NULL release means the album has no match
+CREATE TABLE IF NOT EXISTS public."match_override" (
+	"local_artist" varchar NOT NULL,
+	"local_album" varchar NOT NULL,
+	"actual_id" varchar NULL,
+	PRIMARY KEY ("local_artist", "local_album")
+);
@@ -0,0 +1,7 @@
+-- states of actual releases set by hand, see Lifecycle
+CREATE TABLE IF NOT EXISTS public."release_state" (
+	"actual_id" varchar NOT NULL,
+	"state" varchar NOT NULL,
+	"until" date NULL,
+	PRIMARY KEY ("actual_id")
+);
@@ -0,0 +1,40 @@
+-- release group and track count to tell editions of the same album apart
+ALTER TABLE public.actual_album
+ADD COLUMN IF NOT EXISTS release_group varchar NULL;
+ALTER TABLE public.actual_album
+ADD COLUMN IF NOT EXISTS tracks int4 NULL;
+ALTER TABLE public.local_album
+ADD COLUMN IF NOT EXISTS tracks int4 NULL;
+CREATE OR REPLACE VIEW public.actual_album_published AS
+SELECT aa.id,
+	aa.artist,
+	aa.name,
+	aa.year,
+	aa.kind,
+	aa.version_id,
+	aa.url,
+	aa.release_group,
+	aa.tracks
+FROM actual_album aa
+	JOIN (
+		SELECT actual_version.version_id
+		FROM actual_version
+		WHERE actual_version.status = 'published'
+		ORDER BY actual_version.pinned DESC,
+			actual_version.version_id DESC
+		LIMIT 1
+	) v ON aa.version_id = v.version_id;
+CREATE OR REPLACE VIEW public.local_album_published AS
+SELECT la.artist,
+	la.name,
+	la.version_id,
+	la.tracks
+FROM local_album la
+	JOIN (
+		SELECT local_version.version_id
+		FROM local_version
+		WHERE local_version.status = 'published'
+		ORDER BY local_version.pinned DESC,
+			local_version.version_id DESC
+		LIMIT 1
+	) v ON la.version_id = v.version_id;
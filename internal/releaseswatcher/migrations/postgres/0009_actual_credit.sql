@@ -0,0 +1,23 @@
+-- artist credit of the release as written, e.g. "A & B feat. C"
+ALTER TABLE public.actual_album
+ADD COLUMN IF NOT EXISTS credit varchar NULL;
+CREATE OR REPLACE VIEW public.actual_album_published AS
+SELECT aa.id,
+	aa.artist,
+	aa.name,
+	aa.year,
+	aa.kind,
+	aa.version_id,
+	aa.url,
+	aa.release_group,
+	aa.tracks,
+	aa.credit
+FROM actual_album aa
+	JOIN (
+		SELECT actual_version.version_id
+		FROM actual_version
+		WHERE actual_version.status = 'published'
+		ORDER BY actual_version.pinned DESC,
+			actual_version.version_id DESC
+		LIMIT 1
+	) v ON aa.version_id = v.version_id;
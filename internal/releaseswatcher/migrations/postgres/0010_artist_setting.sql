@@ -0,0 +1,8 @@
+-- settings of artists when they are kept in the database, see ArtistSettingsStore
+CREATE TABLE IF NOT EXISTS public."artist_setting" (
+	"artist" varchar NOT NULL,
+	"notification" varchar NOT NULL,
+	"since" date NULL,
+	"collaborations" varchar NOT NULL DEFAULT '',
+	PRIMARY KEY ("artist")
+);
@@ -0,0 +1,3 @@
+-- cache timestamps keep the time zone, plain timestamps were written in the session time zone
+-- and read back as UTC, existing values are taken in the session time zone
+ALTER TABLE public."cache" ALTER COLUMN "ts" TYPE timestamptz;
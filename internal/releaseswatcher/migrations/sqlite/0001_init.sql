@@ -0,0 +1,76 @@
+-- versions and albums, albums of all versions share a table
+CREATE TABLE actual_version (
+	version_id integer PRIMARY KEY AUTOINCREMENT,
+	created_at timestamp DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	status varchar DEFAULT 'building' NOT NULL,
+	last_artist varchar NULL,
+	pinned bool DEFAULT false NOT NULL
+);
+CREATE TABLE actual_album (
+	id varchar NOT NULL,
+	artist varchar NULL,
+	name varchar NULL,
+	"year" integer NULL,
+	kind varchar NULL,
+	version_id integer NOT NULL,
+	url varchar NULL,
+	CONSTRAINT actual_album_pk PRIMARY KEY (id, version_id)
+);
+CREATE VIEW actual_album_published AS
+SELECT aa.id,
+	aa.artist,
+	aa.name,
+	aa.year,
+	aa.kind,
+	aa.version_id,
+	aa.url
+FROM actual_album aa
+WHERE aa.version_id = (
+		SELECT version_id
+		FROM actual_version
+		WHERE status = 'published'
+		ORDER BY pinned DESC,
+			version_id DESC
+		LIMIT 1
+	);
+CREATE TABLE local_version (
+	version_id integer PRIMARY KEY AUTOINCREMENT,
+	created_at timestamp DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	status varchar DEFAULT 'building' NOT NULL,
+	pinned bool DEFAULT false NOT NULL
+);
+CREATE TABLE local_album (
+	artist varchar NOT NULL,
+	name varchar NOT NULL,
+	version_id integer NOT NULL,
+	CONSTRAINT local_album_pk PRIMARY KEY (version_id, artist, name)
+);
+CREATE VIEW local_album_published AS
+SELECT la.artist,
+	la.name,
+	la.version_id
+FROM local_album la
+WHERE la.version_id = (
+		SELECT version_id
+		FROM local_version
+		WHERE status = 'published'
+		ORDER BY pinned DESC,
+			version_id DESC
+		LIMIT 1
+	);
+CREATE TABLE cache (
+	entity varchar NOT NULL,
+	id varchar NOT NULL,
+	value blob NULL,
+	ts timestamp DEFAULT CURRENT_TIMESTAMP NULL,
+	CONSTRAINT cache_pk PRIMARY KEY (entity, id)
+);
+CREATE TABLE excluded_artist (
+	artist varchar NOT NULL,
+	PRIMARY KEY (artist)
+);
+CREATE TABLE excluded_album (
+	artist varchar NOT NULL,
+	album varchar NOT NULL,
+	PRIMARY KEY (artist, album)
+);
@@ -0,0 +1,3 @@
+-- negative entries remember lookups without a result, value is NULL
+ALTER TABLE cache
+ADD COLUMN negative boolean DEFAULT false NOT NULL;
@@ -0,0 +1,42 @@
+-- release group and track count to tell editions of the same album apart
+ALTER TABLE actual_album
+ADD COLUMN release_group varchar NULL;
+ALTER TABLE actual_album
+ADD COLUMN tracks integer NULL;
+ALTER TABLE local_album
+ADD COLUMN tracks integer NULL;
+DROP VIEW actual_album_published;
+CREATE VIEW actual_album_published AS
+SELECT aa.id,
+	aa.artist,
+	aa.name,
+	aa.year,
+	aa.kind,
+	aa.version_id,
+	aa.url,
+	aa.release_group,
+	aa.tracks
+FROM actual_album aa
+WHERE aa.version_id = (
+		SELECT version_id
+		FROM actual_version
+		WHERE status = 'published'
+		ORDER BY pinned DESC,
+			version_id DESC
+		LIMIT 1
+	);
+DROP VIEW local_album_published;
+CREATE VIEW local_album_published AS
+SELECT la.artist,
+	la.name,
+	la.version_id,
+	la.tracks
+FROM local_album la
+WHERE la.version_id = (
+		SELECT version_id
+		FROM local_version
+		WHERE status = 'published'
+		ORDER BY pinned DESC,
+			version_id DESC
+		LIMIT 1
+	);
@@ -0,0 +1,24 @@
+-- artist credit of the release as written, e.g. "A & B feat. C"
+ALTER TABLE actual_album
+ADD COLUMN credit varchar NULL;
+DROP VIEW actual_album_published;
+CREATE VIEW actual_album_published AS
+SELECT aa.id,
+	aa.artist,
+	aa.name,
+	aa.year,
+	aa.kind,
+	aa.version_id,
+	aa.url,
+	aa.release_group,
+	aa.tracks,
+	aa.credit
+FROM actual_album aa
+WHERE aa.version_id = (
+		SELECT version_id
+		FROM actual_version
+		WHERE status = 'published'
+		ORDER BY pinned DESC,
+			version_id DESC
+		LIMIT 1
+	);
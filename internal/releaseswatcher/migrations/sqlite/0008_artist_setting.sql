@@ -0,0 +1,8 @@
+-- settings of artists when they are kept in the database, see ArtistSettingsStore
+CREATE TABLE artist_setting (
+	artist varchar NOT NULL,
+	notification varchar NOT NULL,
+	since timestamp NULL,
+	collaborations varchar NOT NULL DEFAULT '',
+	PRIMARY KEY (artist)
+);
@@ -0,0 +1,25 @@
+-- names of the credited artists as the library credits them, a JSON array, e.g. ["A","B","C"] for "A & B feat. C"
+ALTER TABLE actual_album
+ADD COLUMN credited varchar NULL;
+DROP VIEW actual_album_published;
+CREATE VIEW actual_album_published AS
+SELECT aa.id,
+	aa.artist,
+	aa.name,
+	aa.year,
+	aa.kind,
+	aa.version_id,
+	aa.url,
+	aa.release_group,
+	aa.tracks,
+	aa.credit,
+	aa.credited
+FROM actual_album aa
+WHERE aa.version_id = (
+		SELECT version_id
+		FROM actual_version
+		WHERE status = 'published'
+		ORDER BY pinned DESC,
+			version_id DESC
+		LIMIT 1
+	);
@@ -2,8 +2,10 @@ package releaseswatcher
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,29 +21,52 @@ type MusicBrainzLibrary struct {
 	cache   Cache
 	mb      *musicbrainzws2.Client
 	limiter *rate.Limiter
+	// editions returns every release of a release group, not only the first one
+	editions bool
 }
 
+const musicBrainzURL = "https://musicbrainz.org"
+
 type MusicBrainzConfig struct {
-	Token string
+	// Token is required by the web service only
+	Token string `envDefault:""`
+	// Mirror is a connection string of a local MusicBrainz PostgreSQL mirror
+	Mirror string `envDefault:""`
+	// Dump is a path to the extracted mbdump/release file of the JSON dumps
+	Dump string `envDefault:""`
+	// Editions fetches every release of a release group, e.g. deluxe editions and re-releases,
+	// instead of the earliest one
+	Editions bool `envDefault:"false"`
 }
 
 func days(d int) time.Duration {
 	return time.Duration(d) * 24 * time.Hour
 }
 
-func NewMusicBrainzLibrary(config MusicBrainzConfig, db DB, cache Cache) (MusicBrainzLibrary, error) {
+func NewMusicBrainzLibrary(config MusicBrainzConfig, db DB, cache Cache, fixtures HTTPFixtures) (MusicBrainzLibrary, error) {
 	if config.Token == "" {
 		return MusicBrainzLibrary{}, fmt.Errorf("token is empty")
 	}
+	endpoint, err := fixtures.Endpoint("musicbrainz", musicBrainzURL)
+	if err != nil {
+		return MusicBrainzLibrary{}, err
+	}
 	appInfo := musicbrainzws2.AppInfo{Name: "Releases Watcher", Version: "1.0"}
-	mb := musicbrainzws2.NewClient(appInfo)
+	mb := musicbrainzws2.NewClientWithURL(appInfo, endpoint)
 	mb.SetAuthToken(config.Token)
-	return MusicBrainzLibrary{
-		db:      db,
-		cache:   cache,
-		mb:      mb,
-		limiter: rate.NewLimiter(50*rate.Every(time.Minute), 1),
-	}, nil
+	limiter := rate.NewLimiter(50*rate.Every(time.Minute), 1)
+	if fixtures.Offline() {
+		limiter = rate.NewLimiter(rate.Inf, 1)
+	}
+	library := MusicBrainzLibrary{
+		db:       db,
+		cache:    cache,
+		mb:       mb,
+		limiter:  limiter,
+		editions: config.Editions,
+	}
+	library.registerRefreshers()
+	return library, nil
 }
 
 func (l MusicBrainzLibrary) Name() string {
@@ -53,68 +78,323 @@ func (l MusicBrainzLibrary) api() *musicbrainzws2.Client {
 	return l.mb
 }
 
+func (l MusicBrainzLibrary) fetchRelease(releaseID string) (*musicbrainzws2.Release, error) {
+	release, err := l.api().LookupRelease(context.TODO(), mbtypes.MBID(releaseID), musicbrainzws2.IncludesFilter{Includes: []string{"release-groups", "media"}})
+	if err != nil {
+		return nil, err
+	}
+	return &release, nil
+}
+
 func (l MusicBrainzLibrary) getRelease(releaseID string) (*musicbrainzws2.Release, error) {
-	freshness := days(7)
-	return GetCached(l.cache, context.TODO(), "musicbrainz_release", releaseID, freshness, func() (*musicbrainzws2.Release, error) {
-		release, err := l.api().LookupRelease(context.TODO(), mbtypes.MBID(releaseID), musicbrainzws2.IncludesFilter{Includes: []string{"release-groups"}})
-		if err != nil {
-			return nil, err
-		}
-		return &release, nil
+	return GetCached(l.cache, context.TODO(), EntityMusicBrainzRelease, releaseID, func() (*musicbrainzws2.Release, error) {
+		return l.fetchRelease(releaseID)
 	})
 }
 
-func (l MusicBrainzLibrary) getArtistID(artist string) (string, error) {
-	freshness := days(90)
-	result, err := GetCached(l.cache, context.TODO(), "musicbrainz_artist_search", artist, freshness, func() (*musicbrainzws2.SearchArtistsResult, error) {
-		filter := musicbrainzws2.SearchFilter{Query: artist}
-		res, err := l.api().SearchArtists(context.TODO(), filter, musicbrainzws2.DefaultPaginator())
-		if err != nil {
-			return nil, err
-		}
-		return &res, nil
+func (l MusicBrainzLibrary) searchArtist(artist string) (*musicbrainzws2.SearchArtistsResult, error) {
+	filter := musicbrainzws2.SearchFilter{Query: artist}
+	res, err := l.api().SearchArtists(context.TODO(), filter, musicbrainzws2.DefaultPaginator())
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Artists) == 0 {
+		return nil, ErrNoResult
+	}
+	return &res, nil
+}
+
+func (l MusicBrainzLibrary) getArtist(artist string) (*musicbrainzws2.Artist, error) {
+	result, err := GetCached(l.cache, context.TODO(), EntityMusicBrainzArtistSearch, artist, func() (*musicbrainzws2.SearchArtistsResult, error) {
+		return l.searchArtist(artist)
 	})
+	if errors.Is(err, ErrNoResult) {
+		return nil, fmt.Errorf("artist '%s' not found", artist)
+	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if len(result.Artists) == 0 {
-		return "", fmt.Errorf("artist '%s' not found", artist)
+		return nil, fmt.Errorf("artist '%s' not found", artist)
+	}
+	return &result.Artists[0], nil
+}
+
+func (l MusicBrainzLibrary) getArtistID(artist string) (string, error) {
+	found, err := l.getArtist(artist)
+	if err != nil {
+		return "", err
 	}
-	return string(result.Artists[0].ID), nil
+	return string(found.ID), nil
+}
+
+// ArtistAliases returns the name and aliases of the artist found by the search.
+func (l MusicBrainzLibrary) ArtistAliases(ctx context.Context, artist string) ([]string, error) {
+	found, err := l.getArtist(artist)
+	if err != nil {
+		return nil, err
+	}
+	aliases := []string{found.Name}
+	for _, alias := range found.Aliases {
+		aliases = append(aliases, alias.Name)
+	}
+	return aliases, nil
+}
+
+func releaseGroupsCacheKey(artistID string, offset int) string {
+	return fmt.Sprintf("%s_%d", artistID, offset)
+}
+
+func parseReleaseGroupsCacheKey(key string) (string, int, error) {
+	i := strings.LastIndex(key, "_")
+	if i < 0 {
+		return "", 0, fmt.Errorf("invalid release groups cache key %q", key)
+	}
+	offset, err := strconv.Atoi(key[i+1:])
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid release groups cache key %q: %w", key, err)
+	}
+	return key[:i], offset, nil
+}
+
+func (l MusicBrainzLibrary) fetchArtistReleaseGroups(artistID string, offset int) (*musicbrainzws2.BrowseReleaseGroupsResult, error) {
+	filter := musicbrainzws2.ReleaseGroupFilter{ArtistMBID: mbtypes.MBID(artistID)}
+	paginator := musicbrainzws2.DefaultPaginator()
+	paginator.Offset = offset
+	paginator.Limit = 100
+	res, err := l.api().BrowseReleaseGroups(context.TODO(), filter, paginator)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Found %d release groups\n", len(res.ReleaseGroups))
+	return &res, nil
 }
 
 func (l MusicBrainzLibrary) getArtistReleaseGroups(artistID string, offset int) (*musicbrainzws2.BrowseReleaseGroupsResult, error) {
-	freshness := days(7)
-	cacheKey := fmt.Sprintf("%s_%d", artistID, offset)
-	return GetCached(l.cache, context.TODO(), "musicbrainz_artist_releasegroups", cacheKey, freshness, func() (*musicbrainzws2.BrowseReleaseGroupsResult, error) {
-		filter := musicbrainzws2.ReleaseGroupFilter{ArtistMBID: mbtypes.MBID(artistID)}
-		paginator := musicbrainzws2.DefaultPaginator()
-		paginator.Offset = offset
-		paginator.Limit = 100
-		res, err := l.api().BrowseReleaseGroups(context.TODO(), filter, paginator)
-		if err != nil {
-			return nil, err
-		}
-		log.Infof("Found %d release groups\n", len(res.ReleaseGroups))
-		return &res, nil
+	cacheKey := releaseGroupsCacheKey(artistID, offset)
+	return GetCached(l.cache, context.TODO(), EntityMusicBrainzArtistReleaseGroups, cacheKey, func() (*musicbrainzws2.BrowseReleaseGroupsResult, error) {
+		return l.fetchArtistReleaseGroups(artistID, offset)
 	})
 }
 
+func (l MusicBrainzLibrary) fetchReleaseGroup(releaseGroupID mbtypes.MBID) (*musicbrainzws2.ReleaseGroup, error) {
+	releaseGroup, err := l.api().LookupReleaseGroup(context.TODO(), releaseGroupID, musicbrainzws2.IncludesFilter{Includes: []string{"releases"}})
+	if err != nil {
+		return nil, err
+	}
+	return &releaseGroup, nil
+}
+
 func (l MusicBrainzLibrary) getArtistReleaseGroup(releaseGroupID mbtypes.MBID) (*musicbrainzws2.ReleaseGroup, error) {
-	freshness := days(30)
-	return GetCached(l.cache, context.TODO(), "musicbrainz_releasegroup", string(releaseGroupID), freshness, func() (*musicbrainzws2.ReleaseGroup, error) {
-		releaseGroup, err := l.api().LookupReleaseGroup(context.TODO(), releaseGroupID, musicbrainzws2.IncludesFilter{Includes: []string{"releases"}})
+	return GetCached(l.cache, context.TODO(), EntityMusicBrainzReleaseGroup, string(releaseGroupID), func() (*musicbrainzws2.ReleaseGroup, error) {
+		return l.fetchReleaseGroup(releaseGroupID)
+	})
+}
+
+// fetchReleaseGroupEditions browses the releases of the group, the lookup of a release group
+// includes 25 releases at most.
+func (l MusicBrainzLibrary) fetchReleaseGroupEditions(releaseGroupID string, offset int) (*musicbrainzws2.BrowseReleasesResult, error) {
+	// track counts of the editions
+	filter := musicbrainzws2.ReleaseFilter{ReleaseGroupMBID: mbtypes.MBID(releaseGroupID), Includes: []string{"media"}}
+	paginator := musicbrainzws2.DefaultPaginator()
+	paginator.Offset = offset
+	paginator.Limit = 100
+	res, err := l.api().BrowseReleases(context.TODO(), filter, paginator)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (l MusicBrainzLibrary) getReleaseGroupEditions(releaseGroupID string, offset int) (*musicbrainzws2.BrowseReleasesResult, error) {
+	cacheKey := releaseGroupsCacheKey(releaseGroupID, offset)
+	return GetCached(l.cache, context.TODO(), EntityMusicBrainzReleaseGroupEditions, cacheKey, func() (*musicbrainzws2.BrowseReleasesResult, error) {
+		return l.fetchReleaseGroupEditions(releaseGroupID, offset)
+	})
+}
+
+// getEditions sends every release of the group except the given one
+func (l MusicBrainzLibrary) getEditions(releaseGroupID string, release *musicbrainzws2.Release, out chan<- musicbrainzws2.Release) error {
+	offset := 0
+	for {
+		resp, err := l.getReleaseGroupEditions(releaseGroupID, offset)
+		if err != nil {
+			return err
+		}
+		for _, edition := range resp.Releases {
+			if edition.ID == release.ID || slices.Contains(excludedReleaseStatuses, edition.Status) {
+				continue
+			}
+			edition.ReleaseGroup = release.ReleaseGroup
+			if len(edition.ArtistCredit) == 0 {
+				edition.ArtistCredit = release.ArtistCredit
+			}
+			out <- edition
+		}
+		offset += len(resp.Releases)
+		if offset >= resp.Count || len(resp.Releases) == 0 {
+			return nil
+		}
+	}
+}
+
+// ArtistPriority matches artist searches and release groups of the artists,
+// the artist IDs are taken from the cached searches.
+func (l MusicBrainzLibrary) ArtistPriority(ctx context.Context, artists []string) func(key CacheKey) bool {
+	names := make(map[string]bool, len(artists))
+	ids := make(map[string]bool, len(artists))
+	for _, artist := range artists {
+		names[artist] = true
+		entry, err := l.cache.Inspect(ctx, EntityMusicBrainzArtistSearch, artist)
+		if err != nil || entry.Negative {
+			continue
+		}
+		result, err := unmarshalCached[musicbrainzws2.SearchArtistsResult](entry.Value)
+		if err != nil || len(result.Artists) == 0 {
+			continue
+		}
+		ids[string(result.Artists[0].ID)] = true
+	}
+	return func(key CacheKey) bool {
+		switch key.Entity {
+		case EntityMusicBrainzArtistSearch:
+			return names[key.ID]
+		case EntityMusicBrainzArtistReleaseGroups:
+			artistID, _, err := parseReleaseGroupsCacheKey(key.ID)
+			return err == nil && ids[artistID]
+		}
+		return false
+	}
+}
+
+// registerRefreshers lets the cache refresh stale MusicBrainz entries in the background.
+func (l MusicBrainzLibrary) registerRefreshers() {
+	l.cache.RegisterRefresher(EntityMusicBrainzRelease, func(ctx context.Context, id string) error {
+		_, err := RefreshCached(l.cache, ctx, EntityMusicBrainzRelease, id, func() (*musicbrainzws2.Release, error) {
+			return l.fetchRelease(id)
+		})
+		return err
+	})
+	l.cache.RegisterRefresher(EntityMusicBrainzArtistSearch, func(ctx context.Context, id string) error {
+		_, err := RefreshCached(l.cache, ctx, EntityMusicBrainzArtistSearch, id, func() (*musicbrainzws2.SearchArtistsResult, error) {
+			return l.searchArtist(id)
+		})
+		return err
+	})
+	l.cache.RegisterRefresher(EntityMusicBrainzArtistReleaseGroups, func(ctx context.Context, id string) error {
+		artistID, offset, err := parseReleaseGroupsCacheKey(id)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		return &releaseGroup, nil
+		_, err = RefreshCached(l.cache, ctx, EntityMusicBrainzArtistReleaseGroups, id, func() (*musicbrainzws2.BrowseReleaseGroupsResult, error) {
+			return l.fetchArtistReleaseGroups(artistID, offset)
+		})
+		return err
+	})
+	l.cache.RegisterRefresher(EntityMusicBrainzReleaseGroup, func(ctx context.Context, id string) error {
+		_, err := RefreshCached(l.cache, ctx, EntityMusicBrainzReleaseGroup, id, func() (*musicbrainzws2.ReleaseGroup, error) {
+			return l.fetchReleaseGroup(mbtypes.MBID(id))
+		})
+		return err
+	})
+	l.cache.RegisterRefresher(EntityMusicBrainzReleaseGroupEditions, func(ctx context.Context, id string) error {
+		releaseGroupID, offset, err := parseReleaseGroupsCacheKey(id)
+		if err != nil {
+			return err
+		}
+		_, err = RefreshCached(l.cache, ctx, EntityMusicBrainzReleaseGroupEditions, id, func() (*musicbrainzws2.BrowseReleasesResult, error) {
+			return l.fetchReleaseGroupEditions(releaseGroupID, offset)
+		})
+		return err
+	})
+}
+
+// excludedSecondaryTypes are release groups which are not releases of the artist on their own,
+// live recordings and compilations are reported by the notification setting of the artist
+var excludedSecondaryTypes = []string{"Remix", "Demo", "Mixtape/Street", "Bootleg", "Promotion", "Withdrawn", "Expunged", "Pseudo-Release", "Accepted"}
+var excludedReleaseStatuses = []string{"Bootleg"}
+
+// isWantedReleaseGroup leaves out remixes, demos and the like, every primary type is kept,
+// so that the kind is decided by musicBrainzKind and filtered by the notification setting.
+func isWantedReleaseGroup(secondaryTypes []string) bool {
+	return !slices.ContainsFunc(secondaryTypes, func(s string) bool {
+		return slices.Contains(excludedSecondaryTypes, s)
 	})
 }
 
+// musicBrainzKind prefers the secondary types telling live recordings and compilations apart
+// over the primary type.
+func musicBrainzKind(primaryType string, secondaryTypes []string) Kind {
+	switch {
+	case slices.Contains(secondaryTypes, "Live"):
+		return KindLive
+	case slices.Contains(secondaryTypes, "Compilation"):
+		return KindCompilation
+	}
+	kind, _ := ParseKind(primaryType)
+	return kind
+}
+
+func musicBrainzActualAlbum(artist string, release musicbrainzws2.Release) sqlc.ActualAlbum {
+	kind := KindUnknown.String()
+	if rg := release.ReleaseGroup; rg != nil {
+		kind = musicBrainzKind(rg.PrimaryType, rg.SecondaryTypes).String()
+	}
+	year := int32(0)
+	if release.Date.Year > 0 {
+		year = int32(release.Date.Year)
+	}
+	album := sqlc.ActualAlbum{
+		ID:     string(release.ID),
+		Artist: &artist,
+		Name:   &release.Title,
+		Year:   &year,
+		Kind:   &kind,
+		Url:    ptr.String(fmt.Sprintf("https://musicbrainz.org/release/%s", release.ID)),
+	}
+	if release.ReleaseGroup != nil {
+		album.ReleaseGroup = ptr.String(string(release.ReleaseGroup.ID))
+	}
+	if credit := musicBrainzCredit(release); credit != "" {
+		album.Credit = &credit
+	}
+	album.Credited = musicBrainzCredited(release)
+	if len(release.Media) > 0 {
+		tracks := int32(0)
+		for _, medium := range release.Media {
+			tracks += int32(medium.TrackCount)
+		}
+		album.Tracks = &tracks
+	}
+	return album
+}
+
+// musicBrainzCredit writes the artist credit of the release as MusicBrainz shows it, e.g. "A & B feat. C".
+func musicBrainzCredit(release musicbrainzws2.Release) string {
+	var b strings.Builder
+	for _, credit := range release.ArtistCredit {
+		b.WriteString(credit.Name)
+		b.WriteString(credit.JoinPhrase)
+	}
+	return b.String()
+}
+
+// musicBrainzCredited returns the names of the credited artists, e.g. "A" and "B" for "A & B",
+// the name in the credit when the artist is missing.
+func musicBrainzCredited(release musicbrainzws2.Release) []string {
+	var names []string
+	for _, credit := range release.ArtistCredit {
+		name := credit.Artist.Name
+		if name == "" {
+			name = credit.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
 func (l MusicBrainzLibrary) getReleases(artist string, out chan<- musicbrainzws2.Release) {
 	defer close(out)
-	var excludedSecondaryTypes = []string{"Compilation", "Live", "Remix", "Demo", "Mixtape/Street", "Bootleg", "Promotion", "Withdrawn", "Expunged", "Pseudo-Release", "Accepted"}
-	var excludedReleaseStatuses = []string{"Bootleg"}
 	artistID, err := l.getArtistID(artist)
 	if err != nil {
 		log.Errorf("Error getting artist ID for %s: %v", artist, err)
@@ -129,24 +409,20 @@ func (l MusicBrainzLibrary) getReleases(artist string, out chan<- musicbrainzws2
 			return
 		}
 		for _, rg := range resp.ReleaseGroups {
-			if rg.PrimaryType == "Album" || rg.PrimaryType == "EP" || rg.PrimaryType == "Single" {
-				if rg.SecondaryTypes != nil && slices.ContainsFunc(rg.SecondaryTypes, func(s string) bool {
-					return slices.Contains(excludedSecondaryTypes, s)
-				}) {
-					continue
-				}
+			if isWantedReleaseGroup(rg.SecondaryTypes) {
 				secondaryTypes := ""
 				if len(rg.SecondaryTypes) > 0 {
 					secondaryTypes = fmt.Sprintf(" (%s)", strings.Join(rg.SecondaryTypes, ", "))
 				}
 				log.Infof("  Getting release for [%s%s] %s\n", rg.PrimaryType, secondaryTypes, rg.Title)
-				rg, err := l.getArtistReleaseGroup(rg.ID)
+				group, err := l.getArtistReleaseGroup(rg.ID)
 				if err != nil {
 					log.Errorf("Error getting release group %s: %v", rg.ID, err)
+					continue
 				}
 				// Get the first release for the group
-				if len(rg.Releases) > 0 {
-					releaseID := string(rg.Releases[0].ID)
+				if len(group.Releases) > 0 {
+					releaseID := string(group.Releases[0].ID)
 					release, err := l.getRelease(releaseID)
 					if err != nil {
 						continue
@@ -155,6 +431,12 @@ func (l MusicBrainzLibrary) getReleases(artist string, out chan<- musicbrainzws2
 						continue
 					}
 					out <- *release
+					if l.editions {
+						// editions are browsed without fetching every release
+						if err := l.getEditions(string(rg.ID), release, out); err != nil {
+							log.Errorf("Error getting editions of release group %s: %v", rg.ID, err)
+						}
+					}
 				}
 			}
 		}
@@ -173,23 +455,7 @@ func (l MusicBrainzLibrary) GetActualAlbumsForArtists(ctx context.Context, artis
 		releases := make(chan musicbrainzws2.Release)
 		go l.getReleases(artist, releases)
 		for release := range releases {
-			kind := ""
-			if release.ReleaseGroup != nil {
-				kind = release.ReleaseGroup.PrimaryType
-			}
-			year := int32(0)
-			if release.Date.Year > 0 {
-				year = int32(release.Date.Year)
-			}
-			actualAlbum := sqlc.ActualAlbum{
-				ID:     string(release.ID),
-				Artist: &artist,
-				Name:   &release.Title,
-				Year:   &year,
-				Kind:   &kind,
-				Url:    ptr.String(fmt.Sprintf("https://musicbrainz.org/release/%s", release.ID)),
-			}
-			out <- actualAlbum
+			out <- musicBrainzActualAlbum(artist, release)
 		}
 	}
 }
@@ -0,0 +1,438 @@
+package releaseswatcher
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pochemuto/releases-watcher/sqlc"
+	mbtypes "go.uploadedlobster.com/mbtypes"
+	"go.uploadedlobster.com/musicbrainzws2"
+)
+
+// musicBrainzSource is a local copy of MusicBrainz.
+type musicBrainzSource interface {
+	// Prepare is called with all the artists before their releases are requested
+	Prepare(ctx context.Context, artists []string) error
+	// Releases returns the earliest release of every release group of the artist,
+	// or every release with editions, with the release group filled
+	Releases(ctx context.Context, artist string) ([]offlineRelease, error)
+	Close()
+}
+
+// offlineRelease is a release of a local copy with the artist credit as written
+// and the names of the credited artists.
+type offlineRelease struct {
+	musicbrainzws2.Release
+	credit   string
+	credited []string
+}
+
+// MusicBrainzMirrorLibrary reads releases from a local MusicBrainz PostgreSQL mirror
+// or a JSON dump instead of the rate limited web service.
+type MusicBrainzMirrorLibrary struct {
+	source musicBrainzSource
+}
+
+// NewLibrary returns the offline MusicBrainz library when a mirror or a dump is configured
+// and the web service one otherwise.
+func NewLibrary(ctx context.Context, config MusicBrainzConfig, db DB, cache Cache, fixtures HTTPFixtures) (Library, error) {
+	switch {
+	case config.Mirror != "":
+		return NewMusicBrainzMirrorLibrary(ctx, config.Mirror, config.Editions)
+	case config.Dump != "":
+		return NewMusicBrainzDumpLibrary(config.Dump, config.Editions), nil
+	default:
+		return NewMusicBrainzLibrary(config, db, cache, fixtures)
+	}
+}
+
+func NewMusicBrainzMirrorLibrary(ctx context.Context, connectionString string, editions bool) (MusicBrainzMirrorLibrary, error) {
+	conn, err := pgxpool.New(ctx, connectionString)
+	if err != nil {
+		return MusicBrainzMirrorLibrary{}, err
+	}
+	if err := conn.Ping(ctx); err != nil {
+		conn.Close()
+		return MusicBrainzMirrorLibrary{}, fmt.Errorf("error connecting to MusicBrainz mirror: %w", err)
+	}
+	return MusicBrainzMirrorLibrary{source: musicBrainzMirror{conn: conn, editions: editions}}, nil
+}
+
+func NewMusicBrainzDumpLibrary(path string, editions bool) MusicBrainzMirrorLibrary {
+	return MusicBrainzMirrorLibrary{source: &musicBrainzDump{path: path, editions: editions}}
+}
+
+func (l MusicBrainzMirrorLibrary) Name() string {
+	return "MusicBrainz (offline)"
+}
+
+func (l MusicBrainzMirrorLibrary) Close() {
+	l.source.Close()
+}
+
+// ArtistAliases returns the name and aliases of the artist, the dump has no aliases.
+func (l MusicBrainzMirrorLibrary) ArtistAliases(ctx context.Context, artist string) ([]string, error) {
+	mirror, ok := l.source.(musicBrainzMirror)
+	if !ok {
+		return nil, ErrNoAliases
+	}
+	return mirror.aliases(ctx, artist)
+}
+
+func (l MusicBrainzMirrorLibrary) GetActualAlbumsForArtists(ctx context.Context, artists []string, out chan<- sqlc.ActualAlbum) {
+	defer close(out)
+	if err := l.source.Prepare(ctx, artists); err != nil {
+		log.Errorf("Error reading MusicBrainz: %v", err)
+		return
+	}
+	for i, artist := range artists {
+		if ctx.Err() != nil {
+			log.Infof("Context done, stopping fetching releases for artists")
+			return
+		}
+		log.Infof("Processing artist %d of %d: %s", i+1, len(artists), artist)
+		releases, err := l.source.Releases(ctx, artist)
+		if err != nil {
+			log.Errorf("Error getting releases for artist %s: %v", artist, err)
+			continue
+		}
+		for _, release := range releases {
+			rg := release.ReleaseGroup
+			if rg == nil || !isWantedReleaseGroup(rg.SecondaryTypes) {
+				continue
+			}
+			if slices.Contains(excludedReleaseStatuses, release.Status) {
+				continue
+			}
+			album := musicBrainzActualAlbum(artist, release.Release)
+			if release.credit != "" {
+				album.Credit = &release.credit
+			}
+			album.Credited = release.credited
+			out <- album
+		}
+	}
+}
+
+// musicBrainzMirror queries the schema of the official MusicBrainz database.
+type musicBrainzMirror struct {
+	conn     *pgxpool.Pool
+	editions bool
+}
+
+// mirrorArtistQuery prefers the exact name over aliases and then the artist with the most credits,
+// like the first result of the web service search.
+const mirrorArtistQuery = `
+SELECT a.id
+FROM musicbrainz.artist a
+WHERE lower(a.name) = lower($1)
+   OR a.id IN (SELECT artist FROM musicbrainz.artist_alias WHERE lower(name) = lower($1))
+ORDER BY lower(a.name) = lower($1) DESC,
+         (SELECT count(*) FROM musicbrainz.artist_credit_name acn WHERE acn.artist = a.id) DESC
+LIMIT 1`
+
+const mirrorReleasesQuery = `
+SELECT rg.gid::text,
+       rg.name,
+       coalesce(pt.name, ''),
+       array(SELECT st.name
+             FROM musicbrainz.release_group_secondary_type_join j
+                      JOIN musicbrainz.release_group_secondary_type st ON st.id = j.secondary_type
+             WHERE j.release_group = rg.id
+             ORDER BY st.name),
+       r.gid::text,
+       r.name,
+       coalesce(rs.name, ''),
+       coalesce(r.date_year, 0),
+       (SELECT coalesce(sum(m.track_count), 0)::int FROM musicbrainz.medium m WHERE m.release = r.id),
+       ac.name,
+       array(SELECT a.name
+             FROM musicbrainz.artist_credit_name acn
+                      JOIN musicbrainz.artist a ON a.id = acn.artist
+             WHERE acn.artist_credit = ac.id
+             ORDER BY acn.position)
+FROM musicbrainz.release_group rg
+         LEFT JOIN musicbrainz.release_group_primary_type pt ON pt.id = rg.type
+         JOIN LATERAL (SELECT r.id, r.gid, r.name, r.status, r.artist_credit, e.date_year
+                       FROM musicbrainz.release r
+                                LEFT JOIN LATERAL (SELECT date_year, date_month, date_day
+                                                   FROM musicbrainz.release_event
+                                                   WHERE release = r.id
+                                                   ORDER BY date_year NULLS LAST, date_month NULLS LAST, date_day NULLS LAST
+                                                   LIMIT 1) e ON true
+                       WHERE r.release_group = rg.id
+                       ORDER BY e.date_year NULLS LAST, e.date_month NULLS LAST, e.date_day NULLS LAST, r.id
+                       LIMIT CASE WHEN $2 THEN NULL ELSE 1 END) r ON true
+         LEFT JOIN musicbrainz.release_status rs ON rs.id = r.status
+         JOIN musicbrainz.artist_credit ac ON ac.id = r.artist_credit
+WHERE rg.artist_credit IN (SELECT artist_credit FROM musicbrainz.artist_credit_name WHERE artist = $1)
+ORDER BY rg.id`
+
+const mirrorAliasesQuery = `
+SELECT name
+FROM musicbrainz.artist
+WHERE id = $1
+UNION
+SELECT name
+FROM musicbrainz.artist_alias
+WHERE artist = $1`
+
+func (m musicBrainzMirror) Prepare(ctx context.Context, artists []string) error {
+	return nil
+}
+
+func (m musicBrainzMirror) artistID(ctx context.Context, artist string) (int64, error) {
+	var artistID int64
+	err := m.conn.QueryRow(ctx, mirrorArtistQuery, artist).Scan(&artistID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("artist '%s' not found", artist)
+	}
+	return artistID, err
+}
+
+func (m musicBrainzMirror) aliases(ctx context.Context, artist string) ([]string, error) {
+	artistID, err := m.artistID(ctx, artist)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := m.conn.Query(ctx, mirrorAliasesQuery, artistID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func (m musicBrainzMirror) Releases(ctx context.Context, artist string) ([]offlineRelease, error) {
+	artistID, err := m.artistID(ctx, artist)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := m.conn.Query(ctx, mirrorReleasesQuery, artistID, m.editions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var releases []offlineRelease
+	for rows.Next() {
+		var rg musicbrainzws2.ReleaseGroup
+		var release offlineRelease
+		var year, tracks int32
+		if err := rows.Scan(
+			&rg.ID,
+			&rg.Title,
+			&rg.PrimaryType,
+			&rg.SecondaryTypes,
+			&release.ID,
+			&release.Title,
+			&release.Status,
+			&year,
+			&tracks,
+			&release.credit,
+			&release.credited,
+		); err != nil {
+			return nil, err
+		}
+		release.Date.Year = int(year)
+		if tracks > 0 {
+			release.Media = []musicbrainzws2.Medium{{TrackCount: int(tracks)}}
+		}
+		release.ReleaseGroup = &rg
+		releases = append(releases, release)
+	}
+	return releases, rows.Err()
+}
+
+func (m musicBrainzMirror) Close() {
+	m.conn.Close()
+}
+
+// dumpRelease is a line of the release JSON dump, the same JSON as the web service returns.
+type dumpRelease struct {
+	ID           string             `json:"id"`
+	Title        string             `json:"title"`
+	Status       string             `json:"status"`
+	Date         string             `json:"date"`
+	ArtistCredit []dumpArtistCredit `json:"artist-credit"`
+	ReleaseGroup struct {
+		ID             string   `json:"id"`
+		Title          string   `json:"title"`
+		PrimaryType    string   `json:"primary-type"`
+		SecondaryTypes []string `json:"secondary-types"`
+	} `json:"release-group"`
+	Media []struct {
+		TrackCount int `json:"track-count"`
+	} `json:"media"`
+}
+
+type dumpArtistCredit struct {
+	Name       string `json:"name"`
+	JoinPhrase string `json:"joinphrase"`
+	Artist     struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"artist"`
+}
+
+// musicBrainzDump reads the extracted mbdump/release file of the JSON dumps, optionally gzipped.
+// The file is streamed once per update keeping only releases of the requested artists, and only
+// the earliest release of wanted release groups unless editions are wanted.
+type musicBrainzDump struct {
+	path     string
+	editions bool
+
+	releases map[string][]offlineRelease
+}
+
+func (d *musicBrainzDump) Prepare(ctx context.Context, artists []string) error {
+	wanted := make(map[string]bool, len(artists))
+	for _, artist := range artists {
+		wanted[strings.ToLower(artist)] = true
+	}
+	releases, err := d.load(ctx, wanted)
+	if err != nil {
+		return err
+	}
+	d.releases = releases
+	return nil
+}
+
+func (d *musicBrainzDump) Releases(ctx context.Context, artist string) ([]offlineRelease, error) {
+	releases, ok := d.releases[strings.ToLower(artist)]
+	if !ok {
+		return nil, fmt.Errorf("artist '%s' not found", artist)
+	}
+	return releases, nil
+}
+
+// load reads releases credited to the wanted lowercase artist names.
+func (d *musicBrainzDump) load(ctx context.Context, wanted map[string]bool) (map[string][]offlineRelease, error) {
+	file, err := os.Open(d.path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening MusicBrainz dump: %w", err)
+	}
+	defer file.Close()
+	var r io.Reader = file
+	if strings.HasSuffix(d.path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("error opening MusicBrainz dump: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	type dated struct {
+		release offlineRelease
+		date    string
+	}
+	// releases without a date go last
+	earlier := func(a, b dated) bool {
+		return a.date != "" && (b.date == "" || a.date < b.date)
+	}
+	groups := make(map[string]map[string][]dated)
+	scanner := bufio.NewScanner(r)
+	// releases with many tracks and credits don't fit into the default 64KiB
+	scanner.Buffer(make([]byte, 0, 1024*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var dump dumpRelease
+		if err := json.Unmarshal(scanner.Bytes(), &dump); err != nil {
+			return nil, fmt.Errorf("error parsing line %d of MusicBrainz dump: %w", line, err)
+		}
+		rg := dump.ReleaseGroup
+		if !isWantedReleaseGroup(rg.SecondaryTypes) {
+			continue
+		}
+		credited := slices.ContainsFunc(dump.ArtistCredit, func(credit dumpArtistCredit) bool {
+			return wanted[strings.ToLower(credit.Artist.Name)]
+		})
+		if !credited {
+			continue
+		}
+		release := musicbrainzws2.Release{
+			ID:     mbtypes.MBID(dump.ID),
+			Title:  dump.Title,
+			Status: dump.Status,
+			ReleaseGroup: &musicbrainzws2.ReleaseGroup{
+				ID:             mbtypes.MBID(rg.ID),
+				Title:          rg.Title,
+				PrimaryType:    rg.PrimaryType,
+				SecondaryTypes: rg.SecondaryTypes,
+			},
+		}
+		if len(dump.Date) >= 4 {
+			release.Date.Year, _ = strconv.Atoi(dump.Date[:4])
+		}
+		for _, medium := range dump.Media {
+			release.Media = append(release.Media, musicbrainzws2.Medium{TrackCount: medium.TrackCount})
+		}
+		var written strings.Builder
+		var names []string
+		for _, credit := range dump.ArtistCredit {
+			written.WriteString(credit.Name)
+			written.WriteString(credit.JoinPhrase)
+			names = append(names, credit.Artist.Name)
+		}
+		entry := dated{release: offlineRelease{Release: release, credit: written.String(), credited: names}, date: dump.Date}
+		for _, credit := range dump.ArtistCredit {
+			name := strings.ToLower(credit.Artist.Name)
+			if !wanted[name] {
+				continue
+			}
+			if groups[name] == nil {
+				groups[name] = make(map[string][]dated)
+			}
+			current := groups[name][rg.ID]
+			switch {
+			case d.editions:
+				current = append(current, entry)
+			case len(current) == 0 || earlier(entry, current[0]):
+				current = []dated{entry}
+			}
+			groups[name][rg.ID] = current
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading MusicBrainz dump: %w", err)
+	}
+
+	result := make(map[string][]offlineRelease, len(groups))
+	for name, artistGroups := range groups {
+		releases := make([]offlineRelease, 0, len(artistGroups))
+		for _, group := range artistGroups {
+			slices.SortStableFunc(group, func(a, b dated) int {
+				switch {
+				case earlier(a, b):
+					return -1
+				case earlier(b, a):
+					return 1
+				}
+				return 0
+			})
+			for _, edition := range group {
+				releases = append(releases, edition.release)
+			}
+		}
+		slices.SortStableFunc(releases, func(a, b offlineRelease) int {
+			return strings.Compare(string(a.ReleaseGroup.ID), string(b.ReleaseGroup.ID))
+		})
+		result[name] = releases
+	}
+	log.Infof("Loaded release groups of %d of %d artists from MusicBrainz dump", len(result), len(wanted))
+	return result, nil
+}
+
+func (d *musicBrainzDump) Close() {}
@@ -0,0 +1,194 @@
+package releaseswatcher
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pochemuto/releases-watcher/sqlc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMusicBrainzDumpLibrary(t *testing.T) {
+	lib := NewMusicBrainzDumpLibrary("testdata/musicbrainz/release.jsonl", false)
+	out := make(chan sqlc.ActualAlbum, 100)
+	go lib.GetActualAlbumsForArtists(context.Background(), []string{"кино", "Unknown"}, out)
+
+	var albums []string
+	for album := range out {
+		assert.Equal(t, "кино", *album.Artist)
+		albums = append(albums, fmt.Sprintf("%s %s %d %s", album.ID, *album.Name, *album.Year, *album.Kind))
+	}
+	assert.Equal(t, []string{
+		"r1 Группа крови 1988 Album",
+		"r3 Концерт 1991 Live",
+		"r5 Красная волна 1986 EP",
+		"r8 Чёрный альбом 1990 Album",
+	}, albums, "earliest dated release of every group without bootlegs")
+}
+
+func TestMusicBrainzDumpLibrary_Editions(t *testing.T) {
+	lib := NewMusicBrainzDumpLibrary("testdata/musicbrainz/release.jsonl", true)
+	out := make(chan sqlc.ActualAlbum, 100)
+	go lib.GetActualAlbumsForArtists(context.Background(), []string{"кино"}, out)
+
+	var albums []string
+	for album := range out {
+		tracks := "-"
+		if album.Tracks != nil {
+			tracks = fmt.Sprint(*album.Tracks)
+		}
+		albums = append(albums, fmt.Sprintf("%s %s %s %s", album.ID, *album.ReleaseGroup, *album.Name, tracks))
+	}
+	assert.Equal(t, []string{
+		"r1 g1 Группа крови 10",
+		"r2 g1 Группа крови 13",
+		"r3 g2 Концерт -",
+		"r5 g4 Красная волна -",
+		"r8 g6 Чёрный альбом -",
+		"r7 g6 Чёрный альбом -",
+	}, albums, "every release of the groups, the earliest first")
+}
+
+func TestMusicBrainzDumpLibrary_Credits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "release")
+	require.NoError(t, os.WriteFile(path, []byte(`{"id": "r1", "title": "Carnage", "status": "Official", "artist-credit": [{"name": "Nick Cave", "joinphrase": " & ", "artist": {"id": "a1", "name": "Nick Cave"}}, {"name": "Warren Ellis", "joinphrase": "", "artist": {"id": "a2", "name": "Warren Ellis"}}], "release-group": {"id": "g1", "title": "Carnage", "primary-type": "Album", "secondary-types": []}, "date": "2021"}
+{"id": "r2", "title": "Ghosteen", "status": "Official", "artist-credit": [{"name": "Nick Cave & The Bad Seeds", "joinphrase": "", "artist": {"id": "a3", "name": "Nick Cave & The Bad Seeds"}}], "release-group": {"id": "g2", "title": "Ghosteen", "primary-type": "Album", "secondary-types": []}, "date": "2019"}
+`), 0o644))
+	lib := NewMusicBrainzDumpLibrary(path, false)
+	out := make(chan sqlc.ActualAlbum, 100)
+	go lib.GetActualAlbumsForArtists(context.Background(), []string{"Nick Cave", "Nick Cave & The Bad Seeds"}, out)
+
+	credited := make(map[string][]string)
+	for album := range out {
+		credited[*album.Artist+" - "+*album.Name+" by "+*album.Credit] = album.Credited
+	}
+	assert.Equal(t, map[string][]string{
+		"Nick Cave - Carnage by Nick Cave & Warren Ellis":                   {"Nick Cave", "Warren Ellis"},
+		"Nick Cave & The Bad Seeds - Ghosteen by Nick Cave & The Bad Seeds": {"Nick Cave & The Bad Seeds"},
+	}, credited, "the artists are taken from the credit, a band is credited alone")
+}
+
+// musicBrainzMirrorSchema is the part of the MusicBrainz schema the mirror queries
+const musicBrainzMirrorSchema = `
+CREATE SCHEMA musicbrainz;
+CREATE TABLE musicbrainz.artist (id integer PRIMARY KEY, gid uuid NOT NULL, name varchar NOT NULL);
+CREATE TABLE musicbrainz.artist_alias (id serial PRIMARY KEY, artist integer NOT NULL, name varchar NOT NULL);
+CREATE TABLE musicbrainz.artist_credit (id integer PRIMARY KEY, name varchar NOT NULL);
+CREATE TABLE musicbrainz.artist_credit_name (
+	artist_credit integer NOT NULL,
+	position smallint NOT NULL,
+	artist integer NOT NULL,
+	name varchar NOT NULL,
+	join_phrase varchar NOT NULL DEFAULT ''
+);
+CREATE TABLE musicbrainz.release_group_primary_type (id integer PRIMARY KEY, name varchar NOT NULL);
+CREATE TABLE musicbrainz.release_group_secondary_type (id integer PRIMARY KEY, name varchar NOT NULL);
+CREATE TABLE musicbrainz.release_group (
+	id integer PRIMARY KEY,
+	gid uuid NOT NULL,
+	name varchar NOT NULL,
+	artist_credit integer NOT NULL,
+	type integer
+);
+CREATE TABLE musicbrainz.release_group_secondary_type_join (release_group integer NOT NULL, secondary_type integer NOT NULL);
+CREATE TABLE musicbrainz.release_status (id integer PRIMARY KEY, name varchar NOT NULL);
+CREATE TABLE musicbrainz.release (
+	id integer PRIMARY KEY,
+	gid uuid NOT NULL,
+	name varchar NOT NULL,
+	artist_credit integer NOT NULL,
+	release_group integer NOT NULL,
+	status integer
+);
+CREATE TABLE musicbrainz.release_event (release integer NOT NULL, date_year smallint, date_month smallint, date_day smallint);
+CREATE TABLE musicbrainz.medium (id serial PRIMARY KEY, release integer NOT NULL, track_count integer NOT NULL);
+
+INSERT INTO musicbrainz.artist VALUES
+	(1, '00000000-0000-0000-0000-0000000000a1', 'Кино'),
+	(2, '00000000-0000-0000-0000-0000000000a2', 'Аквариум');
+INSERT INTO musicbrainz.artist_alias (artist, name) VALUES (1, 'Kino');
+INSERT INTO musicbrainz.artist_credit VALUES (1, 'Кино'), (2, 'Аквариум');
+INSERT INTO musicbrainz.artist_credit_name VALUES (1, 0, 1, 'Кино', ''), (2, 0, 2, 'Аквариум', '');
+INSERT INTO musicbrainz.release_group_primary_type VALUES (1, 'Album'), (2, 'Single');
+INSERT INTO musicbrainz.release_group_secondary_type VALUES (1, 'Compilation'), (2, 'Live');
+INSERT INTO musicbrainz.release_status VALUES (1, 'Official'), (3, 'Bootleg');
+INSERT INTO musicbrainz.release_group VALUES
+	(1, '00000000-0000-0000-0000-0000000000b1', 'Группа крови', 1, 1),
+	(2, '00000000-0000-0000-0000-0000000000b2', 'Концерт', 1, 1),
+	(3, '00000000-0000-0000-0000-0000000000b3', 'Радио Африка', 2, 1);
+INSERT INTO musicbrainz.release_group_secondary_type_join VALUES (2, 2);
+INSERT INTO musicbrainz.release VALUES
+	(11, '00000000-0000-0000-0000-000000000c11', 'Группа крови', 1, 1, 1),
+	(12, '00000000-0000-0000-0000-000000000c12', 'Группа крови', 1, 1, 1),
+	(21, '00000000-0000-0000-0000-000000000c21', 'Концерт', 1, 2, 1),
+	(31, '00000000-0000-0000-0000-000000000c31', 'Радио Африка', 2, 3, 1);
+INSERT INTO musicbrainz.release_event VALUES (11, 1988, 1, 5), (12, 1990, NULL, NULL), (21, 1991, NULL, NULL), (31, 1983, NULL, NULL);
+INSERT INTO musicbrainz.medium (release, track_count) VALUES (11, 10), (12, 10), (12, 3);
+`
+
+func setupMusicBrainzMirror(t *testing.T) string {
+	connString := startPostgres(t)
+	conn, err := pgx.Connect(context.Background(), connString)
+	require.NoError(t, err)
+	defer conn.Close(context.Background())
+	_, err = conn.Exec(context.Background(), musicBrainzMirrorSchema)
+	require.NoError(t, err)
+	return connString
+}
+
+func TestMusicBrainzMirrorLibrary(t *testing.T) {
+	ctx := context.Background()
+	connString := setupMusicBrainzMirror(t)
+
+	for _, test := range []struct {
+		editions bool
+		albums   []string
+	}{
+		{editions: false, albums: []string{"Группа крови 1988 Album 10", "Концерт 1991 Live -"}},
+		{editions: true, albums: []string{"Группа крови 1988 Album 10", "Группа крови 1990 Album 13", "Концерт 1991 Live -"}},
+	} {
+		lib, err := NewMusicBrainzMirrorLibrary(ctx, connString, test.editions)
+		require.NoError(t, err)
+		out := make(chan sqlc.ActualAlbum, 100)
+		// the alias finds the artist, the albums are reported under the requested name
+		go lib.GetActualAlbumsForArtists(ctx, []string{"Kino", "Unknown"}, out)
+		var albums []string
+		for album := range out {
+			assert.Equal(t, "Kino", *album.Artist)
+			assert.Equal(t, "Кино", *album.Credit)
+			tracks := "-"
+			if album.Tracks != nil {
+				tracks = fmt.Sprint(*album.Tracks)
+			}
+			albums = append(albums, fmt.Sprintf("%s %d %s %s", *album.Name, *album.Year, *album.Kind, tracks))
+		}
+		assert.Equal(t, test.albums, albums, "editions %v", test.editions)
+
+		aliases, err := lib.ArtistAliases(ctx, "кино")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"Кино", "Kino"}, aliases)
+		lib.Close()
+	}
+}
+
+func TestMusicBrainzDumpLibrary_KeepsAliases(t *testing.T) {
+	ctx := context.Background()
+	cache := setupTestCache(t, CacheConfig{})
+	db, err := NewDB(cache.store)
+	require.NoError(t, err)
+	require.NoError(t, db.ReplaceArtistAliases(ctx, "Кино", []string{"Kino"}))
+
+	lib := NewMusicBrainzDumpLibrary("testdata/musicbrainz/release.jsonl", false)
+	_, err = lib.ArtistAliases(ctx, "Кино")
+	assert.ErrorIs(t, err, ErrNoAliases)
+	Watcher{db: db, lib: lib}.updateArtistAliases(ctx, "Кино")
+
+	aliases, err := db.GetArtistAliases(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []sqlc.ArtistAlias{{Artist: "Кино", Alias: "Kino"}}, aliases, "stored aliases are kept")
+}
@@ -0,0 +1,132 @@
+package releaseswatcher
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pochemuto/releases-watcher/sqlc"
+)
+
+const transliterationNone = "none"
+
+// transliterations are the schemes of DifferConfig.Transliteration, letters missing
+// in a scheme are kept as is.
+var transliterations = map[string]map[rune]string{
+	"ru": {
+		'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+		'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+		'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "h", 'ц': "ts",
+		'ч': "ch", 'ш': "sh", 'щ': "sch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+		'я': "ya",
+	},
+}
+
+// nameMatcher makes keys of artist and album names, so that different spellings
+// of the same name have the same key.
+type nameMatcher struct {
+	normalizer      normalizer
+	transliteration map[rune]string
+	// aliases maps keys of artist names to the key representing all names of the artist
+	aliases map[string]string
+}
+
+func newNameMatcher(scheme string, rules map[string]string) (nameMatcher, error) {
+	transliteration := make(map[rune]string)
+	if scheme != transliterationNone {
+		letters, ok := transliterations[scheme]
+		if !ok {
+			return nameMatcher{}, fmt.Errorf("unknown transliteration %q", scheme)
+		}
+		for letter, latin := range letters {
+			transliteration[letter] = latin
+		}
+	}
+	for letter, latin := range rules {
+		r, size := utf8.DecodeRuneInString(letter)
+		if size == 0 || size != len(letter) {
+			return nameMatcher{}, fmt.Errorf("transliteration rule %q must be a single letter", letter)
+		}
+		transliteration[unicode.ToLower(r)] = latin
+	}
+	return nameMatcher{normalizer: defaultNormalizer, transliteration: transliteration, aliases: map[string]string{}}, nil
+}
+
+// withNormalizer makes keys by the normalization rules instead of the default ones.
+func (m nameMatcher) withNormalizer(n normalizer) nameMatcher {
+	m.normalizer = n
+	return m
+}
+
+func (m nameMatcher) transliterate(s string) string {
+	if len(m.transliteration) == 0 {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if latin, ok := m.transliteration[r]; ok {
+			b.WriteString(latin)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// key normalizes and transliterates the name.
+func (m nameMatcher) key(name string) string {
+	return m.transliterate(m.normalizer.normalize(name))
+}
+
+// artistKey is the same for all known names of the artist.
+func (m nameMatcher) artistKey(artist string) string {
+	key := m.key(artist)
+	if canonical, ok := m.aliases[key]; ok {
+		return canonical
+	}
+	return key
+}
+
+func (m nameMatcher) album(artist string, name string) sqlc.LocalAlbumPublished {
+	return sqlc.LocalAlbumPublished{
+		Artist: m.artistKey(artist),
+		Name:   m.key(name),
+	}
+}
+
+// withAliases links other names of every artist to the key of the artist. An alias which is
+// the name of another artist, or which several artists share, is left out, so different artists
+// never get the same key.
+func (m nameMatcher) withAliases(aliases []sqlc.ArtistAlias) nameMatcher {
+	artists := make(map[string]bool)
+	for _, alias := range aliases {
+		artists[m.key(alias.Artist)] = true
+	}
+	owners := make(map[string]map[string]bool)
+	for _, alias := range aliases {
+		artist, name := m.key(alias.Artist), m.key(alias.Alias)
+		if artist == "" || name == "" || artists[name] {
+			continue
+		}
+		if owners[name] == nil {
+			owners[name] = make(map[string]bool)
+		}
+		owners[name][artist] = true
+	}
+	result := nameMatcher{
+		normalizer:      m.normalizer,
+		transliteration: m.transliteration,
+		aliases:         make(map[string]string, len(owners)),
+	}
+	for name, artists := range owners {
+		if len(artists) != 1 {
+			log.Debugf("Alias %q is shared by %d artists, it is not used", name, len(artists))
+			continue
+		}
+		for artist := range artists {
+			result.aliases[name] = artist
+		}
+	}
+	return result
+}
@@ -0,0 +1,194 @@
+package releaseswatcher
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+const (
+	bracketsDrop = "drop"
+	bracketsKeep = "keep"
+)
+
+// NormalizationConfig are the rules making keys of names, the defaults drop text in brackets
+// and keep letters, digits and ★.
+type NormalizationConfig struct {
+	// Replacements replace parts of lowercase names before other characters are removed,
+	// e.g. "&:and,+:and"
+	Replacements map[string]string `envDefault:""`
+	// StopWords are left out of names as whole words, e.g. "the,a"
+	StopWords []string `envDefault:""`
+	// Brackets is what happens to text in brackets by default, "drop" or "keep"
+	Brackets string `envDefault:"drop"`
+	// KeepBrackets keep text in brackets containing one of the words, e.g. "part" for "(Part 2)"
+	KeepBrackets []string `envDefault:""`
+	// DropBrackets drop text in brackets containing one of the words, e.g. "remastered"
+	DropBrackets []string `envDefault:""`
+	// Characters are kept besides letters and digits
+	Characters string `envDefault:"★"`
+}
+
+// brackets match text in braces and square brackets
+var brackets = regexp.MustCompile(`[\(\[][^\)\]]*[\)\]]`)
+
+// normalizer removes everything but letters, digits and the kept characters from names,
+// so that spellings differing in case, punctuation and additions in brackets are equal.
+type normalizer struct {
+	replacer     *strings.Replacer
+	stopWords    map[string]bool
+	keepBrackets bool
+	keep         []string
+	drop         []string
+	characters   string
+}
+
+// defaultNormalizer follows the defaults of NormalizationConfig
+var defaultNormalizer = normalizer{characters: "★"}
+
+func newNormalizer(config NormalizationConfig) (normalizer, error) {
+	result := normalizer{
+		stopWords:  make(map[string]bool, len(config.StopWords)),
+		characters: config.Characters,
+	}
+	switch config.Brackets {
+	case bracketsDrop, "":
+	case bracketsKeep:
+		result.keepBrackets = true
+	default:
+		return normalizer{}, fmt.Errorf("unknown brackets handling %q, expected %q or %q", config.Brackets,
+			bracketsDrop, bracketsKeep)
+	}
+	// longer replacements go first, so that "feat." is replaced before "."
+	from := make([]string, 0, len(config.Replacements))
+	for old := range config.Replacements {
+		if old == "" {
+			return normalizer{}, errors.New("replacement of an empty string")
+		}
+		from = append(from, old)
+	}
+	slices.SortFunc(from, func(a, b string) int {
+		return cmp.Or(cmp.Compare(len(b), len(a)), cmp.Compare(a, b))
+	})
+	if len(from) > 0 {
+		pairs := make([]string, 0, 2*len(from))
+		for _, old := range from {
+			pairs = append(pairs, strings.ToLower(old), strings.ToLower(config.Replacements[old]))
+		}
+		result.replacer = strings.NewReplacer(pairs...)
+	}
+	for _, word := range config.StopWords {
+		result.stopWords[strings.ToLower(strings.TrimSpace(word))] = true
+	}
+	for _, word := range config.KeepBrackets {
+		result.keep = append(result.keep, strings.ToLower(strings.TrimSpace(word)))
+	}
+	for _, word := range config.DropBrackets {
+		result.drop = append(result.drop, strings.ToLower(strings.TrimSpace(word)))
+	}
+	return result, nil
+}
+
+// keeps tells whether the character is left in normalized names.
+func (n normalizer) keeps(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(n.characters, r)
+}
+
+// words splits the lowercase text on the removed characters.
+func (n normalizer) words(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return !n.keeps(r) })
+}
+
+// keepsBracket decides about the text in brackets, the keywords are whole words of the text,
+// keeping takes precedence over dropping.
+func (n normalizer) keepsBracket(text string) bool {
+	words := n.words(strings.ToLower(text))
+	switch {
+	case slices.ContainsFunc(n.keep, func(keyword string) bool { return slices.Contains(words, keyword) }):
+		return true
+	case slices.ContainsFunc(n.drop, func(keyword string) bool { return slices.Contains(words, keyword) }):
+		return false
+	}
+	return n.keepBrackets
+}
+
+// prepare handles text in brackets, lowercases the name and applies the replacements,
+// the bracketed parts are added to the trace when it is given.
+func (n normalizer) prepare(s string, trace *Normalization) string {
+	s = brackets.ReplaceAllStringFunc(s, func(text string) string {
+		inner := text[1 : len(text)-1]
+		kept := n.keepsBracket(inner)
+		if trace != nil {
+			trace.Brackets = append(trace.Brackets, NormalizedBracket{Text: text, Kept: kept})
+		}
+		if kept {
+			return " " + inner + " "
+		}
+		return ""
+	})
+	s = strings.ToLower(s)
+	if n.replacer != nil {
+		s = n.replacer.Replace(s)
+	}
+	return s
+}
+
+// withoutStopWords leaves out the stop words unless the name consists of them only, like "The The".
+func (n normalizer) withoutStopWords(words []string) []string {
+	if len(n.stopWords) == 0 {
+		return words
+	}
+	result := slices.DeleteFunc(slices.Clone(words), func(word string) bool { return n.stopWords[word] })
+	if len(result) == 0 {
+		return words
+	}
+	return result
+}
+
+func (n normalizer) normalize(s string) string {
+	return strings.Join(n.withoutStopWords(n.words(n.prepare(s, nil))), "")
+}
+
+// NormalizedBracket is a part of a name in brackets.
+type NormalizedBracket struct {
+	Text string
+	Kept bool
+}
+
+// Normalization shows how a name is normalized step by step.
+type Normalization struct {
+	Name     string
+	Brackets []NormalizedBracket
+	// Replaced is the lowercase name with the brackets handled and the replacements applied
+	Replaced string
+	// StopWords are the stop words left out
+	StopWords  []string
+	Normalized string
+	// Key is the transliterated normalized name, aliases of artists are not taken into account
+	Key    string
+	Tokens []string
+}
+
+// Normalize shows how the name is normalized by the rules of the differ.
+func (d Differ) Normalize(name string) Normalization {
+	n := d.names.normalizer
+	result := Normalization{Name: name}
+	result.Replaced = n.prepare(name, &result)
+	words := n.words(result.Replaced)
+	kept := n.withoutStopWords(words)
+	if len(kept) < len(words) {
+		for _, word := range words {
+			if n.stopWords[word] {
+				result.StopWords = append(result.StopWords, word)
+			}
+		}
+	}
+	result.Normalized = strings.Join(kept, "")
+	result.Key = d.names.transliterate(result.Normalized)
+	result.Tokens = d.names.titleTokens(name)
+	return result
+}
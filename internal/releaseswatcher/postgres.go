@@ -0,0 +1,506 @@
+package releaseswatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pochemuto/releases-watcher/sqlc"
+)
+
+// copyBatchSize is the number of rows sent with a single COPY
+const copyBatchSize = 1000
+
+// postgresStorage keeps albums of every version in a separate partition.
+type postgresStorage struct {
+	conn    *pgxpool.Pool
+	queries *sqlc.Queries
+}
+
+func NewPgxPool(ctx context.Context, config DbConfig) (*pgxpool.Pool, error) {
+	conn, err := pgxpool.New(ctx, config.ConnectionString)
+	if err != nil {
+		return nil, err
+	}
+	err = conn.Ping(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func NewPostgresStorage(conn *pgxpool.Pool) Storage {
+	return postgresStorage{
+		conn:    conn,
+		queries: sqlc.New(conn),
+	}
+}
+
+func notFound(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s postgresStorage) Close() {
+	s.conn.Close()
+}
+
+func (s postgresStorage) inTx(ctx context.Context, fn func(q *sqlc.Queries) error) error {
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	if err := fn(s.queries.WithTx(tx)); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s postgresStorage) CreateActualVersion(ctx context.Context) (sqlc.ActualVersion, error) {
+	version, err := s.queries.CreateActualVersion(ctx)
+	if err != nil {
+		return sqlc.ActualVersion{}, err
+	}
+	err = s.queries.CreateActualAlbumPartition(ctx, version.VersionID)
+	if err != nil {
+		return sqlc.ActualVersion{}, fmt.Errorf("error creating actual album partition: %w", err)
+	}
+	return version, nil
+}
+
+func (s postgresStorage) CreateLocalVersion(ctx context.Context) (sqlc.LocalVersion, error) {
+	version, err := s.queries.CreateLocalVersion(ctx)
+	if err != nil {
+		return sqlc.LocalVersion{}, err
+	}
+	err = s.queries.CreateLocalAlbumPartition(ctx, version.VersionID)
+	if err != nil {
+		return sqlc.LocalVersion{}, fmt.Errorf("error creating local album partition: %w", err)
+	}
+	return version, nil
+}
+
+func (s postgresStorage) GetActualVersion(ctx context.Context, id int32) (sqlc.ActualVersion, error) {
+	version, err := s.queries.GetActualVersion(ctx, id)
+	return version, notFound(err)
+}
+
+func (s postgresStorage) GetLocalVersion(ctx context.Context, id int32) (sqlc.LocalVersion, error) {
+	version, err := s.queries.GetLocalVersion(ctx, id)
+	return version, notFound(err)
+}
+
+func (s postgresStorage) ListActualVersions(ctx context.Context, limit int32) ([]sqlc.ActualVersion, error) {
+	return s.queries.ListActualVersions(ctx, limit)
+}
+
+func (s postgresStorage) ListLocalVersions(ctx context.Context, limit int32) ([]sqlc.LocalVersion, error) {
+	return s.queries.ListLocalVersions(ctx, limit)
+}
+
+func (s postgresStorage) GetResumableActualVersion(ctx context.Context) (sqlc.ActualVersion, error) {
+	version, err := s.queries.GetResumableActualVersion(ctx)
+	return version, notFound(err)
+}
+
+func (s postgresStorage) UpdateActualVersionProgress(ctx context.Context, id int32, lastArtist string) error {
+	return s.queries.UpdateActualVersionProgress(ctx, sqlc.UpdateActualVersionProgressParams{
+		LastArtist: &lastArtist,
+		Version:    id,
+	})
+}
+
+func (s postgresStorage) SetVersionStatus(ctx context.Context, kind VersionKind, id int32, status VersionStatus) error {
+	if kind == VersionKindActual {
+		return s.queries.SetActualVersionStatus(ctx, sqlc.SetActualVersionStatusParams{Status: string(status), Version: id})
+	}
+	return s.queries.SetLocalVersionStatus(ctx, sqlc.SetLocalVersionStatusParams{Status: string(status), Version: id})
+}
+
+func (s postgresStorage) UnpublishVersion(ctx context.Context, kind VersionKind, id int32) error {
+	if kind == VersionKindActual {
+		return s.queries.UnpublishActualVersion(ctx, id)
+	}
+	return s.queries.UnpublishLocalVersion(ctx, id)
+}
+
+func (s postgresStorage) PinVersion(ctx context.Context, kind VersionKind, id int32) error {
+	if kind == VersionKindActual {
+		return s.queries.PinActualVersion(ctx, id)
+	}
+	return s.queries.PinLocalVersion(ctx, id)
+}
+
+func (s postgresStorage) UnpinVersions(ctx context.Context, kind VersionKind) error {
+	if kind == VersionKindActual {
+		return s.queries.UnpinActualVersions(ctx)
+	}
+	return s.queries.UnpinLocalVersions(ctx)
+}
+
+func (s postgresStorage) GetAbandonedVersions(ctx context.Context, kind VersionKind) ([]int32, error) {
+	if kind == VersionKindActual {
+		return s.queries.GetAbandonedActualVersions(ctx)
+	}
+	return s.queries.GetAbandonedLocalVersions(ctx)
+}
+
+func (s postgresStorage) DeleteVersion(ctx context.Context, kind VersionKind, id int32) error {
+	if kind == VersionKindActual {
+		if err := s.queries.DropActualAlbumPartition(ctx, id); err != nil {
+			return fmt.Errorf("error dropping actual album partition %d: %w", id, err)
+		}
+		return s.queries.DeleteActualVersion(ctx, id)
+	}
+	if err := s.queries.DropLocalAlbumPartition(ctx, id); err != nil {
+		return fmt.Errorf("error dropping local album partition %d: %w", id, err)
+	}
+	return s.queries.DeleteLocalVersion(ctx, id)
+}
+
+func (s postgresStorage) CountAlbums(ctx context.Context, kind VersionKind, id int32) (int64, error) {
+	if kind == VersionKindActual {
+		return s.queries.CountActualAlbums(ctx, id)
+	}
+	return s.queries.CountLocalAlbums(ctx, id)
+}
+
+func (s postgresStorage) CountPublishedAlbums(ctx context.Context, kind VersionKind) (int64, error) {
+	if kind == VersionKindActual {
+		return s.queries.CountPublishedActualAlbums(ctx)
+	}
+	return s.queries.CountPublishedLocalAlbums(ctx)
+}
+
+func (s postgresStorage) WriteLocalAlbums(ctx context.Context, albums []sqlc.LocalAlbum) error {
+	return s.inTx(ctx, func(q *sqlc.Queries) error {
+		for start := 0; start < len(albums); start += copyBatchSize {
+			batch := albums[start:min(start+copyBatchSize, len(albums))]
+			params := make([]sqlc.CopyLocalAlbumsParams, len(batch))
+			for i, album := range batch {
+				params[i] = sqlc.CopyLocalAlbumsParams(album)
+			}
+			if _, err := q.CopyLocalAlbums(ctx, params); err != nil {
+				return fmt.Errorf("error copying local albums: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s postgresStorage) WriteActualAlbums(ctx context.Context, id int32, lastArtist string, albums []sqlc.ActualAlbum) error {
+	return s.inTx(ctx, func(q *sqlc.Queries) error {
+		for start := 0; start < len(albums); start += copyBatchSize {
+			batch := albums[start:min(start+copyBatchSize, len(albums))]
+			params := make([]sqlc.CopyActualAlbumsParams, len(batch))
+			for i, album := range batch {
+				album.VersionID = id
+				params[i] = sqlc.CopyActualAlbumsParams(album)
+			}
+			if _, err := q.CopyActualAlbums(ctx, params); err != nil {
+				return fmt.Errorf("error copying actual albums: %w", err)
+			}
+		}
+		return q.UpdateActualVersionProgress(ctx, sqlc.UpdateActualVersionProgressParams{
+			LastArtist: &lastArtist,
+			Version:    id,
+		})
+	})
+}
+
+func (s postgresStorage) GetActualAlbumIDs(ctx context.Context, id int32) ([]string, error) {
+	return s.queries.GetActualAlbumIDs(ctx, id)
+}
+
+func (s postgresStorage) GetLocalAlbums(ctx context.Context) ([]sqlc.LocalAlbumPublished, error) {
+	return s.queries.GetLocalAlbums(ctx)
+}
+
+func (s postgresStorage) GetActualAlbums(ctx context.Context) ([]sqlc.ActualAlbumPublished, error) {
+	return s.queries.GetActualAlbums(ctx)
+}
+
+func (s postgresStorage) GetLocalArtists(ctx context.Context) ([]string, error) {
+	return s.queries.GetLocalArtists(ctx)
+}
+
+func (s postgresStorage) GetRecentArtists(ctx context.Context, sinceYear int32) ([]string, error) {
+	rows, err := s.queries.GetRecentArtists(ctx, &sinceYear)
+	if err != nil {
+		return nil, err
+	}
+	artists := make([]string, 0, len(rows))
+	for _, artist := range rows {
+		if artist != nil {
+			artists = append(artists, *artist)
+		}
+	}
+	return artists, nil
+}
+
+func (s postgresStorage) GetExcludedArtists(ctx context.Context) ([]string, error) {
+	return s.queries.GetExcludedArtists(ctx)
+}
+
+func (s postgresStorage) GetExcludedAlbums(ctx context.Context) ([]sqlc.ExcludedAlbum, error) {
+	return s.queries.GetExcludedAlbums(ctx)
+}
+
+func (s postgresStorage) GetArtistAliases(ctx context.Context) ([]sqlc.ArtistAlias, error) {
+	return s.queries.GetArtistAliases(ctx)
+}
+
+func (s postgresStorage) ReplaceArtistAliases(ctx context.Context, artist string, aliases []string) error {
+	return s.inTx(ctx, func(q *sqlc.Queries) error {
+		if err := q.DeleteArtistAliases(ctx, artist); err != nil {
+			return err
+		}
+		for _, alias := range aliases {
+			err := q.InsertArtistAlias(ctx, sqlc.InsertArtistAliasParams{Artist: artist, Alias: alias})
+			if err != nil {
+				return fmt.Errorf("error inserting alias %s of %s: %w", alias, artist, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s postgresStorage) GetMatchOverrides(ctx context.Context) ([]sqlc.MatchOverride, error) {
+	return s.queries.GetMatchOverrides(ctx)
+}
+
+func (s postgresStorage) SetMatchOverride(ctx context.Context, override sqlc.MatchOverride) error {
+	return s.queries.UpsertMatchOverride(ctx, sqlc.UpsertMatchOverrideParams{
+		LocalArtist: override.LocalArtist,
+		LocalAlbum:  override.LocalAlbum,
+		ActualID:    override.ActualID,
+	})
+}
+
+func (s postgresStorage) DeleteMatchOverride(ctx context.Context, artist string, album string) (int64, error) {
+	return s.queries.DeleteMatchOverride(ctx, sqlc.DeleteMatchOverrideParams{LocalArtist: artist, LocalAlbum: album})
+}
+
+func (s postgresStorage) GetReleaseStates(ctx context.Context) ([]sqlc.ReleaseState, error) {
+	return s.queries.GetReleaseStates(ctx)
+}
+
+func (s postgresStorage) SetReleaseState(ctx context.Context, state sqlc.ReleaseState) error {
+	return s.queries.UpsertReleaseState(ctx, sqlc.UpsertReleaseStateParams{
+		ActualID: state.ActualID,
+		State:    state.State,
+		Until:    state.Until,
+	})
+}
+
+func (s postgresStorage) DeleteReleaseState(ctx context.Context, actualID string) (int64, error) {
+	return s.queries.DeleteReleaseState(ctx, actualID)
+}
+
+func (s postgresStorage) GetArtistSettings(ctx context.Context) ([]sqlc.ArtistSetting, error) {
+	return s.queries.GetArtistSettings(ctx)
+}
+
+func (s postgresStorage) SetArtistSetting(ctx context.Context, setting sqlc.ArtistSetting) error {
+	return upsertArtistSetting(ctx, s.queries, setting)
+}
+
+func upsertArtistSetting(ctx context.Context, q *sqlc.Queries, setting sqlc.ArtistSetting) error {
+	return q.UpsertArtistSetting(ctx, sqlc.UpsertArtistSettingParams{
+		Artist:         setting.Artist,
+		Notification:   setting.Notification,
+		Since:          setting.Since,
+		Collaborations: setting.Collaborations,
+	})
+}
+
+func (s postgresStorage) DeleteArtistSetting(ctx context.Context, artist string) (int64, error) {
+	return s.queries.DeleteArtistSetting(ctx, artist)
+}
+
+func (s postgresStorage) ReplaceArtistSettings(ctx context.Context, settings []sqlc.ArtistSetting) error {
+	return s.inTx(ctx, func(q *sqlc.Queries) error {
+		existing, err := q.GetArtistSettings(ctx)
+		if err != nil {
+			return err
+		}
+		listed := make(map[string]bool, len(settings))
+		for _, setting := range settings {
+			listed[setting.Artist] = true
+			if err := upsertArtistSetting(ctx, q, setting); err != nil {
+				return fmt.Errorf("error updating setting of %s: %w", setting.Artist, err)
+			}
+		}
+		for _, setting := range existing {
+			if listed[setting.Artist] {
+				continue
+			}
+			if _, err := q.DeleteArtistSetting(ctx, setting.Artist); err != nil {
+				return fmt.Errorf("error deleting setting of %s: %w", setting.Artist, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s postgresStorage) GetCache(ctx context.Context, entity string, id string) (CacheEntry, error) {
+	row, err := s.queries.GetCache(ctx, sqlc.GetCacheParams{
+		Entity: entity,
+		ID:     id,
+	})
+	if err != nil {
+		return CacheEntry{}, notFound(err)
+	}
+	return CacheEntry{Value: row.Value, Negative: row.Negative, Ts: row.Ts.Time}, nil
+}
+
+func (s postgresStorage) GetAllCache(ctx context.Context, entity string, since time.Time) (map[string][]byte, error) {
+	// use iterator
+	// https://github.com/sqlc-dev/sqlc/issues/720
+	rows, err := s.queries.GetAll(ctx, sqlc.GetAllParams{
+		Entity: entity,
+		Ts:     pgtype.Timestamptz{Time: since, Valid: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]byte)
+	for _, row := range rows {
+		result[row.ID] = row.Value
+	}
+	return result, nil
+}
+
+func (s postgresStorage) InsertCache(ctx context.Context, entity string, id string, entry CacheEntry) error {
+	return s.queries.InsertCache(ctx, sqlc.InsertCacheParams{
+		Entity:   entity,
+		ID:       id,
+		Value:    entry.Value,
+		Negative: entry.Negative,
+	})
+}
+
+func (s postgresStorage) GetStaleCache(ctx context.Context, entity string, before time.Time, limit int32) ([]CacheKey, error) {
+	rows, err := s.queries.GetStaleCache(ctx, sqlc.GetStaleCacheParams{
+		Entity: entity,
+		Ts:     pgtype.Timestamptz{Time: before, Valid: true},
+		Limit:  limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]CacheKey, len(rows))
+	for i, row := range rows {
+		keys[i] = CacheKey{Entity: entity, ID: row.ID, Ts: row.Ts.Time}
+	}
+	return keys, nil
+}
+
+func (s postgresStorage) ListCache(ctx context.Context) ([]CacheKey, error) {
+	rows, err := s.queries.ListCache(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]CacheKey, len(rows))
+	for i, row := range rows {
+		keys[i] = CacheKey{Entity: row.Entity, ID: row.ID, Ts: row.Ts.Time, Negative: row.Negative}
+	}
+	return keys, nil
+}
+
+func (s postgresStorage) GetCacheRecords(ctx context.Context, entity string) ([]CacheRecord, error) {
+	rows, err := s.queries.GetCacheRecords(ctx, entity)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]CacheRecord, len(rows))
+	for i, row := range rows {
+		records[i] = CacheRecord{
+			CacheKey: CacheKey{Entity: row.Entity, ID: row.ID, Ts: row.Ts.Time, Negative: row.Negative},
+			Value:    row.Value,
+		}
+	}
+	return records, nil
+}
+
+func (s postgresStorage) DeleteCache(ctx context.Context, entity string, id string, prefix bool) (int64, error) {
+	if prefix {
+		return s.queries.DeleteCacheByPrefix(ctx, sqlc.DeleteCacheByPrefixParams{Entity: entity, Prefix: id})
+	}
+	return s.queries.DeleteCacheEntry(ctx, sqlc.DeleteCacheEntryParams{Entity: entity, ID: id})
+}
+
+func (s postgresStorage) ImportCache(ctx context.Context, records []CacheRecord) error {
+	return s.inTx(ctx, func(q *sqlc.Queries) error {
+		for _, record := range records {
+			err := q.ImportCache(ctx, sqlc.ImportCacheParams{
+				Entity:   record.Entity,
+				ID:       record.ID,
+				Value:    record.Value,
+				Negative: record.Negative,
+				Ts:       pgtype.Timestamptz{Time: record.Ts, Valid: true},
+			})
+			if err != nil {
+				return fmt.Errorf("error importing %s %s: %w", record.Entity, record.ID, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s postgresStorage) migrationsDir() string {
+	return "migrations/postgres"
+}
+
+func (s postgresStorage) ensureMigrationsTable(ctx context.Context) error {
+	_, err := s.conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version int4 NOT NULL,
+	name varchar NOT NULL,
+	applied_at timestamp DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	CONSTRAINT schema_migrations_pk PRIMARY KEY (version)
+)`)
+	return err
+}
+
+func (s postgresStorage) appliedMigrations(ctx context.Context) (map[int]time.Time, error) {
+	rows, err := s.conn.Query(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int32
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[int(version)] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+func (s postgresStorage) applyMigration(ctx context.Context, migration Migration) error {
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, migration.SQL); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)",
+		migration.Version, migration.Name)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
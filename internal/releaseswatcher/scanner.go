@@ -25,9 +25,11 @@ func ReadID3(filepath string) (tag.Metadata, error) {
 	return tag, nil
 }
 
+// Scan sends the audio files under the root, the channel is closed when the walk is over or cancelled.
 func Scan(ctx context.Context, root string, excluded_path string,
 	filenames chan<- string, counter *atomic.Int32) error {
-	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+	defer close(filenames)
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
@@ -42,16 +44,13 @@ func Scan(ctx context.Context, root string, excluded_path string,
 		}
 		ext := strings.ToLower(filepath.Ext(path))
 		if ext == ".mp3" || ext == ".m4a" {
-
-			filenames <- path
+			select {
+			case filenames <- path:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
 			counter.Add(1)
 		}
 		return nil
 	})
-	if err != nil {
-		return err
-	}
-	close(
-		filenames)
-	return nil
 }
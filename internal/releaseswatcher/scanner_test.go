@@ -23,7 +23,7 @@ func TestScan(t *testing.T) {
 
 	ch := make(chan string, 10)
 	var counter atomic.Int32
-	err := Scan(context.Background(), tmp, ch, &counter)
+	err := Scan(context.Background(), tmp, filepath.Join(tmp, "skip"), ch, &counter)
 	if err != nil {
 		t.Fatalf("Scan failed: %v", err)
 	}
@@ -39,6 +39,25 @@ func TestScan(t *testing.T) {
 	}
 }
 
+func TestScan_Cancelled(t *testing.T) {
+	tmp := t.TempDir()
+	os.WriteFile(filepath.Join(tmp, "a.mp3"), []byte("dummy"), 0o644)
+	os.WriteFile(filepath.Join(tmp, "b.mp3"), []byte("dummy"), 0o644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// nobody reads the channel, the scan must not block on it
+	ch := make(chan string)
+	var counter atomic.Int32
+	err := Scan(ctx, tmp, "", ch, &counter)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if _, ok := <-ch; ok {
+		t.Errorf("expected the channel to be closed")
+	}
+}
+
 type fakeReadSeekerCloser struct {
 	*bytes.Reader
 	closed bool
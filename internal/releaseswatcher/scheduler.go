@@ -0,0 +1,95 @@
+package releaseswatcher
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type WarmUpConfig struct {
+	// DailyBudget is the number of cache entries refreshed per day
+	DailyBudget int `envDefault:"2000"`
+	// Interval between refresh rounds, the budget is spread evenly over them
+	Interval time.Duration `envDefault:"10m"`
+	// Horizon is how long before expiry an entry can be refreshed
+	Horizon time.Duration `envDefault:"24h"`
+	// RecentYears selects artists with releases in that many last years to refresh first
+	RecentYears int `envDefault:"2"`
+}
+
+// artistPrioritizer is implemented by libraries which know the cache entries of an artist.
+type artistPrioritizer interface {
+	// ArtistPriority returns whether the cache entry belongs to one of the artists
+	ArtistPriority(ctx context.Context, artists []string) func(key CacheKey) bool
+}
+
+// CacheScheduler refreshes cache entries approaching expiry ahead of time, so the nightly
+// update of the actual library mostly hits the cache.
+type CacheScheduler struct {
+	cache  Cache
+	db     DB
+	lib    Library
+	config WarmUpConfig
+}
+
+func NewCacheScheduler(config WarmUpConfig, cache Cache, db DB, lib Library) (CacheScheduler, error) {
+	if config.Interval <= 0 {
+		return CacheScheduler{}, fmt.Errorf("warm-up interval must be positive, got %v", config.Interval)
+	}
+	if config.DailyBudget <= 0 {
+		return CacheScheduler{}, fmt.Errorf("warm-up daily budget must be positive, got %d", config.DailyBudget)
+	}
+	if config.Horizon < 0 {
+		return CacheScheduler{}, fmt.Errorf("warm-up horizon must not be negative, got %v", config.Horizon)
+	}
+	return CacheScheduler{
+		cache:  cache,
+		db:     db,
+		lib:    lib,
+		config: config,
+	}, nil
+}
+
+// batchSize is the share of the daily budget for a single round.
+func (s CacheScheduler) batchSize() int32 {
+	rounds := float64(24*time.Hour) / float64(s.config.Interval)
+	return int32(max(1, float64(s.config.DailyBudget)/rounds))
+}
+
+func (s CacheScheduler) priority(ctx context.Context) func(key CacheKey) bool {
+	prioritizer, ok := s.lib.(artistPrioritizer)
+	if !ok {
+		return nil
+	}
+	since := int32(time.Now().Year() - s.config.RecentYears)
+	artists, err := s.db.GetRecentArtists(ctx, since)
+	if err != nil {
+		log.Warnf("Error loading artists with recent releases: %v", err)
+		return nil
+	}
+	return prioritizer.ArtistPriority(ctx, artists)
+}
+
+// RunOnce refreshes a single batch of expiring entries.
+func (s CacheScheduler) RunOnce(ctx context.Context) (int, error) {
+	return s.cache.RefreshExpiring(ctx, s.config.Horizon, s.batchSize(), s.priority(ctx))
+}
+
+// Run refreshes expiring entries every interval until the context is done.
+func (s CacheScheduler) Run(ctx context.Context) {
+	log.Infof("Warming up the cache: %d entries every %v", s.batchSize(), s.config.Interval)
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+	for {
+		count, err := s.RunOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Errorf("Cache warm-up error: %v", err)
+		}
+		log.Infof("Refreshed %d expiring cache entries", count)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package releaseswatcher
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pochemuto/releases-watcher/sqlc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/thriftrw/ptr"
+)
+
+// priorityLibrary prioritizes the cache entries named after the artists
+type priorityLibrary struct {
+	artists []string
+}
+
+func (l *priorityLibrary) GetActualAlbumsForArtists(ctx context.Context, artists []string, out chan<- sqlc.ActualAlbum) {
+	close(out)
+}
+
+func (l *priorityLibrary) Name() string {
+	return "Test"
+}
+
+func (l *priorityLibrary) ArtistPriority(ctx context.Context, artists []string) func(key CacheKey) bool {
+	l.artists = artists
+	return func(key CacheKey) bool { return slices.Contains(artists, key.ID) }
+}
+
+func TestNewCacheScheduler_InvalidConfig(t *testing.T) {
+	valid := WarmUpConfig{DailyBudget: 2000, Interval: 10 * time.Minute, Horizon: 24 * time.Hour}
+	_, err := NewCacheScheduler(valid, Cache{}, DB{}, nil)
+	require.NoError(t, err)
+
+	for name, config := range map[string]WarmUpConfig{
+		"zero interval":     {DailyBudget: 2000, Horizon: time.Hour},
+		"negative interval": {DailyBudget: 2000, Interval: -time.Minute, Horizon: time.Hour},
+		"zero budget":       {Interval: time.Minute, Horizon: time.Hour},
+		"negative horizon":  {DailyBudget: 2000, Interval: time.Minute, Horizon: -time.Hour},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewCacheScheduler(config, Cache{}, DB{}, nil)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestCacheScheduler_BatchSize(t *testing.T) {
+	for _, test := range []struct {
+		budget   int
+		interval time.Duration
+		batch    int32
+	}{
+		{budget: 2000, interval: 10 * time.Minute, batch: 13},
+		{budget: 2400, interval: time.Hour, batch: 100},
+		{budget: 10, interval: time.Minute, batch: 1},
+	} {
+		scheduler, err := NewCacheScheduler(WarmUpConfig{DailyBudget: test.budget, Interval: test.interval}, Cache{}, DB{}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, test.batch, scheduler.batchSize(), "%d per day every %v", test.budget, test.interval)
+	}
+}
+
+func TestCacheScheduler_RunOnce(t *testing.T) {
+	ctx := context.Background()
+	cache := setupTestCache(t, CacheConfig{TTL: CachePolicy{EntityMusicBrainzArtistSearch: time.Hour}})
+	db, err := NewDB(cache.store)
+	require.NoError(t, err)
+
+	version, err := db.CreateActualVersion(ctx)
+	require.NoError(t, err)
+	albums := []sqlc.ActualAlbum{
+		{ID: "1", Artist: ptr.String("Recent"), Name: ptr.String("New"), Year: ptr.Int32(int32(time.Now().Year()))},
+		{ID: "2", Artist: ptr.String("Old"), Name: ptr.String("Debut"), Year: ptr.Int32(1990)},
+	}
+	require.NoError(t, db.WriteActualAlbums(ctx, version, "Old", albums))
+	require.NoError(t, db.PublishActualVersion(ctx, version))
+
+	for _, artist := range []string{"Old", "Other", "Recent"} {
+		_, err := GetCached(cache, ctx, EntityMusicBrainzArtistSearch, artist, func() (*cachedValue, error) {
+			return &cachedValue{Name: artist}, nil
+		})
+		require.NoError(t, err)
+	}
+	var refreshed []string
+	cache.RegisterRefresher(EntityMusicBrainzArtistSearch, func(ctx context.Context, id string) error {
+		refreshed = append(refreshed, id)
+		return nil
+	})
+
+	lib := &priorityLibrary{}
+	// 288 entries a day in rounds every 10 minutes make 2 entries a round
+	config := WarmUpConfig{DailyBudget: 288, Interval: 10 * time.Minute, Horizon: 2 * time.Hour, RecentYears: 2}
+	scheduler, err := NewCacheScheduler(config, cache, db, lib)
+	require.NoError(t, err)
+
+	count, err := scheduler.RunOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count, "a round is limited by the budget")
+	assert.Equal(t, []string{"Recent"}, lib.artists)
+	require.Len(t, refreshed, 2)
+	assert.Equal(t, "Recent", refreshed[0], "artists with recent releases go first")
+
+	scheduler.config.Horizon = 30 * time.Minute
+	refreshed = nil
+	count, err = scheduler.RunOnce(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, count, "entries expire after the horizon")
+}
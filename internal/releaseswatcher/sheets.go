@@ -4,85 +4,39 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"slices"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/pochemuto/releases-watcher/sqlc"
 	"google.golang.org/api/option"
 	"google.golang.org/api/sheets/v4"
 )
 
 const (
 	settingsSheetName   = "Настройки"
-	headerRange         = settingsSheetName + "!A1:B1"
-	settingsDataRange   = settingsSheetName + "!A2:B"
+	headerRange         = settingsSheetName + "!A1:D1"
+	settingsDataRange   = settingsSheetName + "!A2:D"
 	defaultHeaderTitle  = "Artist"
 	defaultHeaderNotice = "Notification"
+	defaultHeaderSince  = "Since"
+	defaultHeaderCollab = "Collaborations"
 
 	releasesSheetName  = "Релизы"
-	releasesRange      = releasesSheetName + "!A1:I"
-	releasesClearRange = releasesSheetName + "!A:I"
+	releasesRange      = releasesSheetName + "!A1:J"
+	releasesClearRange = releasesSheetName + "!A:J"
+
+	// overridesSheetName is optional, its rows are a local artist, a local album and a release ID,
+	// an empty ID or "-" means the album has no match
+	overridesSheetName = "Сопоставления"
+	overridesDataRange = overridesSheetName + "!A2:C"
+	overrideNoMatch    = "-"
 )
 
-type NotificationSetting string
-
-const (
-	NotificationAllReleases NotificationSetting = "Все релизы"
-	NotificationAlbumsAndEP NotificationSetting = "Альбомы и EP"
-	NotificationAlbumsOnly  NotificationSetting = "Альбомы"
-	NotificationDoNotTrack  NotificationSetting = "Не отслеживать"
-)
-
-var notificationScope = map[NotificationSetting]map[Kind]bool{
-	NotificationAllReleases: {
-		KindAlbum:   true,
-		KindEP:      true,
-		KindSingle:  true,
-		KindUnknown: true,
-	},
-	NotificationAlbumsAndEP: {
-		KindAlbum:   true,
-		KindEP:      true,
-		KindSingle:  false,
-		KindUnknown: false,
-	},
-	NotificationAlbumsOnly: {
-		KindAlbum:   true,
-		KindEP:      false,
-		KindSingle:  false,
-		KindUnknown: false,
-	},
-}
-
-func (n NotificationSetting) IsReleaseInScope(kind Kind) bool {
-	return notificationScope[n][kind]
-}
-
-func (n NotificationSetting) String() string {
-	return string(n)
-}
-
-func parseNotificationSetting(raw string) (NotificationSetting, error) {
-	value := strings.TrimSpace(raw)
-	switch value {
-	case "", string(NotificationAllReleases):
-		return NotificationAllReleases, nil
-	case string(NotificationAlbumsAndEP):
-		return NotificationAlbumsAndEP, nil
-	case string(NotificationDoNotTrack):
-		return NotificationDoNotTrack, nil
-	case string(NotificationAlbumsOnly):
-		return NotificationAlbumsOnly, nil
-	default:
-		return "", fmt.Errorf("unknown notification value %q", raw)
-	}
-}
-
-type ArtistSetting struct {
-	ArtistName   string
-	Notification NotificationSetting
-}
-
 type GoogleSheetsConfig struct {
+	// Enabled writes releases to the spreadsheet, artist settings are read from it with the "sheets" store
+	Enabled         bool   `envDefault:"true"`
 	CredentialsFile string `envDefault:"google-credentials.json"`
 	SpreadsheetID   string `envDefault:"1j-xtIRVbdzguaBoaW3l52VMmVJmOtP4lggR7O3yW9E8"`
 }
@@ -92,27 +46,35 @@ type GoogleSheets struct {
 	spreadsheetID string
 }
 
-func NewGoogleSheets(ctx context.Context, config GoogleSheetsConfig) (GoogleSheets, error) {
+// NewGoogleSheets returns nil when Google Sheets are disabled.
+func NewGoogleSheets(ctx context.Context, config GoogleSheetsConfig) (*GoogleSheets, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
 	opts := []option.ClientOption{
 		option.WithScopes(sheets.SpreadsheetsScope),
 	}
 	if config.CredentialsFile != "" {
 		if _, err := os.Stat(config.CredentialsFile); err != nil {
-			return GoogleSheets{}, fmt.Errorf("check credentials file: %w", err)
+			return nil, fmt.Errorf("check credentials file: %w", err)
 		}
 		opts = append(opts, option.WithCredentialsFile(config.CredentialsFile))
 	}
 
 	service, err := sheets.NewService(ctx, opts...)
 	if err != nil {
-		return GoogleSheets{}, fmt.Errorf("failed to create sheets client: %w", err)
+		return nil, fmt.Errorf("failed to create sheets client: %w", err)
 	}
-	return GoogleSheets{
+	return &GoogleSheets{
 		service:       service,
 		spreadsheetID: config.SpreadsheetID,
 	}, nil
 }
 
+func (g *GoogleSheets) Name() string {
+	return "Google Sheets"
+}
+
 func (g *GoogleSheets) GetArtistSettings(ctx context.Context) ([]ArtistSetting, error) {
 	header, settings, err := g.readSettings(ctx)
 	if err != nil {
@@ -122,14 +84,61 @@ func (g *GoogleSheets) GetArtistSettings(ctx context.Context) ([]ArtistSetting,
 	return settings, nil
 }
 
+// GetMatchOverrides reads the overrides sheet, nothing is overridden without the sheet.
+func (g *GoogleSheets) GetMatchOverrides(ctx context.Context) ([]sqlc.MatchOverride, error) {
+	spreadsheet, err := g.service.Spreadsheets.Get(g.spreadsheetID).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("fetch spreadsheet: %w", err)
+	}
+	if !slices.ContainsFunc(spreadsheet.Sheets, func(sheet *sheets.Sheet) bool {
+		return sheet.Properties != nil && sheet.Properties.Title == overridesSheetName
+	}) {
+		return nil, nil
+	}
+
+	resp, err := g.service.Spreadsheets.Values.Get(g.spreadsheetID, overridesDataRange).
+		Context(ctx).
+		MajorDimension("ROWS").
+		Do()
+	if err != nil {
+		return nil, fmt.Errorf("fetch overrides: %w", err)
+	}
+	overrides := make([]sqlc.MatchOverride, 0, len(resp.Values))
+	for idx, row := range resp.Values {
+		cells := make([]string, 3)
+		for i := range min(len(row), len(cells)) {
+			cells[i] = strings.TrimSpace(fmt.Sprint(row[i]))
+		}
+		if cells[0] == "" && cells[1] == "" {
+			continue
+		}
+		if cells[0] == "" || cells[1] == "" {
+			return nil, fmt.Errorf("row %d: local artist and album are required", idx+2)
+		}
+		override := sqlc.MatchOverride{LocalArtist: cells[0], LocalAlbum: cells[1]}
+		if id := cells[2]; id != "" && id != overrideNoMatch {
+			override.ActualID = &id
+		}
+		overrides = append(overrides, override)
+	}
+	return overrides, nil
+}
+
 func (g *GoogleSheets) UpdateArtistsInSettings(ctx context.Context, artists []string) error {
 	header, settings, err := g.readSettings(ctx)
 	if err != nil {
 		return err
 	}
 
-	if len(header) == 0 || isHeaderEmpty(header) {
-		newHeader := []any{defaultHeaderTitle, defaultHeaderNotice}
+	defaultHeader := []any{defaultHeaderTitle, defaultHeaderNotice, defaultHeaderSince, defaultHeaderCollab}
+	if len(header) < len(defaultHeader) || isHeaderEmpty(header) {
+		// titles set by hand are kept
+		newHeader := cloneRow(defaultHeader)
+		for i, cell := range header {
+			if strings.TrimSpace(fmt.Sprint(cell)) != "" {
+				newHeader[i] = cell
+			}
+		}
 		valueRange := &sheets.ValueRange{
 			MajorDimension: "ROWS",
 			Range:          headerRange,
@@ -144,35 +153,11 @@ func (g *GoogleSheets) UpdateArtistsInSettings(ctx context.Context, artists []st
 		header = newHeader
 	}
 
-	existingNotifications := make(map[string]NotificationSetting, len(settings))
-	for _, setting := range settings {
-		existingNotifications[setting.ArtistName] = setting.Notification
-	}
-
-	uniqueArtists := make([]string, 0, len(artists))
-	seen := make(map[string]struct{}, len(artists))
-	for _, artist := range artists {
-		name := strings.TrimSpace(artist)
-		if name == "" {
-			continue
-		}
-		if _, ok := seen[name]; ok {
-			continue
-		}
-		seen[name] = struct{}{}
-		uniqueArtists = append(uniqueArtists, name)
-	}
-
-	sort.Strings(uniqueArtists)
-
-	rows := make([][]any, 0, len(uniqueArtists))
-
-	for _, artist := range uniqueArtists {
-		notification, ok := existingNotifications[artist]
-		if !ok {
-			notification = NotificationAllReleases
-		}
-		rows = append(rows, []any{artist, notification.String()})
+	merged := mergeArtistSettings(settings, artists)
+	rows := make([][]any, 0, len(merged))
+	for _, setting := range merged {
+		rows = append(rows, []any{setting.ArtistName, setting.Notification.String(), formatSince(setting.Since),
+			string(setting.Collaborations)})
 	}
 
 	clearRequest := &sheets.ClearValuesRequest{}
@@ -215,7 +200,7 @@ var releaseStates = map[releaseState]string{
 func (g *GoogleSheets) UpdateReleases(ctx context.Context, releases []MatchedAlbum) error {
 	rows := make([][]any, 0, len(releases)+1)
 	rows = append(rows, []any{"Артист общий", "Артист", "Альбом", "Локальный артист", "Локальный альбом", "Тип", "Год",
-		"Ссылка", "В коллекции"})
+		"Ссылка", "В коллекции", "Статус"})
 
 	sort.SliceStable(releases, func(i, j int) bool {
 		a := releases[i]
@@ -275,12 +260,24 @@ func (g *GoogleSheets) UpdateReleases(ctx context.Context, releases []MatchedAlb
 		}
 
 		inCollection := releaseStates[releaseState{inActual: release.Actual != nil, inLocal: release.Local != nil}]
+		if release.EditionOf != nil {
+			localArtist = release.EditionOf.Artist
+			localAlbum = release.EditionOf.Name
+			inCollection = "Издание"
+		}
+		if release.Filtered != "" {
+			inCollection = fmt.Sprintf("Пропущен (%s)", release.Filtered)
+		}
 
 		commonArtist := artist
 		if commonArtist == "" {
 			commonArtist = localArtist
 		}
-		rows = append(rows, []any{commonArtist, artist, album, localArtist, localAlbum, kind, year, link, inCollection})
+		state := ""
+		if release.State != nil {
+			state = formatReleaseState(*release.State)
+		}
+		rows = append(rows, []any{commonArtist, artist, album, localArtist, localAlbum, kind, year, link, inCollection, state})
 	}
 
 	clearRequest := &sheets.ClearValuesRequest{}
@@ -368,9 +365,25 @@ func (g *GoogleSheets) readSettings(ctx context.Context) ([]any, []ArtistSetting
 		} else {
 			notification = NotificationAllReleases
 		}
+		var since time.Time
+		if len(row) > 2 {
+			since, err = parseSince(fmt.Sprint(row[2]))
+			if err != nil {
+				return nil, nil, fmt.Errorf("row %d: %w", idx+2, err)
+			}
+		}
+		var collaborations CollaborationSetting
+		if len(row) > 3 {
+			collaborations, err = parseCollaborationSetting(fmt.Sprint(row[3]))
+			if err != nil {
+				return nil, nil, fmt.Errorf("row %d: %w", idx+2, err)
+			}
+		}
 		settings = append(settings, ArtistSetting{
-			ArtistName:   artist,
-			Notification: notification,
+			ArtistName:     artist,
+			Notification:   notification,
+			Since:          since,
+			Collaborations: collaborations,
 		})
 	}
 
@@ -0,0 +1,264 @@
+package releaseswatcher
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pochemuto/releases-watcher/sqlc"
+)
+
+// titleSynonyms replace tokens spelled differently in tags and in the libraries
+var titleSynonyms = map[string]string{
+	"&":    "and",
+	"pt":   "part",
+	"vol":  "volume",
+	"ch":   "chapter",
+	"chap": "chapter",
+	"the":  "",
+	"ft":   "feat",
+}
+
+// numberedWords are followed by numbers, so a single letter roman numeral after them is a number too
+var numberedWords = []string{"part", "volume", "chapter", "book", "act"}
+
+var romanNumerals = map[rune]int{'i': 1, 'v': 5, 'x': 10}
+
+// parseRoman parses roman numerals up to 39 written the canonical way.
+func parseRoman(token string) (int, bool) {
+	if token == "" || len(token) > 6 {
+		return 0, false
+	}
+	total, prev := 0, 0
+	for i := len(token) - 1; i >= 0; i-- {
+		value, ok := romanNumerals[rune(token[i])]
+		if !ok {
+			return 0, false
+		}
+		if value < prev {
+			total -= value
+		} else {
+			total += value
+			prev = value
+		}
+	}
+	if formatRoman(total) != token {
+		return 0, false
+	}
+	return total, true
+}
+
+func formatRoman(n int) string {
+	var b strings.Builder
+	for _, r := range []struct {
+		value  int
+		symbol string
+	}{{10, "x"}, {9, "ix"}, {5, "v"}, {4, "iv"}, {1, "i"}} {
+		for n >= r.value {
+			b.WriteString(r.symbol)
+			n -= r.value
+		}
+	}
+	return b.String()
+}
+
+func isNumber(token string) bool {
+	_, err := strconv.Atoi(token)
+	return err == nil
+}
+
+// titleTokens splits the title into transliterated words with synonyms and numbers
+// written the same way, brackets, replacements and stop words are handled like in the keys.
+func (m nameMatcher) titleTokens(title string) []string {
+	title = strings.ReplaceAll(m.normalizer.prepare(title, nil), "&", " & ")
+	words := m.normalizer.withoutStopWords(strings.FieldsFunc(title, func(r rune) bool {
+		return !m.normalizer.keeps(r) && r != '&'
+	}))
+	tokens := make([]string, 0, len(words))
+	for _, word := range words {
+		token := m.transliterate(word)
+		if synonym, ok := titleSynonyms[token]; ok {
+			token = synonym
+		}
+		if token == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(token); err == nil {
+			token = strconv.Itoa(n)
+		} else if n, ok := parseRoman(token); ok {
+			afterNumbered := len(tokens) > 0 && slices.Contains(numberedWords, tokens[len(tokens)-1])
+			if len(token) > 1 || afterNumbered {
+				token = strconv.Itoa(n)
+			}
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// titleSimilarity scores tokens of two titles from 0 to 1: the better of the token overlap,
+// which ignores the order of words, and the edit distance, which tolerates typos.
+// Titles with different numbers never match, "Part 2" is not a typo of "Part 3".
+func titleSimilarity(a []string, b []string) float64 {
+	joinedA, joinedB := strings.Join(a, " "), strings.Join(b, " ")
+	if joinedA == joinedB {
+		return 1
+	}
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	numbersA := slices.DeleteFunc(slices.Clone(a), func(t string) bool { return !isNumber(t) })
+	numbersB := slices.DeleteFunc(slices.Clone(b), func(t string) bool { return !isNumber(t) })
+	slices.Sort(numbersA)
+	slices.Sort(numbersB)
+	if !slices.Equal(numbersA, numbersB) {
+		return 0
+	}
+
+	counts := make(map[string]int, len(a))
+	for _, token := range a {
+		counts[token]++
+	}
+	common := 0
+	for _, token := range b {
+		if counts[token] > 0 {
+			counts[token]--
+			common++
+		}
+	}
+	overlap := 2 * float64(common) / float64(len(a)+len(b))
+
+	runesA, runesB := []rune(strings.Join(a, "")), []rune(strings.Join(b, ""))
+	distance := editDistance(runesA, runesB)
+	edit := 1 - float64(distance)/float64(max(len(runesA), len(runesB)))
+	return max(overlap, edit)
+}
+
+// editDistance counts insertions, deletions, substitutions and transpositions of adjacent letters.
+func editDistance(a []rune, b []rune) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(prev[j]+1, current[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				current[j] = min(current[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, current = prev, current, prev2
+	}
+	return prev[len(b)]
+}
+
+type albumCandidate struct {
+	tokens []string
+	match  *MatchedAlbum
+}
+
+// albumIndex finds local albums by the exact key or by the most similar title of the same artist.
+type albumIndex struct {
+	names     nameMatcher
+	threshold float64
+	exact     map[sqlc.LocalAlbumPublished]*MatchedAlbum
+	byArtist  map[string][]albumCandidate
+}
+
+func newAlbumIndex(names nameMatcher, threshold float64) albumIndex {
+	return albumIndex{
+		names:     names,
+		threshold: threshold,
+		exact:     make(map[sqlc.LocalAlbumPublished]*MatchedAlbum),
+		byArtist:  make(map[string][]albumCandidate),
+	}
+}
+
+// addLocal makes the album a candidate for similar titles.
+func (i albumIndex) addLocal(key sqlc.LocalAlbumPublished, name string, match *MatchedAlbum) {
+	i.exact[key] = match
+	i.byArtist[key.Artist] = append(i.byArtist[key.Artist], albumCandidate{
+		tokens: i.names.titleTokens(name),
+		match:  match,
+	})
+}
+
+// hasArtist returns whether the artist of the key has local albums.
+func (i albumIndex) hasArtist(artist string) bool {
+	return len(i.byArtist[artist]) > 0
+}
+
+// add makes the album findable by the exact key only.
+func (i albumIndex) add(key sqlc.LocalAlbumPublished, match *MatchedAlbum) {
+	i.exact[key] = match
+}
+
+// find returns the album with the same key or the local album of the artist with the most
+// similar title, if it scores at least the threshold.
+func (i albumIndex) find(key sqlc.LocalAlbumPublished, name string) (*MatchedAlbum, float64) {
+	if match, ok := i.exact[key]; ok {
+		return match, 1
+	}
+	if i.threshold > 1 {
+		return nil, 0
+	}
+	tokens := i.names.titleTokens(name)
+	var best *MatchedAlbum
+	bestScore := 0.0
+	for _, candidate := range i.byArtist[key.Artist] {
+		score := titleSimilarity(tokens, candidate.tokens)
+		if score > bestScore {
+			best, bestScore = candidate.match, score
+		}
+	}
+	if bestScore < i.threshold {
+		return nil, bestScore
+	}
+	return best, bestScore
+}
+
+// remove takes the album with the key out of matching under all of its keys and returns it.
+func (i albumIndex) remove(key sqlc.LocalAlbumPublished) *MatchedAlbum {
+	match, ok := i.exact[key]
+	if !ok {
+		return nil
+	}
+	for other, album := range i.exact {
+		if album == match {
+			delete(i.exact, other)
+		}
+	}
+	for artist, candidates := range i.byArtist {
+		i.byArtist[artist] = slices.DeleteFunc(candidates, func(candidate albumCandidate) bool {
+			return candidate.match == match
+		})
+	}
+	return match
+}
+
+func (i albumIndex) all() []*MatchedAlbum {
+	seen := make(map[*MatchedAlbum]bool, len(i.exact))
+	var result []*MatchedAlbum
+	add := func(match *MatchedAlbum) {
+		if !seen[match] {
+			seen[match] = true
+			result = append(result, match)
+		}
+	}
+	for _, match := range i.exact {
+		add(match)
+	}
+	for _, candidates := range i.byArtist {
+		for _, candidate := range candidates {
+			add(candidate.match)
+		}
+	}
+	return result
+}
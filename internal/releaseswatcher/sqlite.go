@@ -0,0 +1,734 @@
+package releaseswatcher
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pochemuto/releases-watcher/sqlc"
+)
+
+// sqliteTimeFormat matches CURRENT_TIMESTAMP, so timestamps compare as text
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+// sqliteDate writes the date in sqliteTimeFormat like the other time columns, NULL when it is not set.
+func sqliteDate(date pgtype.Date) any {
+	if !date.Valid {
+		return nil
+	}
+	return date.Time.UTC().Format(sqliteTimeFormat)
+}
+
+// sqliteStrings keeps a list in a text column as a JSON array, NULL when it is empty.
+type sqliteStrings []string
+
+func (s sqliteStrings) Value() (driver.Value, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal([]string(s))
+	return string(data), err
+}
+
+func (s *sqliteStrings) Scan(src any) error {
+	switch src := src.(type) {
+	case nil:
+		*s = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(src), (*[]string)(s))
+	case []byte:
+		return json.Unmarshal(src, (*[]string)(s))
+	}
+	return fmt.Errorf("unsupported list %T", src)
+}
+
+// sqliteStorage keeps albums of all versions in one table, a version is
+// removed by deleting its rows instead of dropping a partition.
+type sqliteStorage struct {
+	db *sql.DB
+}
+
+func NewSQLiteStorage(ctx context.Context, path string) (Storage, error) {
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite has a single writer, and every connection to :memory: is a separate database
+	db.SetMaxOpenConns(1)
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return sqliteStorage{db: db}, nil
+}
+
+type sqliteQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func sqliteNotFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s sqliteStorage) Close() {
+	s.db.Close()
+}
+
+func (s sqliteStorage) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func versionTable(kind VersionKind) string {
+	if kind == VersionKindActual {
+		return "actual_version"
+	}
+	return "local_version"
+}
+
+func albumTable(kind VersionKind) string {
+	if kind == VersionKindActual {
+		return "actual_album"
+	}
+	return "local_album"
+}
+
+func queryColumn[T any](ctx context.Context, q sqliteQuerier, query string, args ...any) ([]T, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []T
+	for rows.Next() {
+		var item T
+		if err := rows.Scan(&item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+const sqliteActualVersionColumns = "version_id, created_at, status, last_artist, pinned"
+
+func scanActualVersion(row interface{ Scan(...any) error }) (sqlc.ActualVersion, error) {
+	var v sqlc.ActualVersion
+	var createdAt time.Time
+	err := row.Scan(&v.VersionID, &createdAt, &v.Status, &v.LastArtist, &v.Pinned)
+	v.CreatedAt = pgtype.Timestamp{Time: createdAt, Valid: err == nil}
+	return v, err
+}
+
+func (s sqliteStorage) queryActualVersions(ctx context.Context, query string, args ...any) ([]sqlc.ActualVersion, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []sqlc.ActualVersion
+	for rows.Next() {
+		v, err := scanActualVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+	}
+	return items, rows.Err()
+}
+
+const sqliteLocalVersionColumns = "version_id, created_at, status, pinned"
+
+func scanLocalVersion(row interface{ Scan(...any) error }) (sqlc.LocalVersion, error) {
+	var v sqlc.LocalVersion
+	var createdAt time.Time
+	err := row.Scan(&v.VersionID, &createdAt, &v.Status, &v.Pinned)
+	v.CreatedAt = pgtype.Timestamp{Time: createdAt, Valid: err == nil}
+	return v, err
+}
+
+func (s sqliteStorage) CreateActualVersion(ctx context.Context) (sqlc.ActualVersion, error) {
+	row := s.db.QueryRowContext(ctx, "INSERT INTO actual_version (status) VALUES ('building') RETURNING "+sqliteActualVersionColumns)
+	return scanActualVersion(row)
+}
+
+func (s sqliteStorage) CreateLocalVersion(ctx context.Context) (sqlc.LocalVersion, error) {
+	row := s.db.QueryRowContext(ctx, "INSERT INTO local_version (status) VALUES ('building') RETURNING "+sqliteLocalVersionColumns)
+	return scanLocalVersion(row)
+}
+
+func (s sqliteStorage) GetActualVersion(ctx context.Context, id int32) (sqlc.ActualVersion, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+sqliteActualVersionColumns+" FROM actual_version WHERE version_id = ?", id)
+	v, err := scanActualVersion(row)
+	return v, sqliteNotFound(err)
+}
+
+func (s sqliteStorage) GetLocalVersion(ctx context.Context, id int32) (sqlc.LocalVersion, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+sqliteLocalVersionColumns+" FROM local_version WHERE version_id = ?", id)
+	v, err := scanLocalVersion(row)
+	return v, sqliteNotFound(err)
+}
+
+func (s sqliteStorage) ListActualVersions(ctx context.Context, limit int32) ([]sqlc.ActualVersion, error) {
+	return s.queryActualVersions(ctx, "SELECT "+sqliteActualVersionColumns+" FROM actual_version ORDER BY version_id DESC LIMIT ?", limit)
+}
+
+func (s sqliteStorage) ListLocalVersions(ctx context.Context, limit int32) ([]sqlc.LocalVersion, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+sqliteLocalVersionColumns+" FROM local_version ORDER BY version_id DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []sqlc.LocalVersion
+	for rows.Next() {
+		v, err := scanLocalVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+	}
+	return items, rows.Err()
+}
+
+func (s sqliteStorage) GetResumableActualVersion(ctx context.Context) (sqlc.ActualVersion, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+sqliteActualVersionColumns+`
+FROM actual_version
+WHERE status IN ('aborted', 'failed')
+	AND version_id = (SELECT max(version_id) FROM actual_version)`)
+	v, err := scanActualVersion(row)
+	return v, sqliteNotFound(err)
+}
+
+func (s sqliteStorage) UpdateActualVersionProgress(ctx context.Context, id int32, lastArtist string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE actual_version SET last_artist = ? WHERE version_id = ?", lastArtist, id)
+	return err
+}
+
+func (s sqliteStorage) SetVersionStatus(ctx context.Context, kind VersionKind, id int32, status VersionStatus) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE "+versionTable(kind)+" SET status = ? WHERE version_id = ?", string(status), id)
+	return err
+}
+
+func (s sqliteStorage) UnpublishVersion(ctx context.Context, kind VersionKind, id int32) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE "+versionTable(kind)+" SET status = 'unpublished', pinned = false WHERE version_id = ?", id)
+	return err
+}
+
+func (s sqliteStorage) PinVersion(ctx context.Context, kind VersionKind, id int32) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE "+versionTable(kind)+" SET pinned = (version_id = ?)", id)
+	return err
+}
+
+func (s sqliteStorage) UnpinVersions(ctx context.Context, kind VersionKind) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE "+versionTable(kind)+" SET pinned = false WHERE pinned")
+	return err
+}
+
+func (s sqliteStorage) GetAbandonedVersions(ctx context.Context, kind VersionKind) ([]int32, error) {
+	table := versionTable(kind)
+	return queryColumn[int32](ctx, s.db, `SELECT version_id
+FROM `+table+`
+WHERE status IN ('failed', 'aborted')
+	OR (
+		status = 'building'
+		AND version_id < (
+			SELECT coalesce(max(version_id), 0)
+			FROM `+table+`
+			WHERE status = 'published'
+		)
+	)`)
+}
+
+func (s sqliteStorage) DeleteVersion(ctx context.Context, kind VersionKind, id int32) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+albumTable(kind)+" WHERE version_id = ?", id); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "DELETE FROM "+versionTable(kind)+" WHERE version_id = ?", id)
+		return err
+	})
+}
+
+func (s sqliteStorage) CountAlbums(ctx context.Context, kind VersionKind, id int32) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, "SELECT count(*) FROM "+albumTable(kind)+" WHERE version_id = ?", id).Scan(&count)
+	return count, err
+}
+
+func (s sqliteStorage) CountPublishedAlbums(ctx context.Context, kind VersionKind) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, "SELECT count(*) FROM "+albumTable(kind)+"_published").Scan(&count)
+	return count, err
+}
+
+const (
+	sqliteInsertLocalAlbum  = "INSERT INTO local_album (artist, name, version_id, tracks) VALUES (?, ?, ?, ?)"
+	sqliteInsertActualAlbum = "INSERT INTO actual_album (id, artist, name, year, kind, version_id, url, release_group, tracks, credit, credited) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+)
+
+func (s sqliteStorage) WriteLocalAlbums(ctx context.Context, albums []sqlc.LocalAlbum) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		stmt, err := tx.PrepareContext(ctx, sqliteInsertLocalAlbum)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, album := range albums {
+			if _, err := stmt.ExecContext(ctx, album.Artist, album.Name, album.VersionID, album.Tracks); err != nil {
+				return fmt.Errorf("error inserting local album: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s sqliteStorage) WriteActualAlbums(ctx context.Context, id int32, lastArtist string, albums []sqlc.ActualAlbum) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		stmt, err := tx.PrepareContext(ctx, sqliteInsertActualAlbum)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, album := range albums {
+			_, err := stmt.ExecContext(ctx, album.ID, album.Artist, album.Name, album.Year, album.Kind, id, album.Url,
+				album.ReleaseGroup, album.Tracks, album.Credit, sqliteStrings(album.Credited))
+			if err != nil {
+				return fmt.Errorf("error inserting actual album: %w", err)
+			}
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE actual_version SET last_artist = ? WHERE version_id = ?", lastArtist, id)
+		return err
+	})
+}
+
+func (s sqliteStorage) GetActualAlbumIDs(ctx context.Context, id int32) ([]string, error) {
+	return queryColumn[string](ctx, s.db, "SELECT id FROM actual_album WHERE version_id = ?", id)
+}
+
+func (s sqliteStorage) GetLocalAlbums(ctx context.Context) ([]sqlc.LocalAlbumPublished, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT artist, name, version_id, tracks FROM local_album_published")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []sqlc.LocalAlbumPublished
+	for rows.Next() {
+		var i sqlc.LocalAlbumPublished
+		if err := rows.Scan(&i.Artist, &i.Name, &i.VersionID, &i.Tracks); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	return items, rows.Err()
+}
+
+func (s sqliteStorage) GetActualAlbums(ctx context.Context) ([]sqlc.ActualAlbumPublished, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, artist, name, year, kind, version_id, url, release_group, tracks, credit, credited FROM actual_album_published")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []sqlc.ActualAlbumPublished
+	for rows.Next() {
+		var i sqlc.ActualAlbumPublished
+		if err := rows.Scan(&i.ID, &i.Artist, &i.Name, &i.Year, &i.Kind, &i.VersionID, &i.Url, &i.ReleaseGroup, &i.Tracks, &i.Credit,
+			(*sqliteStrings)(&i.Credited)); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	return items, rows.Err()
+}
+
+func (s sqliteStorage) GetLocalArtists(ctx context.Context) ([]string, error) {
+	return queryColumn[string](ctx, s.db, "SELECT DISTINCT artist FROM local_album_published")
+}
+
+func (s sqliteStorage) GetRecentArtists(ctx context.Context, sinceYear int32) ([]string, error) {
+	return queryColumn[string](ctx, s.db, "SELECT DISTINCT artist FROM actual_album_published WHERE year >= ? AND artist IS NOT NULL", sinceYear)
+}
+
+func (s sqliteStorage) GetExcludedArtists(ctx context.Context) ([]string, error) {
+	return queryColumn[string](ctx, s.db, "SELECT artist FROM excluded_artist")
+}
+
+func (s sqliteStorage) GetExcludedAlbums(ctx context.Context) ([]sqlc.ExcludedAlbum, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT artist, album FROM excluded_album")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []sqlc.ExcludedAlbum
+	for rows.Next() {
+		var i sqlc.ExcludedAlbum
+		if err := rows.Scan(&i.Artist, &i.Album); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	return items, rows.Err()
+}
+
+func (s sqliteStorage) GetArtistAliases(ctx context.Context) ([]sqlc.ArtistAlias, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT artist, alias FROM artist_alias")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []sqlc.ArtistAlias
+	for rows.Next() {
+		var i sqlc.ArtistAlias
+		if err := rows.Scan(&i.Artist, &i.Alias); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	return items, rows.Err()
+}
+
+func (s sqliteStorage) ReplaceArtistAliases(ctx context.Context, artist string, aliases []string) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM artist_alias WHERE artist = ?", artist); err != nil {
+			return err
+		}
+		for _, alias := range aliases {
+			_, err := tx.ExecContext(ctx, "INSERT INTO artist_alias (artist, alias) VALUES (?, ?) ON CONFLICT DO NOTHING",
+				artist, alias)
+			if err != nil {
+				return fmt.Errorf("error inserting alias %s of %s: %w", alias, artist, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s sqliteStorage) GetMatchOverrides(ctx context.Context) ([]sqlc.MatchOverride, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT local_artist, local_album, actual_id FROM match_override")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []sqlc.MatchOverride
+	for rows.Next() {
+		var i sqlc.MatchOverride
+		if err := rows.Scan(&i.LocalArtist, &i.LocalAlbum, &i.ActualID); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	return items, rows.Err()
+}
+
+func (s sqliteStorage) SetMatchOverride(ctx context.Context, override sqlc.MatchOverride) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO match_override (local_artist, local_album, actual_id)
+VALUES (?, ?, ?) ON CONFLICT (local_artist, local_album) DO
+UPDATE
+SET actual_id = excluded.actual_id`, override.LocalArtist, override.LocalAlbum, override.ActualID)
+	return err
+}
+
+func (s sqliteStorage) DeleteMatchOverride(ctx context.Context, artist string, album string) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM match_override WHERE local_artist = ? AND local_album = ?",
+		artist, album)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s sqliteStorage) GetReleaseStates(ctx context.Context) ([]sqlc.ReleaseState, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT actual_id, state, until FROM release_state")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []sqlc.ReleaseState
+	for rows.Next() {
+		var i sqlc.ReleaseState
+		var until sql.NullTime
+		if err := rows.Scan(&i.ActualID, &i.State, &until); err != nil {
+			return nil, err
+		}
+		i.Until = pgtype.Date{Time: until.Time, Valid: until.Valid}
+		items = append(items, i)
+	}
+	return items, rows.Err()
+}
+
+func (s sqliteStorage) SetReleaseState(ctx context.Context, state sqlc.ReleaseState) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO release_state (actual_id, state, until)
+VALUES (?, ?, ?) ON CONFLICT (actual_id) DO
+UPDATE
+SET state = excluded.state,
+	until = excluded.until`, state.ActualID, state.State, sqliteDate(state.Until))
+	return err
+}
+
+func (s sqliteStorage) DeleteReleaseState(ctx context.Context, actualID string) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM release_state WHERE actual_id = ?", actualID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s sqliteStorage) GetArtistSettings(ctx context.Context) ([]sqlc.ArtistSetting, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT artist, notification, since, collaborations
+FROM artist_setting
+ORDER BY artist`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []sqlc.ArtistSetting
+	for rows.Next() {
+		var i sqlc.ArtistSetting
+		var since sql.NullTime
+		if err := rows.Scan(&i.Artist, &i.Notification, &since, &i.Collaborations); err != nil {
+			return nil, err
+		}
+		i.Since = pgtype.Date{Time: since.Time, Valid: since.Valid}
+		items = append(items, i)
+	}
+	return items, rows.Err()
+}
+
+const sqliteUpsertArtistSetting = `INSERT INTO artist_setting (artist, notification, since, collaborations)
+VALUES (?, ?, ?, ?) ON CONFLICT (artist) DO
+UPDATE
+SET notification = excluded.notification,
+	since = excluded.since,
+	collaborations = excluded.collaborations`
+
+func (s sqliteStorage) SetArtistSetting(ctx context.Context, setting sqlc.ArtistSetting) error {
+	_, err := s.db.ExecContext(ctx, sqliteUpsertArtistSetting, setting.Artist, setting.Notification,
+		sqliteDate(setting.Since), setting.Collaborations)
+	return err
+}
+
+func (s sqliteStorage) DeleteArtistSetting(ctx context.Context, artist string) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM artist_setting WHERE artist = ?", artist)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s sqliteStorage) ReplaceArtistSettings(ctx context.Context, settings []sqlc.ArtistSetting) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		existing, err := queryColumn[string](ctx, tx, "SELECT artist FROM artist_setting")
+		if err != nil {
+			return err
+		}
+		listed := make(map[string]bool, len(settings))
+		for _, setting := range settings {
+			listed[setting.Artist] = true
+			_, err := tx.ExecContext(ctx, sqliteUpsertArtistSetting, setting.Artist, setting.Notification,
+				sqliteDate(setting.Since), setting.Collaborations)
+			if err != nil {
+				return fmt.Errorf("error updating setting of %s: %w", setting.Artist, err)
+			}
+		}
+		for _, artist := range existing {
+			if listed[artist] {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, "DELETE FROM artist_setting WHERE artist = ?", artist); err != nil {
+				return fmt.Errorf("error deleting setting of %s: %w", artist, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s sqliteStorage) GetCache(ctx context.Context, entity string, id string) (CacheEntry, error) {
+	var entry CacheEntry
+	var ts sql.NullTime
+	err := s.db.QueryRowContext(ctx, "SELECT value, negative, ts FROM cache WHERE entity = ? AND id = ?",
+		entity, id).Scan(&entry.Value, &entry.Negative, &ts)
+	entry.Ts = ts.Time
+	return entry, sqliteNotFound(err)
+}
+
+func (s sqliteStorage) GetAllCache(ctx context.Context, entity string, since time.Time) (map[string][]byte, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT value, id FROM cache WHERE entity = ? AND ts >= ? AND NOT negative",
+		entity, since.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make(map[string][]byte)
+	for rows.Next() {
+		var value []byte
+		var id string
+		if err := rows.Scan(&value, &id); err != nil {
+			return nil, err
+		}
+		result[id] = value
+	}
+	return result, rows.Err()
+}
+
+func (s sqliteStorage) InsertCache(ctx context.Context, entity string, id string, entry CacheEntry) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO cache (entity, id, value, negative)
+VALUES (?, ?, ?, ?) ON CONFLICT (entity, id) DO
+UPDATE
+SET value = excluded.value,
+	negative = excluded.negative,
+	ts = CURRENT_TIMESTAMP`, entity, id, entry.Value, entry.Negative)
+	return err
+}
+
+func (s sqliteStorage) GetStaleCache(ctx context.Context, entity string, before time.Time, limit int32) ([]CacheKey, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, ts FROM cache WHERE entity = ? AND ts < ? AND NOT negative ORDER BY ts LIMIT ?",
+		entity, before.UTC().Format(sqliteTimeFormat), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []CacheKey
+	for rows.Next() {
+		key := CacheKey{Entity: entity}
+		if err := rows.Scan(&key.ID, &key.Ts); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s sqliteStorage) ListCache(ctx context.Context) ([]CacheKey, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT entity, id, ts, negative FROM cache ORDER BY entity, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []CacheKey
+	for rows.Next() {
+		var key CacheKey
+		var ts sql.NullTime
+		if err := rows.Scan(&key.Entity, &key.ID, &ts, &key.Negative); err != nil {
+			return nil, err
+		}
+		key.Ts = ts.Time
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s sqliteStorage) GetCacheRecords(ctx context.Context, entity string) ([]CacheRecord, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT entity, id, value, ts, negative FROM cache WHERE entity = ? ORDER BY id", entity)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []CacheRecord
+	for rows.Next() {
+		var record CacheRecord
+		var ts sql.NullTime
+		if err := rows.Scan(&record.Entity, &record.ID, &record.Value, &ts, &record.Negative); err != nil {
+			return nil, err
+		}
+		record.Ts = ts.Time
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (s sqliteStorage) DeleteCache(ctx context.Context, entity string, id string, prefix bool) (int64, error) {
+	query := "DELETE FROM cache WHERE entity = ? AND id = ?"
+	if prefix {
+		query = "DELETE FROM cache WHERE entity = ?1 AND substr(id, 1, length(?2)) = ?2"
+	}
+	result, err := s.db.ExecContext(ctx, query, entity, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s sqliteStorage) ImportCache(ctx context.Context, records []CacheRecord) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		stmt, err := tx.PrepareContext(ctx, `INSERT INTO cache (entity, id, value, negative, ts)
+VALUES (?, ?, ?, ?, ?) ON CONFLICT (entity, id) DO
+UPDATE
+SET value = excluded.value,
+	negative = excluded.negative,
+	ts = excluded.ts
+WHERE cache.ts IS NULL
+	OR cache.ts < excluded.ts`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, record := range records {
+			_, err := stmt.ExecContext(ctx, record.Entity, record.ID, record.Value, record.Negative,
+				record.Ts.UTC().Format(sqliteTimeFormat))
+			if err != nil {
+				return fmt.Errorf("error importing %s %s: %w", record.Entity, record.ID, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s sqliteStorage) migrationsDir() string {
+	return "migrations/sqlite"
+}
+
+func (s sqliteStorage) ensureMigrationsTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version integer NOT NULL PRIMARY KEY,
+	name varchar NOT NULL,
+	applied_at timestamp DEFAULT CURRENT_TIMESTAMP NOT NULL
+)`)
+	return err
+}
+
+func (s sqliteStorage) appliedMigrations(ctx context.Context) (map[int]time.Time, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+func (s sqliteStorage) applyMigration(ctx context.Context, migration Migration) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, migration.SQL); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES (?, ?)",
+			migration.Version, migration.Name)
+		return err
+	})
+}
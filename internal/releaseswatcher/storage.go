@@ -0,0 +1,124 @@
+package releaseswatcher
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/pochemuto/releases-watcher/sqlc"
+)
+
+// ErrNotFound is returned by Storage when a requested row doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+const sqlitePrefix = "sqlite:"
+
+// CacheEntry is a cached value, a negative entry has no value and means
+// the lookup found nothing.
+type CacheEntry struct {
+	Value    []byte
+	Negative bool
+	Ts       time.Time
+}
+
+// CacheKey identifies a cache entry and the time it was stored.
+type CacheKey struct {
+	Entity   string
+	ID       string
+	Ts       time.Time
+	Negative bool
+}
+
+// CacheRecord is a cache entry together with its key.
+type CacheRecord struct {
+	CacheKey
+	Value []byte
+}
+
+// Storage is a database backend behind DB, Cache and Migrator.
+// Methods that take a VersionKind work on actual or local versions.
+type Storage interface {
+	CreateActualVersion(ctx context.Context) (sqlc.ActualVersion, error)
+	CreateLocalVersion(ctx context.Context) (sqlc.LocalVersion, error)
+	GetActualVersion(ctx context.Context, id int32) (sqlc.ActualVersion, error)
+	GetLocalVersion(ctx context.Context, id int32) (sqlc.LocalVersion, error)
+	ListActualVersions(ctx context.Context, limit int32) ([]sqlc.ActualVersion, error)
+	ListLocalVersions(ctx context.Context, limit int32) ([]sqlc.LocalVersion, error)
+	GetResumableActualVersion(ctx context.Context) (sqlc.ActualVersion, error)
+	UpdateActualVersionProgress(ctx context.Context, id int32, lastArtist string) error
+	SetVersionStatus(ctx context.Context, kind VersionKind, id int32, status VersionStatus) error
+	UnpublishVersion(ctx context.Context, kind VersionKind, id int32) error
+	PinVersion(ctx context.Context, kind VersionKind, id int32) error
+	UnpinVersions(ctx context.Context, kind VersionKind) error
+	GetAbandonedVersions(ctx context.Context, kind VersionKind) ([]int32, error)
+	// DeleteVersion removes the version together with its albums
+	DeleteVersion(ctx context.Context, kind VersionKind, id int32) error
+	CountAlbums(ctx context.Context, kind VersionKind, id int32) (int64, error)
+	CountPublishedAlbums(ctx context.Context, kind VersionKind) (int64, error)
+
+	// WriteLocalAlbums writes all albums in a single transaction
+	WriteLocalAlbums(ctx context.Context, albums []sqlc.LocalAlbum) error
+	// WriteActualAlbums writes albums and the progress of the version in a single transaction
+	WriteActualAlbums(ctx context.Context, id int32, lastArtist string, albums []sqlc.ActualAlbum) error
+	GetActualAlbumIDs(ctx context.Context, id int32) ([]string, error)
+	GetLocalAlbums(ctx context.Context) ([]sqlc.LocalAlbumPublished, error)
+	GetActualAlbums(ctx context.Context) ([]sqlc.ActualAlbumPublished, error)
+	GetLocalArtists(ctx context.Context) ([]string, error)
+	// GetRecentArtists returns artists of the published actual version with releases since the year
+	GetRecentArtists(ctx context.Context, sinceYear int32) ([]string, error)
+	GetExcludedArtists(ctx context.Context) ([]string, error)
+	GetExcludedAlbums(ctx context.Context) ([]sqlc.ExcludedAlbum, error)
+	GetArtistAliases(ctx context.Context) ([]sqlc.ArtistAlias, error)
+	// ReplaceArtistAliases replaces all aliases of the artist in a single transaction
+	ReplaceArtistAliases(ctx context.Context, artist string, aliases []string) error
+	GetMatchOverrides(ctx context.Context) ([]sqlc.MatchOverride, error)
+	// SetMatchOverride inserts the override or replaces the release of the local album
+	SetMatchOverride(ctx context.Context, override sqlc.MatchOverride) error
+	DeleteMatchOverride(ctx context.Context, artist string, album string) (int64, error)
+	GetReleaseStates(ctx context.Context) ([]sqlc.ReleaseState, error)
+	// SetReleaseState inserts the state of the release or replaces it
+	SetReleaseState(ctx context.Context, state sqlc.ReleaseState) error
+	DeleteReleaseState(ctx context.Context, actualID string) (int64, error)
+	GetArtistSettings(ctx context.Context) ([]sqlc.ArtistSetting, error)
+	// SetArtistSetting inserts the setting of the artist or replaces it
+	SetArtistSetting(ctx context.Context, setting sqlc.ArtistSetting) error
+	DeleteArtistSetting(ctx context.Context, artist string) (int64, error)
+	// ReplaceArtistSettings writes the settings and deletes the ones of other artists in a single transaction
+	ReplaceArtistSettings(ctx context.Context, settings []sqlc.ArtistSetting) error
+
+	// GetCache returns the stored entry regardless of its age
+	GetCache(ctx context.Context, entity string, id string) (CacheEntry, error)
+	// GetAllCache returns positive values of the entity stored not earlier than since
+	GetAllCache(ctx context.Context, entity string, since time.Time) (map[string][]byte, error)
+	InsertCache(ctx context.Context, entity string, id string, entry CacheEntry) error
+	// GetStaleCache returns up to limit positive entries stored before the time, oldest first
+	GetStaleCache(ctx context.Context, entity string, before time.Time, limit int32) ([]CacheKey, error)
+	// ListCache returns keys of all entries without values
+	ListCache(ctx context.Context) ([]CacheKey, error)
+	GetCacheRecords(ctx context.Context, entity string) ([]CacheRecord, error)
+	// DeleteCache removes the entry with the id, or all entries with ids starting with it when prefix is set
+	DeleteCache(ctx context.Context, entity string, id string, prefix bool) (int64, error)
+	// ImportCache writes records in a single transaction keeping their time, newer entries are kept
+	ImportCache(ctx context.Context, records []CacheRecord) error
+
+	migrationsDir() string
+	ensureMigrationsTable(ctx context.Context) error
+	appliedMigrations(ctx context.Context) (map[int]time.Time, error)
+	applyMigration(ctx context.Context, migration Migration) error
+
+	Close()
+}
+
+// NewStorage connects to SQLite when the connection string starts with "sqlite:",
+// e.g. "sqlite:/var/lib/releases-watcher.db", and to Postgres otherwise.
+func NewStorage(ctx context.Context, config DbConfig) (Storage, error) {
+	if path, ok := strings.CutPrefix(config.ConnectionString, sqlitePrefix); ok {
+		return NewSQLiteStorage(ctx, path)
+	}
+	pool, err := NewPgxPool(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	return NewPostgresStorage(pool), nil
+}
@@ -2,6 +2,7 @@ package releaseswatcher
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/pochemuto/releases-watcher/sqlc"
 )
@@ -9,27 +10,57 @@ import (
 type Kind int
 
 const (
-	KindUnknown = iota
+	KindUnknown Kind = iota
 	KindAlbum
 	KindEP
 	KindSingle
+	KindBroadcast
+	KindOther
+	KindLive
+	KindCompilation
 )
 
 var kindName = map[Kind]string{
-	KindAlbum:  "Album",
-	KindEP:     "EP",
-	KindSingle: "Single",
+	KindUnknown:     "Unknown",
+	KindAlbum:       "Album",
+	KindEP:          "EP",
+	KindSingle:      "Single",
+	KindBroadcast:   "Broadcast",
+	KindOther:       "Other",
+	KindLive:        "Live",
+	KindCompilation: "Compilation",
+}
+
+// kindAliases are other spellings of kinds used by the libraries, in lower case
+var kindAliases = map[string]Kind{
+	"lp":          KindAlbum,
+	"mini-album":  KindEP,
+	"maxi":        KindSingle,
+	"maxi-single": KindSingle,
 }
 
 func (k Kind) String() string {
 	return kindName[k]
 }
 
-func KindOf(published *sqlc.ActualAlbumPublished) (Kind, error) {
+// ParseKind parses the name of the kind in any case, unknown names are KindUnknown.
+func ParseKind(name string) (Kind, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
 	for k, v := range kindName {
-		if v == *published.Kind {
-			return k, nil
+		if strings.ToLower(v) == name {
+			return k, true
 		}
 	}
+	kind, ok := kindAliases[name]
+	return kind, ok
+}
+
+func KindOf(published *sqlc.ActualAlbumPublished) (Kind, error) {
+	if published.Kind == nil {
+		return KindUnknown, fmt.Errorf("no kind")
+	}
+	if kind, ok := ParseKind(*published.Kind); ok {
+		return kind, nil
+	}
 	return KindUnknown, fmt.Errorf("unknown kind %v", *published.Kind)
 }
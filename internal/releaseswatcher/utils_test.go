@@ -0,0 +1,36 @@
+package releaseswatcher
+
+import (
+	"testing"
+
+	"github.com/pochemuto/releases-watcher/sqlc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKindOf(t *testing.T) {
+	kindOf := func(name string) Kind {
+		kind, _ := KindOf(&sqlc.ActualAlbumPublished{Kind: &name})
+		return kind
+	}
+	assert.Equal(t, KindAlbum, kindOf("Album"))
+	assert.Equal(t, KindAlbum, kindOf("album"), "Discogs kinds are lower case")
+	assert.Equal(t, KindSingle, kindOf("single"))
+	assert.Equal(t, KindEP, kindOf("ep"))
+	assert.Equal(t, KindAlbum, kindOf("LP"))
+	assert.Equal(t, KindBroadcast, kindOf("Broadcast"))
+	assert.Equal(t, KindUnknown, kindOf("Audiobook"))
+	_, err := KindOf(&sqlc.ActualAlbumPublished{})
+	assert.Error(t, err)
+
+	assert.Equal(t, KindLive, musicBrainzKind("Album", []string{"Live"}))
+	assert.Equal(t, KindCompilation, musicBrainzKind("Album", []string{"Compilation"}))
+	assert.Equal(t, KindOther, musicBrainzKind("Other", nil))
+	assert.Equal(t, KindBroadcast, musicBrainzKind("Broadcast", nil))
+	assert.True(t, isWantedReleaseGroup([]string{"Live"}), "live recordings are left to the notification setting")
+	assert.True(t, isWantedReleaseGroup([]string{"Compilation"}))
+	assert.False(t, isWantedReleaseGroup([]string{"Remix"}))
+
+	assert.True(t, NotificationAllReleases.IsReleaseInScope(KindLive))
+	assert.False(t, NotificationAlbumsAndEP.IsReleaseInScope(KindCompilation))
+	assert.False(t, NotificationAlbumsOnly.IsReleaseInScope(KindUnknown))
+}
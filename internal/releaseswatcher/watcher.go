@@ -2,7 +2,9 @@ package releaseswatcher
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -27,34 +29,116 @@ func init() {
 type WatcherConfig struct {
 	RootPath     string
 	ExcludedPath string `envDefault:""`
+	// MaxShrinkPercent stops a new version from being published automatically
+	// when it has that much fewer albums than the current one. 0 disables the check.
+	MaxShrinkPercent uint `envDefault:"20"`
 }
 
+var ErrVersionShrunk = errors.New("version shrank too much")
+
 type Library interface {
 	GetActualAlbumsForArtists(ctx context.Context, artists []string, out chan<- sqlc.ActualAlbum)
 	Name() string
 }
 
+// ErrNoAliases is returned by an AliasLibrary whose source doesn't know aliases,
+// the stored aliases are kept then.
+var ErrNoAliases = errors.New("aliases are not supported")
+
+// AliasLibrary is implemented by libraries which know other names of artists.
+type AliasLibrary interface {
+	// ArtistAliases returns names of the artist in the library, including the primary one
+	ArtistAliases(ctx context.Context, artist string) ([]string, error)
+}
+
 type Watcher struct {
-	db           DB
-	lib          Library
-	root         string
-	excludedPath string
+	db               DB
+	lib              Library
+	root             string
+	excludedPath     string
+	maxShrinkPercent uint
 }
 
 func NewWatcher(config WatcherConfig, db DB, lib Library) (Watcher, error) {
-	return Watcher{root: config.RootPath, excludedPath: config.ExcludedPath, db: db, lib: lib}, nil
+	return Watcher{
+		root:             config.RootPath,
+		excludedPath:     config.ExcludedPath,
+		maxShrinkPercent: config.MaxShrinkPercent,
+		db:               db,
+		lib:              lib,
+	}, nil
 }
 
 func (w Watcher) UpdateActualLibrary(ctx context.Context) error {
+	artists, err := w.actualArtists(ctx)
+	if err != nil {
+		return err
+	}
+
+	version, err := w.db.CreateActualVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("error creating new version: %w", err)
+	}
+	return w.fillActualVersion(ctx, version, artists)
+}
+
+// DryRunActualLibrary fetches albums of the actual library like UpdateActualLibrary,
+// but only reports them instead of writing a version.
+func (w Watcher) DryRunActualLibrary(ctx context.Context) (int, error) {
+	artists, err := w.actualArtists(ctx)
+	if err != nil {
+		return 0, err
+	}
+	log.Infof("Dry run of actual library from %s for %d artists", w.lib.Name(), len(artists))
+	actualAlbums := make(chan sqlc.ActualAlbum, 100)
+	go w.lib.GetActualAlbumsForArtists(ctx, artists, actualAlbums)
+	count := 0
+	for album := range actualAlbums {
+		count++
+		log.Debugf("Actual album: [%d] %s - %s (%s)", *album.Year, *album.Artist, *album.Name, *album.Kind)
+	}
+	return count, ctx.Err()
+}
+
+// ResumeActualLibrary continues the latest aborted or failed actual version
+// from the artist following the last completely written one.
+func (w Watcher) ResumeActualLibrary(ctx context.Context) error {
+	version, err := w.db.GetResumableActualVersion(ctx)
+	if err != nil {
+		return err
+	}
+	artists, err := w.actualArtists(ctx)
+	if err != nil {
+		return err
+	}
+	if version.LastArtist != nil {
+		remaining := artists[:0]
+		for _, artist := range artists {
+			if artist > *version.LastArtist {
+				remaining = append(remaining, artist)
+			}
+		}
+		log.Infof("Resuming actual version %d after '%s', %d of %d artists left",
+			version.VersionID, *version.LastArtist, len(remaining), len(artists))
+		artists = remaining
+	}
+	err = w.db.SetActualVersionStatus(ctx, version, VersionBuilding)
+	if err != nil {
+		return fmt.Errorf("error resuming version %d: %w", version.VersionID, err)
+	}
+	return w.fillActualVersion(ctx, version, artists)
+}
+
+// actualArtists returns sorted local artists without the excluded ones.
+func (w Watcher) actualArtists(ctx context.Context) ([]string, error) {
 	artists, err := w.db.GetLocalArtists(ctx)
-	log.Infof("Updating local library from %s for %d artists", w.lib.Name(), len(artists))
 	if err != nil {
-		return fmt.Errorf("error loading local artists: %w", err)
+		return nil, fmt.Errorf("error loading local artists: %w", err)
 	}
 
 	excludedArtists, err := w.db.GetExcludedArtists(ctx)
 	if err != nil {
-		return fmt.Errorf("error loading excluded artists: %w", err)
+		return nil, fmt.Errorf("error loading excluded artists: %w", err)
 	}
 
 	// Filter out excluded artists
@@ -64,41 +148,131 @@ func (w Watcher) UpdateActualLibrary(ctx context.Context) error {
 			filteredArtists = append(filteredArtists, artist)
 		}
 	}
+	// Stable order is required to resume from the last completed artist
+	sort.Strings(filteredArtists)
+	return filteredArtists, nil
+}
 
-	version, err := w.db.CreateActualVersion(ctx)
+func (w Watcher) fillActualVersion(ctx context.Context, version sqlc.ActualVersion, artists []string) error {
+	log.Infof("Updating actual library from %s for %d artists", w.lib.Name(), len(artists))
+	// the same release can be credited to several artists, but COPY doesn't skip duplicates
+	written, err := w.db.GetActualAlbumIDs(ctx, version)
 	if err != nil {
-		return fmt.Errorf("error creating new version: %w", err)
+		return w.failActualVersion(ctx, version, fmt.Errorf("error loading written albums: %w", err))
 	}
-	actualAlbums := make(chan sqlc.ActualAlbum, 100)
-	go w.lib.GetActualAlbumsForArtists(ctx, filteredArtists, actualAlbums)
+	seen := make(map[string]bool, len(written))
+	for _, id := range written {
+		seen[id] = true
+	}
+
+	var batch []sqlc.ActualAlbum
+	lastArtist := ""
 	count := 0
-	for actualAlbum := range actualAlbums {
-		select {
-		case <-ctx.Done():
-			log.Infof("Context is done, stopping inserting actual albums")
+	flush := func(ctx context.Context) error {
+		if lastArtist == "" {
 			return nil
-		default:
 		}
-		actualAlbum.VersionID = version.VersionID
-		err := w.db.InsertActualAlbum(ctx, actualAlbum)
-		if err != nil {
-			return fmt.Errorf("error inserting actual album: %w", err)
+		if err := w.db.WriteActualAlbums(ctx, version, lastArtist, batch); err != nil {
+			return err
 		}
-		count++
-		if count%100 == 0 {
-			log.Infof("Inserted %d actual albums", count)
+		count += len(batch)
+		log.Infof("Inserted %d actual albums", count)
+		batch, lastArtist = batch[:0], ""
+		return nil
+	}
+
+	for _, artist := range artists {
+		actualAlbums := make(chan sqlc.ActualAlbum, 100)
+		go w.lib.GetActualAlbumsForArtists(ctx, []string{artist}, actualAlbums)
+		var artistAlbums []sqlc.ActualAlbum
+		for actualAlbum := range actualAlbums {
+			if seen[actualAlbum.ID] {
+				continue
+			}
+			seen[actualAlbum.ID] = true
+			artistAlbums = append(artistAlbums, actualAlbum)
 		}
+		// The library stops early on cancellation, so the artist may be incomplete
+		if ctx.Err() != nil {
+			log.Infof("Context is done, stopping inserting actual albums")
+			if err := flush(context.WithoutCancel(ctx)); err != nil {
+				return w.failActualVersion(ctx, version, fmt.Errorf("error inserting actual albums: %w", err))
+			}
+			return w.abortActualVersion(ctx, version)
+		}
+		batch = append(batch, artistAlbums...)
+		lastArtist = artist
+		w.updateArtistAliases(ctx, artist)
+		if len(batch) >= copyBatchSize {
+			if err := flush(ctx); err != nil {
+				return w.failActualVersion(ctx, version, fmt.Errorf("error inserting actual albums: %w", err))
+			}
+		}
+	}
+	if err := flush(ctx); err != nil {
+		return w.failActualVersion(ctx, version, fmt.Errorf("error inserting actual albums: %w", err))
+	}
+
+	err = w.checkShrink(ctx, VersionKindActual, version.VersionID)
+	if errors.Is(err, ErrVersionShrunk) {
+		if statusErr := w.db.SetActualVersionStatus(context.WithoutCancel(ctx), version, VersionHeld); statusErr != nil {
+			log.Errorf("Error marking version %d as held: %v", version.VersionID, statusErr)
+		}
+		return err
+	}
+	if err != nil {
+		return w.failActualVersion(ctx, version, err)
 	}
 	err = w.db.PublishActualVersion(ctx, version)
 	if err != nil {
-		return fmt.Errorf("error publishing actual version: %w", err)
+		return w.failActualVersion(ctx, version, fmt.Errorf("error publishing actual version: %w", err))
 	}
 	log.Infof("Inserted total %d actual albums in version %d", count, version.VersionID)
 	return nil
 }
 
+// updateArtistAliases remembers other names of the artist, so the differ matches them.
+func (w Watcher) updateArtistAliases(ctx context.Context, artist string) {
+	lib, ok := w.lib.(AliasLibrary)
+	if !ok {
+		return
+	}
+	aliases, err := lib.ArtistAliases(ctx, artist)
+	if errors.Is(err, ErrNoAliases) {
+		return
+	}
+	if err != nil {
+		log.Warnf("Error getting aliases of %s: %v", artist, err)
+		return
+	}
+	if err := w.db.ReplaceArtistAliases(ctx, artist, aliases); err != nil {
+		log.Warnf("Error saving aliases of %s: %v", artist, err)
+	}
+}
+
+func (w Watcher) abortActualVersion(ctx context.Context, version sqlc.ActualVersion) error {
+	err := w.db.SetActualVersionStatus(context.WithoutCancel(ctx), version, VersionAborted)
+	if err != nil {
+		return fmt.Errorf("error marking version %d as aborted: %w", version.VersionID, err)
+	}
+	log.Infof("Actual version %d is aborted, continue it with -resume-actual", version.VersionID)
+	return nil
+}
+
+func (w Watcher) failActualVersion(ctx context.Context, version sqlc.ActualVersion, cause error) error {
+	err := w.db.SetActualVersionStatus(context.WithoutCancel(ctx), version, VersionFailed)
+	if err != nil {
+		log.Errorf("Error marking version %d as failed: %v", version.VersionID, err)
+	}
+	return cause
+}
+
 func (w Watcher) UpdateLocalLibrary(ctx context.Context) error {
 	log.Info("Updating local library")
+	version, err := w.db.CreateLocalVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("error creating new version: %w", err)
+	}
 	filenames := make(chan string)
 	tags := make(chan tag.Metadata)
 
@@ -131,7 +305,12 @@ func (w Watcher) UpdateLocalLibrary(ctx context.Context) error {
 					log.Warningf("Error when parsing %s: %v", err, filename)
 					continue
 				}
-				tags <- tag
+				select {
+				case tags <- tag:
+				case <-ctx.Done():
+					log.Infof("Context is done, stopping worker")
+					return
+				}
 			}
 		}()
 	}
@@ -140,17 +319,12 @@ func (w Watcher) UpdateLocalLibrary(ctx context.Context) error {
 		close(tags)
 	}()
 
-	version, err := w.db.CreateLocalVersion(ctx)
-	if err != nil {
-		return fmt.Errorf("error creating new version: %w", err)
-	}
-	albums := make(map[sqlc.LocalAlbum]bool)
+	// files per album, every file is a track
+	albums := make(map[sqlc.LocalAlbum]int32)
 	for tag := range tags {
-		select {
-		case <-ctx.Done():
-			log.Infof("Context is done, stopping inserting local albums")
-			return nil
-		default:
+		// the rest is drained until the workers stop
+		if ctx.Err() != nil {
+			continue
 		}
 		albumKey := sqlc.LocalAlbum{
 			Artist: strings.TrimSpace(tag.Artist()),
@@ -160,28 +334,77 @@ func (w Watcher) UpdateLocalLibrary(ctx context.Context) error {
 			log.Warnf("Incorrect tag %v", tag)
 			continue
 		}
-		if _, present := albums[albumKey]; present {
+		albums[albumKey]++
+		if albums[albumKey] > 1 {
 			continue
 		}
-		albums[albumKey] = true
-		album := albumKey
-		album.VersionID = version.VersionID
-		err := w.db.InsertLocalAlbum(ctx, album)
+		log.Tracef("Read %d/%d %s - %s", processedCount.Load(), filenameCount.Load(),
+			albumKey.Artist, albumKey.Name)
+	}
+
+	// Workers stop on cancellation as well, so the scan may be incomplete
+	if ctx.Err() != nil {
+		log.Infof("Context is done, stopping inserting local albums")
+		err = w.db.SetLocalVersionStatus(context.WithoutCancel(ctx), version, VersionAborted)
 		if err != nil {
-			log.Errorf("Failed to write to db: %v", err)
+			return fmt.Errorf("error marking version %d as aborted: %w", version.VersionID, err)
 		}
-		log.Tracef("Read %d/%d %s - %s", processedCount.Load(), filenameCount.Load(),
-			album.Artist, album.Name)
+		return nil
 	}
 
-	err = w.db.PublishLocalVersion(ctx, version)
+	localAlbums := make([]sqlc.LocalAlbum, 0, len(albums))
+	for album, tracks := range albums {
+		album.VersionID = version.VersionID
+		album.Tracks = &tracks
+		localAlbums = append(localAlbums, album)
+	}
+	err = w.db.WriteLocalAlbums(ctx, localAlbums)
+	if err == nil {
+		err = w.checkShrink(ctx, VersionKindLocal, version.VersionID)
+	}
+	if errors.Is(err, ErrVersionShrunk) {
+		if statusErr := w.db.SetLocalVersionStatus(context.WithoutCancel(ctx), version, VersionHeld); statusErr != nil {
+			log.Errorf("Error marking version %d as held: %v", version.VersionID, statusErr)
+		}
+		return err
+	}
+	if err == nil {
+		err = w.db.PublishLocalVersion(ctx, version)
+	}
 	if err != nil {
-		return fmt.Errorf("error publishing local version: %w", err)
+		if statusErr := w.db.SetLocalVersionStatus(context.WithoutCancel(ctx), version, VersionFailed); statusErr != nil {
+			log.Errorf("Error marking version %d as failed: %v", version.VersionID, statusErr)
+		}
+		return fmt.Errorf("error writing local version: %w", err)
 	}
 	log.Infof("Inserted total %d local albums in version %d", len(albums), version.VersionID)
 	return nil
 }
 
+// checkShrink compares the album count of a new version with the current published one.
+func (w Watcher) checkShrink(ctx context.Context, kind VersionKind, id int32) error {
+	if w.maxShrinkPercent == 0 {
+		return nil
+	}
+	current, err := w.db.CountPublishedAlbums(ctx, kind)
+	if err != nil {
+		return fmt.Errorf("error counting published %s albums: %w", kind, err)
+	}
+	count, err := w.db.CountAlbums(ctx, kind, id)
+	if err != nil {
+		return fmt.Errorf("error counting %s albums of version %d: %w", kind, id, err)
+	}
+	if current == 0 || count >= current {
+		return nil
+	}
+	shrink := uint((current - count) * 100 / current)
+	if shrink > w.maxShrinkPercent {
+		return fmt.Errorf("%w: %s version %d has %d albums, %d%% less than %d published, publish it with 'versions publish %s %d'",
+			ErrVersionShrunk, kind, id, count, shrink, current, kind, id)
+	}
+	return nil
+}
+
 func contains(slice []string, item string) bool {
 	for _, a := range slice {
 		if a == item {
@@ -0,0 +1,32 @@
+package releaseswatcher
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pochemuto/releases-watcher/sqlc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatcher_ActualArtists(t *testing.T) {
+	ctx := context.Background()
+	store := setupTestCache(t, CacheConfig{}).store
+	db, err := NewDB(store)
+	require.NoError(t, err)
+
+	version, err := db.CreateLocalVersion(ctx)
+	require.NoError(t, err)
+	var albums []sqlc.LocalAlbum
+	for _, artist := range []string{"Nick Cave", "Nick Cave & The Bad Seeds", "Earth, Wind & Fire", "Florence + the Machine", "Кино"} {
+		albums = append(albums, sqlc.LocalAlbum{Artist: artist, Name: "Album", VersionID: version.VersionID})
+	}
+	require.NoError(t, db.WriteLocalAlbums(ctx, albums))
+	require.NoError(t, db.PublishLocalVersion(ctx, version))
+	execSQL(t, store, `INSERT INTO excluded_artist (artist) VALUES ('Кино')`)
+
+	artists, err := Watcher{db: db}.actualArtists(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Earth, Wind & Fire", "Florence + the Machine", "Nick Cave", "Nick Cave & The Bad Seeds"}, artists,
+		"band names are not split into artists")
+}
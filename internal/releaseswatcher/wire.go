@@ -14,35 +14,68 @@ import (
 )
 
 type Application struct {
-	DB      DB
-	Watcher Watcher
-	Differ  Differ
-	Sheets  GoogleSheets
+	DB        DB
+	Cache     Cache
+	Scheduler CacheScheduler
+	Watcher   Watcher
+	Differ    Differ
+	Sheets    *GoogleSheets
+	Settings  ArtistSettingsStore
+	Migrator  Migrator
+	Library   Library
+	Fixtures  HTTPFixtures
 }
 
 type Config struct {
 	WatcherConfig `envDefault:""`
-	Db            DbConfig           `envPrefix:"DB_" envDefault:""`
-	Diff          DifferConfig       `envPrefix:"DIFF_" envDefault:""`
-	Discogs       DiscogsConfig      `envPrefix:"DISCOGS_" envDefault:""`
-	MusicBrainz   MusicBrainzConfig  `envPrefix:"MUSIC_BRAINZ_" envDefault:""`
-	GoogleSheets  GoogleSheetsConfig `envPrefix:"GOOGLE_SHEETS_" envDefault:""`
+	Db            DbConfig             `envPrefix:"DB_" envDefault:""`
+	Cache         CacheConfig          `envPrefix:"CACHE_" envDefault:""`
+	WarmUp        WarmUpConfig         `envPrefix:"WARMUP_" envDefault:""`
+	HTTP          HTTPConfig           `envPrefix:"HTTP_" envDefault:""`
+	Diff          DifferConfig         `envPrefix:"DIFF_" envDefault:""`
+	Discogs       DiscogsConfig        `envPrefix:"DISCOGS_" envDefault:""`
+	MusicBrainz   MusicBrainzConfig    `envPrefix:"MUSIC_BRAINZ_" envDefault:""`
+	GoogleSheets  GoogleSheetsConfig   `envPrefix:"GOOGLE_SHEETS_" envDefault:""`
+	Settings      ArtistSettingsConfig `envPrefix:"SETTINGS_" envDefault:""`
 }
 
 func NewApplication(
 	db DB,
+	cache Cache,
+	scheduler CacheScheduler,
 	watcher Watcher,
 	differ Differ,
-	sheets GoogleSheets,
+	sheets *GoogleSheets,
+	settings ArtistSettingsStore,
+	migrator Migrator,
+	library Library,
+	fixtures HTTPFixtures,
 ) Application {
 	return Application{
-		DB:      db,
-		Watcher: watcher,
-		Differ:  differ,
-		Sheets:  sheets,
+		DB:        db,
+		Cache:     cache,
+		Scheduler: scheduler,
+		Watcher:   watcher,
+		Differ:    differ,
+		Sheets:    sheets,
+		Settings:  settings,
+		Migrator:  migrator,
+		Library:   library,
+		Fixtures:  fixtures,
 	}
 }
 
+// Close releases connections of the library, stops the fixtures proxies and disconnects from the database.
+func (a Application) Close() {
+	if closer, ok := a.Library.(interface{ Close() }); ok {
+		closer.Close()
+	}
+	if err := a.Fixtures.Close(); err != nil {
+		log.Warnf("Error stopping fixtures proxies: %v", err)
+	}
+	a.DB.Disconnect()
+}
+
 func InitializeApplication(ctx context.Context) (Application, error) {
 	var config Config
 	err := env.ParseWithOptions(&config, env.Options{RequiredIfNoDef: true, UseFieldNameByDefault: true})
@@ -55,24 +88,54 @@ func InitializeApplication(ctx context.Context) (Application, error) {
 		return Application{}, fmt.Errorf("app initialization error: %w", err)
 	}
 
+	if err := app.Migrator.CheckUpToDate(ctx); err != nil {
+		return Application{}, err
+	}
+
 	return app, nil
 }
 
+// InitializeMigrator needs only the database settings, so migrations can run
+// before the rest of the application is configured.
+func InitializeMigrator(ctx context.Context) (Migrator, error) {
+	var config DbConfig
+	err := env.ParseWithOptions(&config, env.Options{Prefix: "DB_", RequiredIfNoDef: true, UseFieldNameByDefault: true})
+	if err != nil {
+		return Migrator{}, fmt.Errorf("parsing env variables error: %w", err)
+	}
+
+	return initializeMigrator(ctx, config)
+}
+
 func initializeApp(
 	ctx context.Context,
 	config Config,
 ) (Application, error) {
 	wire.Build(
 		NewDB,
-		NewMusicBrainzLibrary,
-		wire.Bind(new(Library), new(MusicBrainzLibrary)),
+		NewLibrary,
 		NewWatcher,
 		NewApplication,
 		NewCache,
-		NewPgxPool,
+		NewCacheScheduler,
+		NewHTTPFixtures,
+		NewStorage,
 		NewDiffer,
 		NewGoogleSheets,
-		wire.FieldsOf(new(Config), "Db", "Diff", "MusicBrainz", "GoogleSheets", "WatcherConfig"),
+		NewArtistSettingsStore,
+		NewMigrator,
+		wire.FieldsOf(new(Config), "Db", "Cache", "WarmUp", "HTTP", "Diff", "MusicBrainz", "GoogleSheets", "Settings", "WatcherConfig"),
 	)
 	return Application{}, nil
 }
+
+func initializeMigrator(
+	ctx context.Context,
+	config DbConfig,
+) (Migrator, error) {
+	wire.Build(
+		NewStorage,
+		NewMigrator,
+	)
+	return Migrator{}, nil
+}
@@ -16,22 +16,33 @@ import (
 
 func initializeApp(ctx context.Context, config Config) (Application, error) {
 	dbConfig := config.Db
-	pool, err := NewPgxPool(ctx, dbConfig)
+	storage, err := NewStorage(ctx, dbConfig)
 	if err != nil {
 		return Application{}, err
 	}
-	db, err := NewDB(pool)
+	db, err := NewDB(storage)
 	if err != nil {
 		return Application{}, err
 	}
 	watcherConfig := config.WatcherConfig
 	musicBrainzConfig := config.MusicBrainz
-	cache := NewCache(pool)
-	musicBrainzLibrary, err := NewMusicBrainzLibrary(musicBrainzConfig, db, cache)
+	cacheConfig := config.Cache
+	cache := NewCache(storage, cacheConfig)
+	httpConfig := config.HTTP
+	httpFixtures, err := NewHTTPFixtures(httpConfig)
 	if err != nil {
 		return Application{}, err
 	}
-	watcher, err := NewWatcher(watcherConfig, db, musicBrainzLibrary)
+	library, err := NewLibrary(ctx, musicBrainzConfig, db, cache, httpFixtures)
+	if err != nil {
+		return Application{}, err
+	}
+	warmUpConfig := config.WarmUp
+	cacheScheduler, err := NewCacheScheduler(warmUpConfig, cache, db, library)
+	if err != nil {
+		return Application{}, err
+	}
+	watcher, err := NewWatcher(watcherConfig, db, library)
 	if err != nil {
 		return Application{}, err
 	}
@@ -41,41 +52,98 @@ func initializeApp(ctx context.Context, config Config) (Application, error) {
 	if err != nil {
 		return Application{}, err
 	}
-	differ := NewDiffer(db, differConfig, googleSheets)
-	application := NewApplication(db, watcher, differ, googleSheets)
+	artistSettingsConfig := config.Settings
+	artistSettingsStore, err := NewArtistSettingsStore(artistSettingsConfig, db, googleSheets)
+	if err != nil {
+		return Application{}, err
+	}
+	differ, err := NewDiffer(db, differConfig, artistSettingsStore)
+	if err != nil {
+		return Application{}, err
+	}
+	migrator, err := NewMigrator(storage)
+	if err != nil {
+		return Application{}, err
+	}
+	application := NewApplication(db, cache, cacheScheduler, watcher, differ, googleSheets, artistSettingsStore, migrator, library, httpFixtures)
 	return application, nil
 }
 
+func initializeMigrator(ctx context.Context, config DbConfig) (Migrator, error) {
+	storage, err := NewStorage(ctx, config)
+	if err != nil {
+		return Migrator{}, err
+	}
+	migrator, err := NewMigrator(storage)
+	if err != nil {
+		return Migrator{}, err
+	}
+	return migrator, nil
+}
+
 // wire.go:
 
 type Application struct {
-	DB      DB
-	Watcher Watcher
-	Differ  Differ
-	Sheets  GoogleSheets
+	DB        DB
+	Cache     Cache
+	Scheduler CacheScheduler
+	Watcher   Watcher
+	Differ    Differ
+	Sheets    *GoogleSheets
+	Settings  ArtistSettingsStore
+	Migrator  Migrator
+	Library   Library
+	Fixtures  HTTPFixtures
 }
 
 type Config struct {
 	WatcherConfig `envDefault:""`
-	Db            DbConfig           `envPrefix:"DB_" envDefault:""`
-	Diff          DifferConfig       `envPrefix:"DIFF_" envDefault:""`
-	Discogs       DiscogsConfig      `envPrefix:"DISCOGS_" envDefault:""`
-	MusicBrainz   MusicBrainzConfig  `envPrefix:"MUSIC_BRAINZ_" envDefault:""`
-	GoogleSheets  GoogleSheetsConfig `envPrefix:"GOOGLE_SHEETS_" envDefault:""`
+	Db            DbConfig             `envPrefix:"DB_" envDefault:""`
+	Cache         CacheConfig          `envPrefix:"CACHE_" envDefault:""`
+	WarmUp        WarmUpConfig         `envPrefix:"WARMUP_" envDefault:""`
+	HTTP          HTTPConfig           `envPrefix:"HTTP_" envDefault:""`
+	Diff          DifferConfig         `envPrefix:"DIFF_" envDefault:""`
+	Discogs       DiscogsConfig        `envPrefix:"DISCOGS_" envDefault:""`
+	MusicBrainz   MusicBrainzConfig    `envPrefix:"MUSIC_BRAINZ_" envDefault:""`
+	GoogleSheets  GoogleSheetsConfig   `envPrefix:"GOOGLE_SHEETS_" envDefault:""`
+	Settings      ArtistSettingsConfig `envPrefix:"SETTINGS_" envDefault:""`
 }
 
 func NewApplication(
 	db DB,
+	cache Cache,
+	scheduler CacheScheduler,
 	watcher Watcher,
 	differ Differ,
-	sheets GoogleSheets,
+	sheets *GoogleSheets,
+	settings ArtistSettingsStore,
+	migrator Migrator,
+	library Library,
+	fixtures HTTPFixtures,
 ) Application {
 	return Application{
-		DB:      db,
-		Watcher: watcher,
-		Differ:  differ,
-		Sheets:  sheets,
+		DB:        db,
+		Cache:     cache,
+		Scheduler: scheduler,
+		Watcher:   watcher,
+		Differ:    differ,
+		Sheets:    sheets,
+		Settings:  settings,
+		Migrator:  migrator,
+		Library:   library,
+		Fixtures:  fixtures,
+	}
+}
+
+// Close releases connections of the library, stops the fixtures proxies and disconnects from the database.
+func (a Application) Close() {
+	if closer, ok := a.Library.(interface{ Close() }); ok {
+		closer.Close()
+	}
+	if err := a.Fixtures.Close(); err != nil {
+		log.Warnf("Error stopping fixtures proxies: %v", err)
 	}
+	a.DB.Disconnect()
 }
 
 func InitializeApplication(ctx context.Context) (Application, error) {
@@ -90,5 +158,21 @@ func InitializeApplication(ctx context.Context) (Application, error) {
 		return Application{}, fmt.Errorf("app initialization error: %w", err)
 	}
 
+	if err := app.Migrator.CheckUpToDate(ctx); err != nil {
+		return Application{}, err
+	}
+
 	return app, nil
 }
+
+// InitializeMigrator needs only the database settings, so migrations can run
+// before the rest of the application is configured.
+func InitializeMigrator(ctx context.Context) (Migrator, error) {
+	var config DbConfig
+	err := env.ParseWithOptions(&config, env.Options{Prefix: "DB_", RequiredIfNoDef: true, UseFieldNameByDefault: true})
+	if err != nil {
+		return Migrator{}, fmt.Errorf("parsing env variables error: %w", err)
+	}
+
+	return initializeMigrator(ctx, config)
+}
@@ -7,29 +7,41 @@ FROM actual_album_published;
 -- name: GetLocalArtists :many
 SELECT DISTINCT artist
 FROM local_album_published;
+-- name: GetRecentArtists :many
+SELECT DISTINCT artist
+FROM actual_album_published
+WHERE year >= $1
+	AND artist IS NOT NULL;
 -- name: GetAll :many
 SELECT value,
 	id
 FROM cache
-WHERE entity = $1;
--- name: InsertLocalAlbum :exec
-INSERT INTO local_album (artist, name, version_id)
-VALUES ($1, $2, $3) ON CONFLICT DO NOTHING;
--- name: InsertActualAlbum :exec
-INSERT INTO actual_album (id, artist, name, year, kind, version_id, url)
-VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT DO NOTHING;
+WHERE entity = $1
+	AND ts >= $2
+	AND NOT negative;
 -- name: GetCache :one
-SELECT value
+SELECT value,
+	negative,
+	ts
 FROM cache
 WHERE entity = $1
-	AND id = $2
-	AND ts >= $3;
+	AND id = $2;
 -- name: InsertCache :exec
-INSERT INTO cache (entity, id, value)
-VALUES ($1, $2, $3) ON CONFLICT (entity, id) DO
+INSERT INTO cache (entity, id, value, negative)
+VALUES ($1, $2, $3, $4) ON CONFLICT (entity, id) DO
 UPDATE
 SET value = EXCLUDED.value,
+	negative = EXCLUDED.negative,
 	ts = CURRENT_TIMESTAMP;
+-- name: GetStaleCache :many
+SELECT id,
+	ts
+FROM cache
+WHERE entity = $1
+	AND ts < $2
+	AND NOT negative
+ORDER BY ts
+LIMIT $3;
 -- name: GetExcludedArtists :many
 SELECT artist
 FROM excluded_artist;
@@ -37,27 +49,255 @@ FROM excluded_artist;
 SELECT artist,
 	album
 FROM excluded_album;
+-- name: GetArtistAliases :many
+SELECT artist,
+	alias
+FROM artist_alias;
+-- name: DeleteArtistAliases :exec
+DELETE FROM artist_alias
+WHERE artist = $1;
+-- name: InsertArtistAlias :exec
+INSERT INTO artist_alias (artist, alias)
+VALUES ($1, $2) ON CONFLICT DO NOTHING;
+-- name: GetMatchOverrides :many
+SELECT local_artist,
+	local_album,
+	actual_id
+FROM match_override;
+-- name: UpsertMatchOverride :exec
+INSERT INTO match_override (local_artist, local_album, actual_id)
+VALUES ($1, $2, $3) ON CONFLICT (local_artist, local_album) DO
+UPDATE
+SET actual_id = EXCLUDED.actual_id;
+-- name: DeleteMatchOverride :execrows
+DELETE FROM match_override
+WHERE local_artist = $1
+	AND local_album = $2;
+-- name: GetReleaseStates :many
+SELECT actual_id,
+	state,
+	until
+FROM release_state;
+-- name: UpsertReleaseState :exec
+INSERT INTO release_state (actual_id, state, until)
+VALUES ($1, $2, $3) ON CONFLICT (actual_id) DO
+UPDATE
+SET state = EXCLUDED.state,
+	until = EXCLUDED.until;
+-- name: DeleteReleaseState :execrows
+DELETE FROM release_state
+WHERE actual_id = $1;
+-- name: GetArtistSettings :many
+SELECT artist,
+	notification,
+	since,
+	collaborations
+FROM artist_setting
+ORDER BY artist;
+-- name: UpsertArtistSetting :exec
+INSERT INTO artist_setting (artist, notification, since, collaborations)
+VALUES ($1, $2, $3, $4) ON CONFLICT (artist) DO
+UPDATE
+SET notification = EXCLUDED.notification,
+	since = EXCLUDED.since,
+	collaborations = EXCLUDED.collaborations;
+-- name: DeleteArtistSetting :execrows
+DELETE FROM artist_setting
+WHERE artist = $1;
 -- name: CreateActualVersion :one
-INSERT INTO actual_version (published)
-VALUES (FALSE)
+INSERT INTO actual_version (status)
+VALUES ('building')
 RETURNING version_id,
 	created_at,
-	published;
+	status,
+	last_artist,
+	pinned;
 -- name: CreateLocalVersion :one
-INSERT INTO local_version (published)
-VALUES (FALSE)
+INSERT INTO local_version (status)
+VALUES ('building')
 RETURNING version_id,
 	created_at,
-	published;
+	status,
+	pinned;
 -- name: CreateActualAlbumPartition :exec
 SELECT create_actual_album_partition(@version::int);
 -- name: CreateLocalAlbumPartition :exec
 SELECT create_local_album_partition(@version::int);
 -- name: PublishActualVersion :exec
 UPDATE actual_version
-SET published = TRUE
+SET status = 'published'
 WHERE version_id = @version::int;
 -- name: PublishLocalVersion :exec
 UPDATE local_version
-SET published = TRUE
-WHERE version_id = @version::int;
\ No newline at end of file
+SET status = 'published'
+WHERE version_id = @version::int;
+-- name: SetActualVersionStatus :exec
+UPDATE actual_version
+SET status = @status
+WHERE version_id = @version::int;
+-- name: SetLocalVersionStatus :exec
+UPDATE local_version
+SET status = @status
+WHERE version_id = @version::int;
+-- name: UpdateActualVersionProgress :exec
+UPDATE actual_version
+SET last_artist = @last_artist
+WHERE version_id = @version::int;
+-- name: GetResumableActualVersion :one
+SELECT version_id,
+	created_at,
+	status,
+	last_artist,
+	pinned
+FROM actual_version
+WHERE status IN ('aborted', 'failed')
+	AND version_id = (
+		SELECT max(version_id)
+		FROM actual_version
+	);
+-- name: GetAbandonedActualVersions :many
+SELECT version_id
+FROM actual_version
+WHERE status IN ('failed', 'aborted')
+	OR (
+		status = 'building'
+		AND version_id < (
+			SELECT coalesce(max(version_id), 0)
+			FROM actual_version
+			WHERE status = 'published'
+		)
+	);
+-- name: GetAbandonedLocalVersions :many
+SELECT version_id
+FROM local_version
+WHERE status IN ('failed', 'aborted')
+	OR (
+		status = 'building'
+		AND version_id < (
+			SELECT coalesce(max(version_id), 0)
+			FROM local_version
+			WHERE status = 'published'
+		)
+	);
+-- name: DropActualAlbumPartition :exec
+SELECT drop_actual_album_partition(@version::int);
+-- name: DropLocalAlbumPartition :exec
+SELECT drop_local_album_partition(@version::int);
+-- name: DeleteActualVersion :exec
+DELETE FROM actual_version
+WHERE version_id = @version::int;
+-- name: DeleteLocalVersion :exec
+DELETE FROM local_version
+WHERE version_id = @version::int;
+-- name: GetActualVersion :one
+SELECT *
+FROM actual_version
+WHERE version_id = @version::int;
+-- name: GetLocalVersion :one
+SELECT *
+FROM local_version
+WHERE version_id = @version::int;
+-- name: ListActualVersions :many
+SELECT *
+FROM actual_version
+ORDER BY version_id DESC
+LIMIT $1;
+-- name: ListLocalVersions :many
+SELECT *
+FROM local_version
+ORDER BY version_id DESC
+LIMIT $1;
+-- name: UnpublishActualVersion :exec
+UPDATE actual_version
+SET status = 'unpublished',
+	pinned = FALSE
+WHERE version_id = @version::int;
+-- name: UnpublishLocalVersion :exec
+UPDATE local_version
+SET status = 'unpublished',
+	pinned = FALSE
+WHERE version_id = @version::int;
+-- name: PinActualVersion :exec
+UPDATE actual_version
+SET pinned = (version_id = @version::int);
+-- name: PinLocalVersion :exec
+UPDATE local_version
+SET pinned = (version_id = @version::int);
+-- name: UnpinActualVersions :exec
+UPDATE actual_version
+SET pinned = FALSE
+WHERE pinned;
+-- name: UnpinLocalVersions :exec
+UPDATE local_version
+SET pinned = FALSE
+WHERE pinned;
+-- name: CountActualAlbums :one
+SELECT count(*)
+FROM actual_album
+WHERE version_id = @version::int;
+-- name: CountLocalAlbums :one
+SELECT count(*)
+FROM local_album
+WHERE version_id = @version::int;
+-- name: CountPublishedActualAlbums :one
+SELECT count(*)
+FROM actual_album_published;
+-- name: CountPublishedLocalAlbums :one
+SELECT count(*)
+FROM local_album_published;
+-- name: CopyActualAlbums :copyfrom
+INSERT INTO actual_album (
+		id,
+		artist,
+		name,
+		year,
+		kind,
+		version_id,
+		url,
+		release_group,
+		tracks,
+		credit,
+		credited
+	)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11);
+-- name: CopyLocalAlbums :copyfrom
+INSERT INTO local_album (artist, name, version_id, tracks)
+VALUES ($1, $2, $3, $4);
+-- name: GetActualAlbumIDs :many
+SELECT id
+FROM actual_album
+WHERE version_id = @version::int;
+-- name: ListCache :many
+SELECT entity,
+	id,
+	ts,
+	negative
+FROM cache
+ORDER BY entity,
+	id;
+-- name: GetCacheRecords :many
+SELECT entity,
+	id,
+	value,
+	ts,
+	negative
+FROM cache
+WHERE entity = $1
+ORDER BY id;
+-- name: DeleteCacheEntry :execrows
+DELETE FROM cache
+WHERE entity = $1
+	AND id = $2;
+-- name: DeleteCacheByPrefix :execrows
+DELETE FROM cache
+WHERE entity = @entity
+	AND substr(id, 1, length(@prefix::varchar)) = @prefix::varchar;
+-- name: ImportCache :exec
+INSERT INTO cache (entity, id, value, negative, ts)
+VALUES ($1, $2, $3, $4, $5) ON CONFLICT (entity, id) DO
+UPDATE
+SET value = EXCLUDED.value,
+	negative = EXCLUDED.negative,
+	ts = EXCLUDED.ts
+WHERE cache.ts IS NULL
+	OR cache.ts < EXCLUDED.ts;
@@ -9,36 +9,59 @@ import (
 )
 
 type ActualAlbum struct {
-	ID        string
-	Artist    *string
-	Name      *string
-	Year      *int32
-	Kind      *string
-	VersionID int32
-	Url       *string
+	ID           string
+	Artist       *string
+	Name         *string
+	Year         *int32
+	Kind         *string
+	VersionID    int32
+	Url          *string
+	ReleaseGroup *string
+	Tracks       *int32
+	Credit       *string
+	Credited     []string
 }
 
 type ActualAlbumPublished struct {
-	ID        string
-	Artist    *string
-	Name      *string
-	Year      *int32
-	Kind      *string
-	VersionID int32
-	Url       *string
+	ID           string
+	Artist       *string
+	Name         *string
+	Year         *int32
+	Kind         *string
+	VersionID    int32
+	Url          *string
+	ReleaseGroup *string
+	Tracks       *int32
+	Credit       *string
+	Credited     []string
 }
 
 type ActualVersion struct {
-	VersionID int32
-	CreatedAt pgtype.Timestamp
-	Published bool
+	VersionID  int32
+	CreatedAt  pgtype.Timestamp
+	Status     string
+	LastArtist *string
+	Pinned     bool
+}
+
+type ArtistAlias struct {
+	Artist string
+	Alias  string
+}
+
+type ArtistSetting struct {
+	Artist         string
+	Notification   string
+	Since          pgtype.Date
+	Collaborations string
 }
 
 type Cache struct {
-	Entity string
-	ID     string
-	Value  []byte
-	Ts     pgtype.Timestamp
+	Entity   string
+	ID       string
+	Value    []byte
+	Ts       pgtype.Timestamptz
+	Negative bool
 }
 
 type ExcludedAlbum struct {
@@ -54,16 +77,31 @@ type LocalAlbum struct {
 	Artist    string
 	Name      string
 	VersionID int32
+	Tracks    *int32
 }
 
 type LocalAlbumPublished struct {
 	Artist    string
 	Name      string
 	VersionID int32
+	Tracks    *int32
 }
 
 type LocalVersion struct {
 	VersionID int32
 	CreatedAt pgtype.Timestamp
-	Published bool
+	Status    string
+	Pinned    bool
+}
+
+type MatchOverride struct {
+	LocalArtist string
+	LocalAlbum  string
+	ActualID    *string
+}
+
+type ReleaseState struct {
+	ActualID string
+	State    string
+	Until    pgtype.Date
 }
@@ -11,6 +11,77 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type CopyActualAlbumsParams struct {
+	ID           string
+	Artist       *string
+	Name         *string
+	Year         *int32
+	Kind         *string
+	VersionID    int32
+	Url          *string
+	ReleaseGroup *string
+	Tracks       *int32
+	Credit       *string
+	Credited     []string
+}
+
+type CopyLocalAlbumsParams struct {
+	Artist    string
+	Name      string
+	VersionID int32
+	Tracks    *int32
+}
+
+const countActualAlbums = `-- name: CountActualAlbums :one
+SELECT count(*)
+FROM actual_album
+WHERE version_id = $1::int
+`
+
+func (q *Queries) CountActualAlbums(ctx context.Context, version int32) (int64, error) {
+	row := q.db.QueryRow(ctx, countActualAlbums, version)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countLocalAlbums = `-- name: CountLocalAlbums :one
+SELECT count(*)
+FROM local_album
+WHERE version_id = $1::int
+`
+
+func (q *Queries) CountLocalAlbums(ctx context.Context, version int32) (int64, error) {
+	row := q.db.QueryRow(ctx, countLocalAlbums, version)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countPublishedActualAlbums = `-- name: CountPublishedActualAlbums :one
+SELECT count(*)
+FROM actual_album_published
+`
+
+func (q *Queries) CountPublishedActualAlbums(ctx context.Context) (int64, error) {
+	row := q.db.QueryRow(ctx, countPublishedActualAlbums)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countPublishedLocalAlbums = `-- name: CountPublishedLocalAlbums :one
+SELECT count(*)
+FROM local_album_published
+`
+
+func (q *Queries) CountPublishedLocalAlbums(ctx context.Context) (int64, error) {
+	row := q.db.QueryRow(ctx, countPublishedLocalAlbums)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createActualAlbumPartition = `-- name: CreateActualAlbumPartition :exec
 SELECT create_actual_album_partition($1::int)
 `
@@ -21,17 +92,25 @@ func (q *Queries) CreateActualAlbumPartition(ctx context.Context, version int32)
 }
 
 const createActualVersion = `-- name: CreateActualVersion :one
-INSERT INTO actual_version (published)
-VALUES (FALSE)
+INSERT INTO actual_version (status)
+VALUES ('building')
 RETURNING version_id,
 	created_at,
-	published
+	status,
+	last_artist,
+	pinned
 `
 
 func (q *Queries) CreateActualVersion(ctx context.Context) (ActualVersion, error) {
 	row := q.db.QueryRow(ctx, createActualVersion)
 	var i ActualVersion
-	err := row.Scan(&i.VersionID, &i.CreatedAt, &i.Published)
+	err := row.Scan(
+		&i.VersionID,
+		&i.CreatedAt,
+		&i.Status,
+		&i.LastArtist,
+		&i.Pinned,
+	)
 	return i, err
 }
 
@@ -45,22 +124,253 @@ func (q *Queries) CreateLocalAlbumPartition(ctx context.Context, version int32)
 }
 
 const createLocalVersion = `-- name: CreateLocalVersion :one
-INSERT INTO local_version (published)
-VALUES (FALSE)
+INSERT INTO local_version (status)
+VALUES ('building')
 RETURNING version_id,
 	created_at,
-	published
+	status,
+	pinned
 `
 
 func (q *Queries) CreateLocalVersion(ctx context.Context) (LocalVersion, error) {
 	row := q.db.QueryRow(ctx, createLocalVersion)
 	var i LocalVersion
-	err := row.Scan(&i.VersionID, &i.CreatedAt, &i.Published)
+	err := row.Scan(
+		&i.VersionID,
+		&i.CreatedAt,
+		&i.Status,
+		&i.Pinned,
+	)
 	return i, err
 }
 
+const deleteActualVersion = `-- name: DeleteActualVersion :exec
+DELETE FROM actual_version
+WHERE version_id = $1::int
+`
+
+func (q *Queries) DeleteActualVersion(ctx context.Context, version int32) error {
+	_, err := q.db.Exec(ctx, deleteActualVersion, version)
+	return err
+}
+
+const deleteArtistAliases = `-- name: DeleteArtistAliases :exec
+DELETE FROM artist_alias
+WHERE artist = $1
+`
+
+func (q *Queries) DeleteArtistAliases(ctx context.Context, artist string) error {
+	_, err := q.db.Exec(ctx, deleteArtistAliases, artist)
+	return err
+}
+
+const deleteArtistSetting = `-- name: DeleteArtistSetting :execrows
+DELETE FROM artist_setting
+WHERE artist = $1
+`
+
+func (q *Queries) DeleteArtistSetting(ctx context.Context, artist string) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteArtistSetting, artist)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteCacheByPrefix = `-- name: DeleteCacheByPrefix :execrows
+DELETE FROM cache
+WHERE entity = $1
+	AND substr(id, 1, length($2::varchar)) = $2::varchar
+`
+
+type DeleteCacheByPrefixParams struct {
+	Entity string
+	Prefix string
+}
+
+func (q *Queries) DeleteCacheByPrefix(ctx context.Context, arg DeleteCacheByPrefixParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteCacheByPrefix, arg.Entity, arg.Prefix)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteCacheEntry = `-- name: DeleteCacheEntry :execrows
+DELETE FROM cache
+WHERE entity = $1
+	AND id = $2
+`
+
+type DeleteCacheEntryParams struct {
+	Entity string
+	ID     string
+}
+
+func (q *Queries) DeleteCacheEntry(ctx context.Context, arg DeleteCacheEntryParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteCacheEntry, arg.Entity, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteLocalVersion = `-- name: DeleteLocalVersion :exec
+DELETE FROM local_version
+WHERE version_id = $1::int
+`
+
+func (q *Queries) DeleteLocalVersion(ctx context.Context, version int32) error {
+	_, err := q.db.Exec(ctx, deleteLocalVersion, version)
+	return err
+}
+
+const deleteMatchOverride = `-- name: DeleteMatchOverride :execrows
+DELETE FROM match_override
+WHERE local_artist = $1
+	AND local_album = $2
+`
+
+type DeleteMatchOverrideParams struct {
+	LocalArtist string
+	LocalAlbum  string
+}
+
+func (q *Queries) DeleteMatchOverride(ctx context.Context, arg DeleteMatchOverrideParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteMatchOverride, arg.LocalArtist, arg.LocalAlbum)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteReleaseState = `-- name: DeleteReleaseState :execrows
+DELETE FROM release_state
+WHERE actual_id = $1
+`
+
+func (q *Queries) DeleteReleaseState(ctx context.Context, actualID string) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteReleaseState, actualID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const dropActualAlbumPartition = `-- name: DropActualAlbumPartition :exec
+SELECT drop_actual_album_partition($1::int)
+`
+
+func (q *Queries) DropActualAlbumPartition(ctx context.Context, version int32) error {
+	_, err := q.db.Exec(ctx, dropActualAlbumPartition, version)
+	return err
+}
+
+const dropLocalAlbumPartition = `-- name: DropLocalAlbumPartition :exec
+SELECT drop_local_album_partition($1::int)
+`
+
+func (q *Queries) DropLocalAlbumPartition(ctx context.Context, version int32) error {
+	_, err := q.db.Exec(ctx, dropLocalAlbumPartition, version)
+	return err
+}
+
+const getAbandonedActualVersions = `-- name: GetAbandonedActualVersions :many
+SELECT version_id
+FROM actual_version
+WHERE status IN ('failed', 'aborted')
+	OR (
+		status = 'building'
+		AND version_id < (
+			SELECT coalesce(max(version_id), 0)
+			FROM actual_version
+			WHERE status = 'published'
+		)
+	)
+`
+
+func (q *Queries) GetAbandonedActualVersions(ctx context.Context) ([]int32, error) {
+	rows, err := q.db.Query(ctx, getAbandonedActualVersions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int32
+	for rows.Next() {
+		var version_id int32
+		if err := rows.Scan(&version_id); err != nil {
+			return nil, err
+		}
+		items = append(items, version_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAbandonedLocalVersions = `-- name: GetAbandonedLocalVersions :many
+SELECT version_id
+FROM local_version
+WHERE status IN ('failed', 'aborted')
+	OR (
+		status = 'building'
+		AND version_id < (
+			SELECT coalesce(max(version_id), 0)
+			FROM local_version
+			WHERE status = 'published'
+		)
+	)
+`
+
+func (q *Queries) GetAbandonedLocalVersions(ctx context.Context) ([]int32, error) {
+	rows, err := q.db.Query(ctx, getAbandonedLocalVersions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int32
+	for rows.Next() {
+		var version_id int32
+		if err := rows.Scan(&version_id); err != nil {
+			return nil, err
+		}
+		items = append(items, version_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getActualAlbumIDs = `-- name: GetActualAlbumIDs :many
+SELECT id
+FROM actual_album
+WHERE version_id = $1::int
+`
+
+func (q *Queries) GetActualAlbumIDs(ctx context.Context, version int32) ([]string, error) {
+	rows, err := q.db.Query(ctx, getActualAlbumIDs, version)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getActualAlbums = `-- name: GetActualAlbums :many
-SELECT id, artist, name, year, kind, version_id, url
+SELECT id, artist, name, year, kind, version_id, url, release_group, tracks, credit, credited
 FROM actual_album_published
 `
 
@@ -81,6 +391,10 @@ func (q *Queries) GetActualAlbums(ctx context.Context) ([]ActualAlbumPublished,
 			&i.Kind,
 			&i.VersionID,
 			&i.Url,
+			&i.ReleaseGroup,
+			&i.Tracks,
+			&i.Credit,
+			&i.Credited,
 		); err != nil {
 			return nil, err
 		}
@@ -92,20 +406,46 @@ func (q *Queries) GetActualAlbums(ctx context.Context) ([]ActualAlbumPublished,
 	return items, nil
 }
 
+const getActualVersion = `-- name: GetActualVersion :one
+SELECT version_id, created_at, status, last_artist, pinned
+FROM actual_version
+WHERE version_id = $1::int
+`
+
+func (q *Queries) GetActualVersion(ctx context.Context, version int32) (ActualVersion, error) {
+	row := q.db.QueryRow(ctx, getActualVersion, version)
+	var i ActualVersion
+	err := row.Scan(
+		&i.VersionID,
+		&i.CreatedAt,
+		&i.Status,
+		&i.LastArtist,
+		&i.Pinned,
+	)
+	return i, err
+}
+
 const getAll = `-- name: GetAll :many
 SELECT value,
 	id
 FROM cache
 WHERE entity = $1
+	AND ts >= $2
+	AND NOT negative
 `
 
+type GetAllParams struct {
+	Entity string
+	Ts     pgtype.Timestamptz
+}
+
 type GetAllRow struct {
 	Value []byte
 	ID    string
 }
 
-func (q *Queries) GetAll(ctx context.Context, entity string) ([]GetAllRow, error) {
-	rows, err := q.db.Query(ctx, getAll, entity)
+func (q *Queries) GetAll(ctx context.Context, arg GetAllParams) ([]GetAllRow, error) {
+	rows, err := q.db.Query(ctx, getAll, arg.Entity, arg.Ts)
 	if err != nil {
 		return nil, err
 	}
@@ -124,25 +464,128 @@ func (q *Queries) GetAll(ctx context.Context, entity string) ([]GetAllRow, error
 	return items, nil
 }
 
+const getArtistAliases = `-- name: GetArtistAliases :many
+SELECT artist,
+	alias
+FROM artist_alias
+`
+
+func (q *Queries) GetArtistAliases(ctx context.Context) ([]ArtistAlias, error) {
+	rows, err := q.db.Query(ctx, getArtistAliases)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ArtistAlias
+	for rows.Next() {
+		var i ArtistAlias
+		if err := rows.Scan(&i.Artist, &i.Alias); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getArtistSettings = `-- name: GetArtistSettings :many
+SELECT artist,
+	notification,
+	since,
+	collaborations
+FROM artist_setting
+ORDER BY artist
+`
+
+func (q *Queries) GetArtistSettings(ctx context.Context) ([]ArtistSetting, error) {
+	rows, err := q.db.Query(ctx, getArtistSettings)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ArtistSetting
+	for rows.Next() {
+		var i ArtistSetting
+		if err := rows.Scan(
+			&i.Artist,
+			&i.Notification,
+			&i.Since,
+			&i.Collaborations,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getCache = `-- name: GetCache :one
-SELECT value
+SELECT value,
+	negative,
+	ts
 FROM cache
 WHERE entity = $1
 	AND id = $2
-	AND ts >= $3
 `
 
 type GetCacheParams struct {
 	Entity string
 	ID     string
-	Ts     pgtype.Timestamp
 }
 
-func (q *Queries) GetCache(ctx context.Context, arg GetCacheParams) ([]byte, error) {
-	row := q.db.QueryRow(ctx, getCache, arg.Entity, arg.ID, arg.Ts)
-	var value []byte
-	err := row.Scan(&value)
-	return value, err
+type GetCacheRow struct {
+	Value    []byte
+	Negative bool
+	Ts       pgtype.Timestamptz
+}
+
+func (q *Queries) GetCache(ctx context.Context, arg GetCacheParams) (GetCacheRow, error) {
+	row := q.db.QueryRow(ctx, getCache, arg.Entity, arg.ID)
+	var i GetCacheRow
+	err := row.Scan(&i.Value, &i.Negative, &i.Ts)
+	return i, err
+}
+
+const getCacheRecords = `-- name: GetCacheRecords :many
+SELECT entity,
+	id,
+	value,
+	ts,
+	negative
+FROM cache
+WHERE entity = $1
+ORDER BY id
+`
+
+func (q *Queries) GetCacheRecords(ctx context.Context, entity string) ([]Cache, error) {
+	rows, err := q.db.Query(ctx, getCacheRecords, entity)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Cache
+	for rows.Next() {
+		var i Cache
+		if err := rows.Scan(
+			&i.Entity,
+			&i.ID,
+			&i.Value,
+			&i.Ts,
+			&i.Negative,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
 const getExcludedAlbums = `-- name: GetExcludedAlbums :many
@@ -197,7 +640,7 @@ func (q *Queries) GetExcludedArtists(ctx context.Context) ([]string, error) {
 }
 
 const getLocalAlbums = `-- name: GetLocalAlbums :many
-SELECT artist, name, version_id
+SELECT artist, name, version_id, tracks
 FROM local_album_published
 `
 
@@ -210,7 +653,12 @@ func (q *Queries) GetLocalAlbums(ctx context.Context) ([]LocalAlbumPublished, er
 	var items []LocalAlbumPublished
 	for rows.Next() {
 		var i LocalAlbumPublished
-		if err := rows.Scan(&i.Artist, &i.Name, &i.VersionID); err != nil {
+		if err := rows.Scan(
+			&i.Artist,
+			&i.Name,
+			&i.VersionID,
+			&i.Tracks,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -246,72 +694,375 @@ func (q *Queries) GetLocalArtists(ctx context.Context) ([]string, error) {
 	return items, nil
 }
 
-const insertActualAlbum = `-- name: InsertActualAlbum :exec
-INSERT INTO actual_album (id, artist, name, year, kind, version_id, url)
-VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT DO NOTHING
+const getLocalVersion = `-- name: GetLocalVersion :one
+SELECT version_id, created_at, status, pinned
+FROM local_version
+WHERE version_id = $1::int
 `
 
-type InsertActualAlbumParams struct {
-	ID        string
-	Artist    *string
-	Name      *string
-	Year      *int32
-	Kind      *string
-	VersionID int32
-	Url       *string
+func (q *Queries) GetLocalVersion(ctx context.Context, version int32) (LocalVersion, error) {
+	row := q.db.QueryRow(ctx, getLocalVersion, version)
+	var i LocalVersion
+	err := row.Scan(
+		&i.VersionID,
+		&i.CreatedAt,
+		&i.Status,
+		&i.Pinned,
+	)
+	return i, err
 }
 
-func (q *Queries) InsertActualAlbum(ctx context.Context, arg InsertActualAlbumParams) error {
-	_, err := q.db.Exec(ctx, insertActualAlbum,
+const getMatchOverrides = `-- name: GetMatchOverrides :many
+SELECT local_artist,
+	local_album,
+	actual_id
+FROM match_override
+`
+
+func (q *Queries) GetMatchOverrides(ctx context.Context) ([]MatchOverride, error) {
+	rows, err := q.db.Query(ctx, getMatchOverrides)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []MatchOverride
+	for rows.Next() {
+		var i MatchOverride
+		if err := rows.Scan(&i.LocalArtist, &i.LocalAlbum, &i.ActualID); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRecentArtists = `-- name: GetRecentArtists :many
+SELECT DISTINCT artist
+FROM actual_album_published
+WHERE year >= $1
+	AND artist IS NOT NULL
+`
+
+func (q *Queries) GetRecentArtists(ctx context.Context, year *int32) ([]*string, error) {
+	rows, err := q.db.Query(ctx, getRecentArtists, year)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []*string
+	for rows.Next() {
+		var artist *string
+		if err := rows.Scan(&artist); err != nil {
+			return nil, err
+		}
+		items = append(items, artist)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getReleaseStates = `-- name: GetReleaseStates :many
+SELECT actual_id,
+	state,
+	until
+FROM release_state
+`
+
+func (q *Queries) GetReleaseStates(ctx context.Context) ([]ReleaseState, error) {
+	rows, err := q.db.Query(ctx, getReleaseStates)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ReleaseState
+	for rows.Next() {
+		var i ReleaseState
+		if err := rows.Scan(&i.ActualID, &i.State, &i.Until); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getResumableActualVersion = `-- name: GetResumableActualVersion :one
+SELECT version_id,
+	created_at,
+	status,
+	last_artist,
+	pinned
+FROM actual_version
+WHERE status IN ('aborted', 'failed')
+	AND version_id = (
+		SELECT max(version_id)
+		FROM actual_version
+	)
+`
+
+func (q *Queries) GetResumableActualVersion(ctx context.Context) (ActualVersion, error) {
+	row := q.db.QueryRow(ctx, getResumableActualVersion)
+	var i ActualVersion
+	err := row.Scan(
+		&i.VersionID,
+		&i.CreatedAt,
+		&i.Status,
+		&i.LastArtist,
+		&i.Pinned,
+	)
+	return i, err
+}
+
+const getStaleCache = `-- name: GetStaleCache :many
+SELECT id,
+	ts
+FROM cache
+WHERE entity = $1
+	AND ts < $2
+	AND NOT negative
+ORDER BY ts
+LIMIT $3
+`
+
+type GetStaleCacheParams struct {
+	Entity string
+	Ts     pgtype.Timestamptz
+	Limit  int32
+}
+
+type GetStaleCacheRow struct {
+	ID string
+	Ts pgtype.Timestamptz
+}
+
+func (q *Queries) GetStaleCache(ctx context.Context, arg GetStaleCacheParams) ([]GetStaleCacheRow, error) {
+	rows, err := q.db.Query(ctx, getStaleCache, arg.Entity, arg.Ts, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetStaleCacheRow
+	for rows.Next() {
+		var i GetStaleCacheRow
+		if err := rows.Scan(&i.ID, &i.Ts); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const importCache = `-- name: ImportCache :exec
+INSERT INTO cache (entity, id, value, negative, ts)
+VALUES ($1, $2, $3, $4, $5) ON CONFLICT (entity, id) DO
+UPDATE
+SET value = EXCLUDED.value,
+	negative = EXCLUDED.negative,
+	ts = EXCLUDED.ts
+WHERE cache.ts IS NULL
+	OR cache.ts < EXCLUDED.ts
+`
+
+type ImportCacheParams struct {
+	Entity   string
+	ID       string
+	Value    []byte
+	Negative bool
+	Ts       pgtype.Timestamptz
+}
+
+func (q *Queries) ImportCache(ctx context.Context, arg ImportCacheParams) error {
+	_, err := q.db.Exec(ctx, importCache,
+		arg.Entity,
 		arg.ID,
-		arg.Artist,
-		arg.Name,
-		arg.Year,
-		arg.Kind,
-		arg.VersionID,
-		arg.Url,
+		arg.Value,
+		arg.Negative,
+		arg.Ts,
 	)
 	return err
 }
 
+const insertArtistAlias = `-- name: InsertArtistAlias :exec
+INSERT INTO artist_alias (artist, alias)
+VALUES ($1, $2) ON CONFLICT DO NOTHING
+`
+
+type InsertArtistAliasParams struct {
+	Artist string
+	Alias  string
+}
+
+func (q *Queries) InsertArtistAlias(ctx context.Context, arg InsertArtistAliasParams) error {
+	_, err := q.db.Exec(ctx, insertArtistAlias, arg.Artist, arg.Alias)
+	return err
+}
+
 const insertCache = `-- name: InsertCache :exec
-INSERT INTO cache (entity, id, value)
-VALUES ($1, $2, $3) ON CONFLICT (entity, id) DO
+INSERT INTO cache (entity, id, value, negative)
+VALUES ($1, $2, $3, $4) ON CONFLICT (entity, id) DO
 UPDATE
 SET value = EXCLUDED.value,
+	negative = EXCLUDED.negative,
 	ts = CURRENT_TIMESTAMP
 `
 
 type InsertCacheParams struct {
-	Entity string
-	ID     string
-	Value  []byte
+	Entity   string
+	ID       string
+	Value    []byte
+	Negative bool
 }
 
 func (q *Queries) InsertCache(ctx context.Context, arg InsertCacheParams) error {
-	_, err := q.db.Exec(ctx, insertCache, arg.Entity, arg.ID, arg.Value)
+	_, err := q.db.Exec(ctx, insertCache,
+		arg.Entity,
+		arg.ID,
+		arg.Value,
+		arg.Negative,
+	)
 	return err
 }
 
-const insertLocalAlbum = `-- name: InsertLocalAlbum :exec
-INSERT INTO local_album (artist, name, version_id)
-VALUES ($1, $2, $3) ON CONFLICT DO NOTHING
+const listActualVersions = `-- name: ListActualVersions :many
+SELECT version_id, created_at, status, last_artist, pinned
+FROM actual_version
+ORDER BY version_id DESC
+LIMIT $1
 `
 
-type InsertLocalAlbumParams struct {
-	Artist    string
-	Name      string
-	VersionID int32
+func (q *Queries) ListActualVersions(ctx context.Context, limit int32) ([]ActualVersion, error) {
+	rows, err := q.db.Query(ctx, listActualVersions, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ActualVersion
+	for rows.Next() {
+		var i ActualVersion
+		if err := rows.Scan(
+			&i.VersionID,
+			&i.CreatedAt,
+			&i.Status,
+			&i.LastArtist,
+			&i.Pinned,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCache = `-- name: ListCache :many
+SELECT entity,
+	id,
+	ts,
+	negative
+FROM cache
+ORDER BY entity,
+	id
+`
+
+type ListCacheRow struct {
+	Entity   string
+	ID       string
+	Ts       pgtype.Timestamptz
+	Negative bool
+}
+
+func (q *Queries) ListCache(ctx context.Context) ([]ListCacheRow, error) {
+	rows, err := q.db.Query(ctx, listCache)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListCacheRow
+	for rows.Next() {
+		var i ListCacheRow
+		if err := rows.Scan(
+			&i.Entity,
+			&i.ID,
+			&i.Ts,
+			&i.Negative,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-func (q *Queries) InsertLocalAlbum(ctx context.Context, arg InsertLocalAlbumParams) error {
-	_, err := q.db.Exec(ctx, insertLocalAlbum, arg.Artist, arg.Name, arg.VersionID)
+const listLocalVersions = `-- name: ListLocalVersions :many
+SELECT version_id, created_at, status, pinned
+FROM local_version
+ORDER BY version_id DESC
+LIMIT $1
+`
+
+func (q *Queries) ListLocalVersions(ctx context.Context, limit int32) ([]LocalVersion, error) {
+	rows, err := q.db.Query(ctx, listLocalVersions, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []LocalVersion
+	for rows.Next() {
+		var i LocalVersion
+		if err := rows.Scan(
+			&i.VersionID,
+			&i.CreatedAt,
+			&i.Status,
+			&i.Pinned,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const pinActualVersion = `-- name: PinActualVersion :exec
+UPDATE actual_version
+SET pinned = (version_id = $1::int)
+`
+
+func (q *Queries) PinActualVersion(ctx context.Context, version int32) error {
+	_, err := q.db.Exec(ctx, pinActualVersion, version)
+	return err
+}
+
+const pinLocalVersion = `-- name: PinLocalVersion :exec
+UPDATE local_version
+SET pinned = (version_id = $1::int)
+`
+
+func (q *Queries) PinLocalVersion(ctx context.Context, version int32) error {
+	_, err := q.db.Exec(ctx, pinLocalVersion, version)
 	return err
 }
 
 const publishActualVersion = `-- name: PublishActualVersion :exec
 UPDATE actual_version
-SET published = TRUE
+SET status = 'published'
 WHERE version_id = $1::int
 `
 
@@ -322,7 +1073,7 @@ func (q *Queries) PublishActualVersion(ctx context.Context, version int32) error
 
 const publishLocalVersion = `-- name: PublishLocalVersion :exec
 UPDATE local_version
-SET published = TRUE
+SET status = 'published'
 WHERE version_id = $1::int
 `
 
@@ -330,3 +1081,160 @@ func (q *Queries) PublishLocalVersion(ctx context.Context, version int32) error
 	_, err := q.db.Exec(ctx, publishLocalVersion, version)
 	return err
 }
+
+const setActualVersionStatus = `-- name: SetActualVersionStatus :exec
+UPDATE actual_version
+SET status = $1
+WHERE version_id = $2::int
+`
+
+type SetActualVersionStatusParams struct {
+	Status  string
+	Version int32
+}
+
+func (q *Queries) SetActualVersionStatus(ctx context.Context, arg SetActualVersionStatusParams) error {
+	_, err := q.db.Exec(ctx, setActualVersionStatus, arg.Status, arg.Version)
+	return err
+}
+
+const setLocalVersionStatus = `-- name: SetLocalVersionStatus :exec
+UPDATE local_version
+SET status = $1
+WHERE version_id = $2::int
+`
+
+type SetLocalVersionStatusParams struct {
+	Status  string
+	Version int32
+}
+
+func (q *Queries) SetLocalVersionStatus(ctx context.Context, arg SetLocalVersionStatusParams) error {
+	_, err := q.db.Exec(ctx, setLocalVersionStatus, arg.Status, arg.Version)
+	return err
+}
+
+const unpinActualVersions = `-- name: UnpinActualVersions :exec
+UPDATE actual_version
+SET pinned = FALSE
+WHERE pinned
+`
+
+func (q *Queries) UnpinActualVersions(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, unpinActualVersions)
+	return err
+}
+
+const unpinLocalVersions = `-- name: UnpinLocalVersions :exec
+UPDATE local_version
+SET pinned = FALSE
+WHERE pinned
+`
+
+func (q *Queries) UnpinLocalVersions(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, unpinLocalVersions)
+	return err
+}
+
+const unpublishActualVersion = `-- name: UnpublishActualVersion :exec
+UPDATE actual_version
+SET status = 'unpublished',
+	pinned = FALSE
+WHERE version_id = $1::int
+`
+
+func (q *Queries) UnpublishActualVersion(ctx context.Context, version int32) error {
+	_, err := q.db.Exec(ctx, unpublishActualVersion, version)
+	return err
+}
+
+const unpublishLocalVersion = `-- name: UnpublishLocalVersion :exec
+UPDATE local_version
+SET status = 'unpublished',
+	pinned = FALSE
+WHERE version_id = $1::int
+`
+
+func (q *Queries) UnpublishLocalVersion(ctx context.Context, version int32) error {
+	_, err := q.db.Exec(ctx, unpublishLocalVersion, version)
+	return err
+}
+
+const updateActualVersionProgress = `-- name: UpdateActualVersionProgress :exec
+UPDATE actual_version
+SET last_artist = $1
+WHERE version_id = $2::int
+`
+
+type UpdateActualVersionProgressParams struct {
+	LastArtist *string
+	Version    int32
+}
+
+func (q *Queries) UpdateActualVersionProgress(ctx context.Context, arg UpdateActualVersionProgressParams) error {
+	_, err := q.db.Exec(ctx, updateActualVersionProgress, arg.LastArtist, arg.Version)
+	return err
+}
+
+const upsertArtistSetting = `-- name: UpsertArtistSetting :exec
+INSERT INTO artist_setting (artist, notification, since, collaborations)
+VALUES ($1, $2, $3, $4) ON CONFLICT (artist) DO
+UPDATE
+SET notification = EXCLUDED.notification,
+	since = EXCLUDED.since,
+	collaborations = EXCLUDED.collaborations
+`
+
+type UpsertArtistSettingParams struct {
+	Artist         string
+	Notification   string
+	Since          pgtype.Date
+	Collaborations string
+}
+
+func (q *Queries) UpsertArtistSetting(ctx context.Context, arg UpsertArtistSettingParams) error {
+	_, err := q.db.Exec(ctx, upsertArtistSetting,
+		arg.Artist,
+		arg.Notification,
+		arg.Since,
+		arg.Collaborations,
+	)
+	return err
+}
+
+const upsertMatchOverride = `-- name: UpsertMatchOverride :exec
+INSERT INTO match_override (local_artist, local_album, actual_id)
+VALUES ($1, $2, $3) ON CONFLICT (local_artist, local_album) DO
+UPDATE
+SET actual_id = EXCLUDED.actual_id
+`
+
+type UpsertMatchOverrideParams struct {
+	LocalArtist string
+	LocalAlbum  string
+	ActualID    *string
+}
+
+func (q *Queries) UpsertMatchOverride(ctx context.Context, arg UpsertMatchOverrideParams) error {
+	_, err := q.db.Exec(ctx, upsertMatchOverride, arg.LocalArtist, arg.LocalAlbum, arg.ActualID)
+	return err
+}
+
+const upsertReleaseState = `-- name: UpsertReleaseState :exec
+INSERT INTO release_state (actual_id, state, until)
+VALUES ($1, $2, $3) ON CONFLICT (actual_id) DO
+UPDATE
+SET state = EXCLUDED.state,
+	until = EXCLUDED.until
+`
+
+type UpsertReleaseStateParams struct {
+	ActualID string
+	State    string
+	Until    pgtype.Date
+}
+
+func (q *Queries) UpsertReleaseState(ctx context.Context, arg UpsertReleaseStateParams) error {
+	_, err := q.db.Exec(ctx, upsertReleaseState, arg.ActualID, arg.State, arg.Until)
+	return err
+}